@@ -9,7 +9,10 @@ import (
 )
 
 func main() {
-	client := app.NewClient(config.NewConfig())
+	client, err := app.NewClient(config.NewConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
 	if err := client.Run(context.Background()); err != nil {
 		log.Fatal(err)
 	}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"gokeeper/pkg/domain"
 	"log"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -14,6 +15,10 @@ type AuthService interface {
 	Register(ctx context.Context, user domain.InUserRequest, saveJWT bool) error
 	Login(ctx context.Context, user domain.InUserRequest, saveJWT bool) (string, error)
 	GetJwt(ctx context.Context) (string, error)
+	Logout(ctx context.Context, all bool) error
+	LoginMFA(ctx context.Context, mfaToken string, code string, saveJWT bool) (string, error)
+	EnrollTOTP(ctx context.Context) (domain.TOTPEnrollResponse, error)
+	VerifyTOTP(ctx context.Context, code string) ([]string, error)
 }
 
 type AuthCLI struct {
@@ -34,6 +39,7 @@ func (ac *AuthCLI) GetCommands() []*cobra.Command {
 	}
 	cmdLogin.Flags().String("login", "", "authentication on server")
 	cmdLogin.Flags().String("password", "", "authentication on server")
+	cmdLogin.Flags().String("code", "", "two-factor authentication or recovery code")
 
 	cmdRegister := &cobra.Command{
 		Use:   "register",
@@ -42,7 +48,20 @@ func (ac *AuthCLI) GetCommands() []*cobra.Command {
 	}
 	cmdRegister.Flags().String("login", "", "register on server")
 	cmdRegister.Flags().String("password", "", "register on server")
-	return []*cobra.Command{cmdLogin, cmdRegister}
+
+	cmdLogout := &cobra.Command{
+		Use:   "logout",
+		Short: "Command for logout",
+		Run:   ac.logout,
+	}
+	cmdLogout.Flags().Bool("all", false, "revoke sessions on all devices")
+
+	cmdEnable2FA := &cobra.Command{
+		Use:   "enable-2fa",
+		Short: "Command for enabling two-factor authentication",
+		Run:   ac.enable2FA,
+	}
+	return []*cobra.Command{cmdLogin, cmdRegister, cmdLogout, cmdEnable2FA}
 }
 
 func (ac *AuthCLI) login(cmd *cobra.Command, _ []string) {
@@ -63,13 +82,27 @@ func (ac *AuthCLI) login(cmd *cobra.Command, _ []string) {
 		Password: password,
 	}
 
-	_, err = ac.authService.Login(cmd.Context(), u, true)
+	mfaToken, err := ac.authService.Login(cmd.Context(), u, true)
+	if errors.Is(err, domain.ErrMFARequired) {
+		code, _ := cmd.Flags().GetString("code")
+		if code == "" {
+			fmt.Print("Enter two-factor authentication code: ")
+			fmt.Scanf("%s", &code)
+		}
+		_, err = ac.authService.LoginMFA(cmd.Context(), mfaToken, code, true)
+	}
 	if err != nil {
 		if errors.Is(err, domain.ErrUserAuthentication) {
 			log.Printf("authentication failed")
 			return
 		}
+		var throttled *domain.TooManyRequestsError
+		if errors.As(err, &throttled) {
+			log.Printf("too many failed two-factor attempts, try again in %s", throttled.RetryAfter.Round(time.Second))
+			return
+		}
 		log.Printf("server unavailable, try later")
+		return
 	}
 
 	fmt.Print("Successfully logged in\n")
@@ -104,3 +137,60 @@ func (ac *AuthCLI) register(cmd *cobra.Command, _ []string) {
 
 	fmt.Print("Successfully registered\n")
 }
+
+func (ac *AuthCLI) logout(cmd *cobra.Command, _ []string) {
+	all, _ := cmd.Flags().GetBool("all")
+
+	if err := ac.authService.Logout(cmd.Context(), all); err != nil {
+		if errors.Is(err, domain.ErrUserAuthentication) {
+			log.Printf("session already expired, local tokens removed")
+			return
+		}
+		log.Printf("failed to revoke session on server, local tokens removed: %v", err)
+		return
+	}
+
+	fmt.Print("Successfully logged out\n")
+}
+
+func (ac *AuthCLI) enable2FA(cmd *cobra.Command, _ []string) {
+	enrollment, err := ac.authService.EnrollTOTP(cmd.Context())
+	if err != nil {
+		if errors.Is(err, domain.ErrMFAAlreadyEnabled) {
+			log.Printf("two-factor authentication is already enabled")
+			return
+		}
+		log.Printf("failed to enroll two-factor authentication: %v", err)
+		return
+	}
+
+	fmt.Printf("Add this key to your authenticator app:\n%s\n\nor use the URI:\n%s\n\n", enrollment.Secret, enrollment.URI)
+
+	// The secret is new, so the code can only be asked for once it was
+	// shown. A wrong code is asked for again rather than enrolling a new
+	// secret.
+	var recoveryCodes []string
+	for {
+		var code string
+		fmt.Print("Enter code from authenticator app (empty to cancel): ")
+		fmt.Scanf("%s", &code)
+		if code == "" {
+			log.Printf("two-factor authentication was not enabled")
+			return
+		}
+		recoveryCodes, err = ac.authService.VerifyTOTP(cmd.Context(), code)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, domain.ErrUserAuthentication) {
+			log.Printf("failed to verify two-factor authentication: %v", err)
+			return
+		}
+		log.Printf("invalid code, try again")
+	}
+
+	fmt.Print("Two-factor authentication enabled. Store these recovery codes in a safe place:\n")
+	for _, recoveryCode := range recoveryCodes {
+		fmt.Println(recoveryCode)
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"gokeeper/pkg/domain"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -14,10 +17,26 @@ import (
 
 type PrivateService interface {
 	Save(ctx context.Context, pd domain.Data, inputUser domain.InUserRequest, saveLocalOnError bool) error
-	GetAll(ctx context.Context, gpr domain.GetAllRequest, inputUser domain.InUserRequest) ([]domain.Data, error)
-	Get(ctx context.Context, id string, inputUser domain.InUserRequest) (*domain.Data, error)
-	Delete(ctx context.Context, pd domain.DeleteRequest) error
-	Upload(ctx context.Context) error
+	SaveStream(ctx context.Context, pd domain.Data, r io.Reader, inputUser domain.InUserRequest, saveLocalOnError bool) error
+	GetAll(ctx context.Context, gpr domain.GetAllRequest, inputUser domain.InUserRequest, offline bool) (*domain.Page, error)
+	Open(ctx context.Context, id string, inputUser domain.InUserRequest, offline bool) (*domain.Data, io.Reader, error)
+	Delete(ctx context.Context, pd domain.DeleteRequest, inputUser *domain.InUserRequest, saveLocalOnError bool) error
+	Upload(ctx context.Context, dryRun bool, progress func(done, total int)) (domain.UploadReport, error)
+	Queue() ([]domain.QueueEntry, error)
+	DropQueued(id int64) error
+	ChangePassword(ctx context.Context, inputUser domain.InUserRequest, newPassword string, progress func(done, total int)) error
+	Rekey(ctx context.Context, inputUser domain.InUserRequest, legacy bool, progress func(done, total int)) error
+	History(ctx context.Context, id string, inputUser domain.InUserRequest) ([]domain.Data, error)
+	OpenVersion(ctx context.Context, id string, version int64, inputUser domain.InUserRequest) (*domain.Data, io.Reader, error)
+	Restore(ctx context.Context, id string, version int64, inputUser domain.InUserRequest) (int64, error)
+	Trash(ctx context.Context, inputUser domain.InUserRequest) ([]domain.Data, error)
+	Undelete(ctx context.Context, id string, inputUser domain.InUserRequest) error
+	Sync(
+		ctx context.Context,
+		inputUser domain.InUserRequest,
+		policy domain.ConflictPolicy,
+		prompt func(conflict domain.SyncConflict) domain.ConflictPolicy,
+	) (domain.SyncReport, error)
 }
 
 type PrivateCLI struct {
@@ -36,7 +55,15 @@ func (pc *PrivateCLI) GetCommands() []*cobra.Command {
 		pc.createGetCommand(),
 		pc.createGetAllCommand(),
 		pc.createDeleteCommand(),
+		pc.createTrashCommand(),
+		pc.createUndeleteCommand(),
+		pc.createHistoryCommand(),
+		pc.createRestoreCommand(),
 		pc.createUploadCommand(),
+		pc.createQueueCommand(),
+		pc.createSyncCommand(),
+		pc.createChangePasswordCommand(),
+		pc.createRekeyCommand(),
 	}
 }
 
@@ -50,7 +77,7 @@ func (pc *PrivateCLI) createSaveCommand() *cobra.Command {
 	addCommonAuthFlags(cmd)
 	cmd.Flags().String("type", "", "Data type")
 	cmd.Flags().String("id", "", "Data key")
-	cmd.Flags().String("meta", "", "Meta information (not encrypted)")
+	cmd.Flags().String("meta", "", "Meta information (encrypted unless CLI_ENCRYPT_META is off)")
 	cmd.Flags().Bool("save-local-on-error", false, "Save locally if server unavailable")
 
 	// Data-specific flags
@@ -76,6 +103,7 @@ func (pc *PrivateCLI) createGetCommand() *cobra.Command {
 	addCommonAuthFlags(cmd)
 	cmd.Flags().String("id", "", "Data key")
 	cmd.Flags().String("output", "", "Output file")
+	cmd.Flags().Bool("offline", false, "Read the local copy without contacting the server")
 
 	return cmd
 }
@@ -88,9 +116,15 @@ func (pc *PrivateCLI) createGetAllCommand() *cobra.Command {
 	}
 
 	addCommonAuthFlags(cmd)
-	cmd.Flags().Uint64("limit", 10, "Number of elements")
-	cmd.Flags().Uint64("offset", 0, "Page number")
+	cmd.Flags().Uint64("limit", 10, "Number of elements per page")
+	cmd.Flags().String("cursor", "", "Cursor of the page to list, as returned in next_cursor")
+	cmd.Flags().String("order", "", "Order of the records: id (default), saved_at or type")
+	cmd.Flags().String("type", "", "Only records of this type: auth, card, text or file")
+	cmd.Flags().String("id-prefix", "", "Only records whose id starts with this prefix")
+	cmd.Flags().String("search", "", "Only records whose meta information contains this word")
+	cmd.Flags().Bool("all", false, "List the records of all pages")
 	cmd.Flags().String("output", "", "Output file")
+	cmd.Flags().Bool("offline", false, "Read the local copy without contacting the server")
 
 	return cmd
 }
@@ -98,21 +132,140 @@ func (pc *PrivateCLI) createGetAllCommand() *cobra.Command {
 func (pc *PrivateCLI) createDeleteCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "delete",
-		Short: "Delete private data",
+		Short: "Move private data to the trash",
 		Run:   pc.delete,
 	}
 
+	addCommonAuthFlags(cmd)
+	cmd.Flags().String("id", "", "Data key")
+	cmd.Flags().Bool("save-local-on-error", false, "Queue the deletion if the server is unavailable")
+
+	return cmd
+}
+
+func (pc *PrivateCLI) createTrashCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trash",
+		Short: "List deleted private data",
+		Run:   pc.trash,
+	}
+
+	addCommonAuthFlags(cmd)
+
+	return cmd
+}
+
+func (pc *PrivateCLI) createUndeleteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "undelete",
+		Short: "Take private data out of the trash",
+		Run:   pc.undelete,
+	}
+
+	addCommonAuthFlags(cmd)
 	cmd.Flags().String("id", "", "Data key")
 
 	return cmd
 }
 
+func (pc *PrivateCLI) createHistoryCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List the versions of private data, or get one of them",
+		Run:   pc.history,
+	}
+
+	addCommonAuthFlags(cmd)
+	cmd.Flags().String("id", "", "Data key")
+	cmd.Flags().Int64("version", 0, "Version to get instead of listing all")
+	cmd.Flags().String("output", "", "Output file")
+
+	return cmd
+}
+
+func (pc *PrivateCLI) createRestoreCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore an earlier version of private data",
+		Run:   pc.restore,
+	}
+
+	addCommonAuthFlags(cmd)
+	cmd.Flags().String("id", "", "Data key")
+	cmd.Flags().Int64("version", 0, "Version to restore")
+
+	return cmd
+}
+
 func (pc *PrivateCLI) createUploadCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "upload",
-		Short: "Upload private data",
+		Short: "Upload locally saved private data and resume interrupted file uploads",
 		Run:   pc.upload,
 	}
+
+	cmd.Flags().Bool("dry-run", false, "Only list the changes that would be uploaded")
+
+	return cmd
+}
+
+func (pc *PrivateCLI) createQueueCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "queue",
+		Short: "Manage private data changes waiting to be uploaded",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the changes waiting to be uploaded",
+		Run:   pc.queueList,
+	}, &cobra.Command{
+		Use:   "drop <id>",
+		Short: "Remove a change from the queue without uploading it",
+		Args:  cobra.ExactArgs(1),
+		Run:   pc.queueDrop,
+	})
+
+	return cmd
+}
+
+func (pc *PrivateCLI) createSyncCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Update the local replica from the server and upload locally saved private data",
+		Run:   pc.sync,
+	}
+
+	addCommonAuthFlags(cmd)
+	cmd.Flags().String("policy", "", "Conflict policy: server-wins, local-wins, keep-both or prompt (CLI_SYNC_POLICY by default)")
+
+	return cmd
+}
+
+func (pc *PrivateCLI) createChangePasswordCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "change-password",
+		Short: "Change account password",
+		Run:   pc.changePassword,
+	}
+
+	addCommonAuthFlags(cmd)
+	cmd.Flags().String("new-password", "", "New account password")
+
+	return cmd
+}
+
+func (pc *PrivateCLI) createRekeyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rekey",
+		Short: "Re-encrypt records in the newest encryption format",
+		Run:   pc.rekey,
+	}
+
+	addCommonAuthFlags(cmd)
+	cmd.Flags().Bool("legacy", false, "Also migrate records of the baseline format, which the server could swap for others")
+
+	return cmd
 }
 
 func addCommonAuthFlags(cmd *cobra.Command) {
@@ -132,9 +285,6 @@ var dataHandlers = map[domain.Type]dataHandler{
 	domain.TEXT: func(cmd *cobra.Command) ([]byte, error) {
 		return handleTextData(cmd)
 	},
-	domain.BYTES: func(cmd *cobra.Command) ([]byte, error) {
-		return handleFileData(cmd, true)
-	},
 }
 
 func (pc *PrivateCLI) save(cmd *cobra.Command, _ []string) {
@@ -152,29 +302,25 @@ func (pc *PrivateCLI) save(cmd *cobra.Command, _ []string) {
 		return
 	}
 
-	handler, exists := dataHandlers[dataType]
-	if !exists {
-		pc.handleError(fmt.Errorf("unsupported data type: %s", dataType))
-		return
-	}
-
-	data, err := handler(cmd)
-	if err != nil {
-		pc.handleError(err)
-		return
-	}
-
 	pd := domain.Data{
 		ID:       id,
 		DataType: dataType,
 		MetaData: []byte(metaDataStr),
-		Data:     data,
 		SavedAt:  time.Now(),
 	}
 
-	if err := pc.privateService.Save(ctx, pd, *u, saveLocalOnError); err != nil {
+	var err error
+	if dataType == domain.BYTES {
+		err = pc.saveFile(ctx, cmd, pd, *u, saveLocalOnError)
+	} else {
+		err = pc.saveData(ctx, cmd, pd, *u, saveLocalOnError)
+	}
+	if err != nil {
 		if errors.Is(err, domain.WarnServerUnavailable) {
-			fmt.Println("Your data was saved locally, try command \"upload\" for uploading your data to the server")
+			fmt.Println("Your data was saved locally, try command \"sync\" for uploading your data to the server")
+			return
+		}
+		if pc.handleRevisionError(err, id, "save") {
 			return
 		}
 		pc.handleError(err)
@@ -184,18 +330,56 @@ func (pc *PrivateCLI) save(cmd *cobra.Command, _ []string) {
 	fmt.Println("Your data was successfully saved")
 }
 
+func (pc *PrivateCLI) saveData(
+	ctx context.Context,
+	cmd *cobra.Command,
+	pd domain.Data,
+	u domain.InUserRequest,
+	saveLocalOnError bool,
+) error {
+	handler, exists := dataHandlers[pd.DataType]
+	if !exists {
+		return fmt.Errorf("unsupported data type: %d", pd.DataType)
+	}
+
+	data, err := handler(cmd)
+	if err != nil {
+		return err
+	}
+	pd.Data = data
+	return pc.privateService.Save(ctx, pd, u, saveLocalOnError)
+}
+
+// saveFile streams the file into the record instead of reading it at once.
+func (pc *PrivateCLI) saveFile(
+	ctx context.Context,
+	cmd *cobra.Command,
+	pd domain.Data,
+	u domain.InUserRequest,
+	saveLocalOnError bool,
+) error {
+	f, err := os.Open(getFilePath(cmd, true))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return pc.privateService.SaveStream(ctx, pd, f, u, saveLocalOnError)
+}
+
 func (pc *PrivateCLI) get(cmd *cobra.Command, _ []string) {
 	ctx := cmd.Context()
 	u := pc.authenticate(cmd)
 	id := getInputString(cmd, "id", "Enter id: ")
+	offline, _ := cmd.Flags().GetBool("offline")
 
-	data, err := pc.privateService.Get(ctx, id, *u)
-	if err != nil {
+	data, r, err := pc.privateService.Open(ctx, id, *u, offline)
+	if err = pc.handleStale(err); err != nil {
 		pc.handleGetError(err, id)
 		return
 	}
 
-	if err := pc.handleOutput(cmd, data); err != nil {
+	if err := pc.handleOutput(cmd, data, r); err != nil {
 		pc.handleError(err)
 	}
 }
@@ -205,15 +389,41 @@ func (pc *PrivateCLI) getAll(cmd *cobra.Command, _ []string) {
 	u := pc.authenticate(cmd)
 
 	limit, _ := cmd.Flags().GetUint64("limit")
-	offset, _ := cmd.Flags().GetUint64("offset")
+	cursor, _ := cmd.Flags().GetString("cursor")
+	order, _ := cmd.Flags().GetString("order")
+	dataTypeStr, _ := cmd.Flags().GetString("type")
+	idPrefix, _ := cmd.Flags().GetString("id-prefix")
+	search, _ := cmd.Flags().GetString("search")
+	all, _ := cmd.Flags().GetBool("all")
+	offline, _ := cmd.Flags().GetBool("offline")
+
+	gpr := domain.GetAllRequest{Limit: limit, Cursor: cursor, IDPrefix: idPrefix, Search: search}
+	var err error
+	if gpr.Order, err = domain.ParseOrder(order); err != nil {
+		pc.handleError(err)
+		return
+	}
+	if dataTypeStr != "" {
+		dataType := parseType(dataTypeStr)
+		if dataType == domain.UNKNOWN {
+			pc.handleError(fmt.Errorf("invalid data type"))
+			return
+		}
+		gpr.Type = &dataType
+	}
 
-	data, err := pc.privateService.GetAll(ctx, domain.GetAllRequest{Limit: limit, Offset: offset}, *u)
-	if err != nil {
+	var page *domain.Page
+	if all {
+		page, err = pc.getAllPages(ctx, gpr, *u, offline)
+	} else {
+		page, err = pc.privateService.GetAll(ctx, gpr, *u, offline)
+	}
+	if err = pc.handleStale(err); err != nil {
 		pc.handleError(err)
 		return
 	}
 
-	resBytes, err := json.Marshal(data)
+	resBytes, err := json.Marshal(page)
 	if err != nil {
 		pc.handleError(fmt.Errorf("marshaling error: %w", err))
 		return
@@ -224,24 +434,299 @@ func (pc *PrivateCLI) getAll(cmd *cobra.Command, _ []string) {
 	}
 }
 
+// getAllPages walks the pages of a listing from the cursor of gpr on and
+// returns their records as one page. A stale page is reported once, after
+// the last one.
+func (pc *PrivateCLI) getAllPages(
+	ctx context.Context,
+	gpr domain.GetAllRequest,
+	inputUser domain.InUserRequest,
+	offline bool,
+) (*domain.Page, error) {
+	all := &domain.Page{Records: []domain.Data{}}
+	var stale *domain.StaleDataWarning
+	for {
+		page, err := pc.privateService.GetAll(ctx, gpr, inputUser, offline)
+		if !errors.As(err, &stale) && err != nil {
+			return nil, err
+		}
+		all.Records = append(all.Records, page.Records...)
+		all.Total = page.Total
+		if page.NextCursor == "" {
+			break
+		}
+		gpr.Cursor = page.NextCursor
+	}
+	if stale != nil {
+		return all, stale
+	}
+	return all, nil
+}
+
 func (pc *PrivateCLI) delete(cmd *cobra.Command, _ []string) {
 	ctx := cmd.Context()
+	saveLocalOnError, _ := cmd.Flags().GetBool("save-local-on-error")
+	// The vault key is needed to look up the revision the deletion is
+	// based on.
+	u := pc.authenticate(cmd)
 	id := getInputString(cmd, "id", "Enter id: ")
 
-	if err := pc.privateService.Delete(ctx, domain.DeleteRequest{ID: id, DeletedAt: time.Now()}); err != nil {
+	if err := pc.privateService.Delete(ctx, domain.DeleteRequest{ID: id, DeletedAt: time.Now()}, u, saveLocalOnError); err != nil {
+		if errors.Is(err, domain.WarnServerUnavailable) {
+			fmt.Println("Your deletion was queued locally, try command \"sync\" for sending it to the server")
+			return
+		}
+		if pc.handleRevisionError(err, id, "delete") {
+			return
+		}
+		pc.handleError(err)
+		return
+	}
+
+	fmt.Printf("Your data with id %s was moved to the trash\n", id)
+}
+
+func (pc *PrivateCLI) trash(cmd *cobra.Command, _ []string) {
+	u := pc.authenticate(cmd)
+
+	data, err := pc.privateService.Trash(cmd.Context(), *u)
+	if err != nil {
 		pc.handleError(err)
 		return
 	}
+	for _, pd := range data {
+		fmt.Printf("%s\t%s\t%s\n", pd.ID, pd.DeletedAt.Local().Format(time.DateTime), pd.MetaData)
+	}
+}
+
+func (pc *PrivateCLI) undelete(cmd *cobra.Command, _ []string) {
+	u := pc.authenticate(cmd)
+	id := getInputString(cmd, "id", "Enter id: ")
+
+	if err := pc.privateService.Undelete(cmd.Context(), id, *u); err != nil {
+		if errors.Is(err, domain.ErrRevisionRequired) {
+			fmt.Printf("The trash was not listed here since your data with id %s was deleted, try command \"trash\" and undelete again\n", id)
+			return
+		}
+		if errors.Is(err, domain.ErrPrivateDataConflict) {
+			fmt.Printf("Your data with id %s was changed elsewhere in the meantime, try command \"trash\" and undelete again\n", id)
+			return
+		}
+		pc.handleGetError(err, id)
+		return
+	}
 
-	fmt.Printf("Your data with id %s was successfully deleted\n", id)
+	fmt.Printf("Your data with id %s was restored from the trash\n", id)
+}
+
+func (pc *PrivateCLI) history(cmd *cobra.Command, _ []string) {
+	ctx := cmd.Context()
+	u := pc.authenticate(cmd)
+	id := getInputString(cmd, "id", "Enter id: ")
+
+	if version, _ := cmd.Flags().GetInt64("version"); version != 0 {
+		data, r, err := pc.privateService.OpenVersion(ctx, id, version, *u)
+		if err != nil {
+			pc.handleGetError(err, id)
+			return
+		}
+		if err := pc.handleOutput(cmd, data, r); err != nil {
+			pc.handleError(err)
+		}
+		return
+	}
+
+	versions, err := pc.privateService.History(ctx, id, *u)
+	if err != nil {
+		pc.handleGetError(err, id)
+		return
+	}
+	for _, v := range versions {
+		fmt.Printf("%d\t%s\t%s\n", v.Version, v.SavedAt.Local().Format(time.DateTime), v.MetaData)
+	}
+}
+
+func (pc *PrivateCLI) restore(cmd *cobra.Command, _ []string) {
+	ctx := cmd.Context()
+	u := pc.authenticate(cmd)
+	id := getInputString(cmd, "id", "Enter id: ")
+	version := getInputInt64(cmd, "version", "Enter version: ")
+
+	restored, err := pc.privateService.Restore(ctx, id, version, *u)
+	if err != nil {
+		if pc.handleRevisionError(err, id, "restore") {
+			return
+		}
+		pc.handleGetError(err, id)
+		return
+	}
+
+	fmt.Printf("Version %d of your data with id %s was restored as version %d\n", version, id, restored)
 }
 
 func (pc *PrivateCLI) upload(cmd *cobra.Command, _ []string) {
-	if err := pc.privateService.Upload(cmd.Context()); err != nil {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	report, err := pc.privateService.Upload(cmd.Context(), dryRun, printProgress("Uploading"))
+	if dryRun {
+		for _, entry := range report.Changes {
+			fmt.Printf("%d\t%s\t%s\n", entry.ID, entry.Op, entry.Data.ID)
+		}
+	} else {
+		if tried := len(report.Succeeded) + len(report.Failed); tried > 0 && tried < len(report.Changes) {
+			// The progress line was left unfinished.
+			fmt.Println()
+		}
+		printUploadReport(report)
+	}
+	if err != nil {
+		pc.handleError(err)
+	}
+}
+
+func printUploadReport(report domain.UploadReport) {
+	if len(report.Changes) == 0 {
+		fmt.Println("Nothing to upload")
+		return
+	}
+	fmt.Printf("Uploaded %d of %d changes\n", len(report.Succeeded), len(report.Changes))
+	if len(report.Succeeded) > 0 {
+		ids := make([]string, len(report.Succeeded))
+		for i, entry := range report.Succeeded {
+			ids[i] = entry.Data.ID
+		}
+		fmt.Printf("Succeeded: %s\n", strings.Join(ids, ", "))
+	}
+	for _, entry := range report.Failed {
+		fmt.Printf("Failed: %s (queue entry %d): %s\n", entry.Data.ID, entry.ID, entry.LastError)
+	}
+	if left := len(report.Changes) - len(report.Succeeded) - len(report.Failed); left > 0 {
+		fmt.Printf("Not tried: %d, see command \"queue list\"\n", left)
+	}
+}
+
+func (pc *PrivateCLI) queueList(_ *cobra.Command, _ []string) {
+	entries, err := pc.privateService.Queue()
+	if err != nil {
+		pc.handleError(err)
+		return
+	}
+	for _, entry := range entries {
+		fmt.Printf("%d\t%s\t%s\t%s\t%d attempts",
+			entry.ID, entry.Op, entry.Data.ID, entry.QueuedAt.Local().Format(time.DateTime), entry.Attempts)
+		if entry.LastError != "" {
+			fmt.Printf("\t%s", entry.LastError)
+		}
+		fmt.Println()
+	}
+}
+
+func (pc *PrivateCLI) queueDrop(_ *cobra.Command, args []string) {
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		pc.handleError(fmt.Errorf("invalid queue entry id %q", args[0]))
+		return
+	}
+
+	if err = pc.privateService.DropQueued(id); err != nil {
+		if errors.Is(err, domain.ErrQueueEntryNotFound) {
+			fmt.Printf("Queue entry %d was not found\n", id)
+			return
+		}
 		pc.handleError(err)
 		return
 	}
-	fmt.Println("Your data was successfully uploaded")
+	fmt.Printf("Queue entry %d was dropped\n", id)
+}
+
+func (pc *PrivateCLI) sync(cmd *cobra.Command, _ []string) {
+	u := pc.authenticate(cmd)
+
+	var policy domain.ConflictPolicy
+	if name, _ := cmd.Flags().GetString("policy"); name != "" {
+		var err error
+		if policy, err = domain.ParseConflictPolicy(name); err != nil {
+			pc.handleError(err)
+			return
+		}
+	}
+
+	report, err := pc.privateService.Sync(cmd.Context(), *u, policy, promptConflict)
+	if err != nil {
+		pc.handleError(err)
+		return
+	}
+	fmt.Printf(
+		"Pulled %d changes, pushed %d, %d conflicts with %d local changes discarded, %d left for the next sync\n",
+		report.Pulled, report.Pushed, report.Conflicts, report.Discarded, report.Pending,
+	)
+}
+
+// promptConflict asks which side of a conflict to keep. Without an answer
+// both are kept, so nothing is lost.
+func promptConflict(conflict domain.SyncConflict) domain.ConflictPolicy {
+	server := "changed at " + conflict.Server.SavedAt.Local().Format(time.DateTime)
+	if conflict.Server.DeletedAt != nil {
+		server = "deleted at " + conflict.Server.DeletedAt.Local().Format(time.DateTime)
+	}
+	local := "changed locally at " + conflict.Local.SavedAt.Local().Format(time.DateTime)
+	if conflict.Local.DeletedAt != nil {
+		local = "deleted locally at " + conflict.Local.DeletedAt.Local().Format(time.DateTime)
+	}
+	fmt.Printf("Data with id %s was %s and %s on the server\n", conflict.Local.ID, local, server)
+	fmt.Printf("  local:  %s\n  server: %s\n", conflict.Local.MetaData, conflict.Server.MetaData)
+
+	for {
+		fmt.Print("Keep [s]erver, [l]ocal or [b]oth? ")
+		var answer string
+		if _, err := fmt.Scanf("%s", &answer); errors.Is(err, io.EOF) {
+			return domain.KeepBoth
+		}
+		switch answer {
+		case "s":
+			return domain.ServerWins
+		case "l":
+			return domain.LocalWins
+		case "b":
+			return domain.KeepBoth
+		}
+	}
+}
+
+func (pc *PrivateCLI) changePassword(cmd *cobra.Command, _ []string) {
+	u := pc.authenticate(cmd)
+	newPassword := getInputString(cmd, "new-password", "Enter new password: ")
+
+	err := pc.privateService.ChangePassword(cmd.Context(), *u, newPassword, printProgress("Re-encrypting records"))
+	if err != nil {
+		fmt.Println()
+		pc.handleError(err)
+		fmt.Println("Run the command again to resume")
+		return
+	}
+	fmt.Println("Your password was successfully changed")
+}
+
+func (pc *PrivateCLI) rekey(cmd *cobra.Command, _ []string) {
+	u := pc.authenticate(cmd)
+	legacy, _ := cmd.Flags().GetBool("legacy")
+
+	if err := pc.privateService.Rekey(cmd.Context(), *u, legacy, printProgress("Re-encrypting records")); err != nil {
+		fmt.Println()
+		pc.handleError(err)
+		fmt.Println("Run the command again to resume")
+		return
+	}
+	fmt.Println("Your data was successfully re-encrypted")
+}
+
+func printProgress(title string) func(done, total int) {
+	return func(done, total int) {
+		fmt.Printf("\r%s: %d/%d", title, done, total)
+		if done == total {
+			fmt.Println()
+		}
+	}
 }
 
 func parseType(dataType string) domain.Type {
@@ -275,8 +760,39 @@ func (pc *PrivateCLI) authenticate(cmd *cobra.Command) *domain.InUserRequest {
 	}
 }
 
+// handleRevisionError tells what to do about a change of the record id the
+// server refused because of the revision it was based on, and reports whether
+// err was such a refusal. retry names the command to run again.
+func (pc *PrivateCLI) handleRevisionError(err error, id, retry string) bool {
+	switch {
+	case errors.Is(err, domain.ErrPrivateDataDeleted):
+		fmt.Printf("Your data with id %s is in the trash, try commands \"trash\" and \"undelete\" first\n", id)
+	case errors.Is(err, domain.ErrRevisionRequired):
+		fmt.Printf("Your data with id %s exists on the server but was not read here yet, try command \"sync\" and %s again\n", id, retry)
+	case errors.Is(err, domain.ErrPrivateDataConflict):
+		fmt.Printf("Your data with id %s was changed elsewhere since you last read it, try command \"sync\" and %s again\n", id, retry)
+	default:
+		return false
+	}
+	return true
+}
+
 func (pc *PrivateCLI) handleError(err error) {
 	fmt.Printf("Error: %v\n", err)
+	if errors.Is(err, domain.ErrLegacyEncryption) {
+		fmt.Println("Run rekey --legacy to migrate records of the baseline format")
+	}
+}
+
+// handleStale prints the warning about records read from the local copy and
+// returns any other error.
+func (pc *PrivateCLI) handleStale(err error) error {
+	var stale *domain.StaleDataWarning
+	if errors.As(err, &stale) {
+		fmt.Printf("Warn: %v\n", stale)
+		return nil
+	}
+	return err
 }
 
 func (pc *PrivateCLI) handleGetError(err error, id string) {
@@ -287,18 +803,46 @@ func (pc *PrivateCLI) handleGetError(err error, id string) {
 	pc.handleError(err)
 }
 
-func (pc *PrivateCLI) handleOutput(cmd *cobra.Command, data *domain.Data) error {
+func (pc *PrivateCLI) handleOutput(cmd *cobra.Command, data *domain.Data, r io.Reader) error {
 	switch data.DataType {
 	case domain.LOGIN_PASSWORD, domain.CARD:
-		fmt.Printf("%s\n\n%s\n", data.MetaData, data.Data)
+		plain, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n\n%s\n", data.MetaData, plain)
 		return nil
 	case domain.TEXT, domain.BYTES:
-		return pc.saveToOutput(cmd, data.Data)
+		return pc.copyToOutput(cmd, r)
 	default:
 		return errors.New("unsupported data type")
 	}
 }
 
+// copyToOutput writes r to the output file as it is decrypted. The file is
+// removed if the data turns out to be corrupted.
+func (pc *PrivateCLI) copyToOutput(cmd *cobra.Command, r io.Reader) error {
+	filePath, _ := cmd.Flags().GetString("output")
+	if filePath == "" {
+		fmt.Print("Enter output file: ")
+		fmt.Scanf("%s", &filePath)
+	}
+
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filePath)
+		return err
+	}
+	return nil
+}
+
 func (pc *PrivateCLI) saveToOutput(cmd *cobra.Command, data []byte) error {
 	filePath, _ := cmd.Flags().GetString("output")
 	if filePath == "" {
@@ -340,16 +884,16 @@ func handleTextData(cmd *cobra.Command) ([]byte, error) {
 	if text, _ := cmd.Flags().GetString("text"); text != "" {
 		return []byte(text), nil
 	}
-	return handleFileData(cmd, false)
+	return os.ReadFile(getFilePath(cmd, false))
 }
 
-func handleFileData(cmd *cobra.Command, required bool) ([]byte, error) {
+func getFilePath(cmd *cobra.Command, required bool) string {
 	filePath, _ := cmd.Flags().GetString("file")
 	if filePath == "" && required {
 		fmt.Print("Enter file path: ")
 		fmt.Scanf("%s", &filePath)
 	}
-	return os.ReadFile(filePath)
+	return filePath
 }
 
 func getInputUint16(cmd *cobra.Command, flagName, prompt string) uint16 {
@@ -360,3 +904,12 @@ func getInputUint16(cmd *cobra.Command, flagName, prompt string) uint16 {
 	}
 	return val
 }
+
+func getInputInt64(cmd *cobra.Command, flagName, prompt string) int64 {
+	val, err := cmd.Flags().GetInt64(flagName)
+	if err != nil || val == 0 {
+		fmt.Print(prompt)
+		fmt.Scanf("%d", &val)
+	}
+	return val
+}
@@ -3,6 +3,7 @@ package clients
 import (
 	"context"
 	"encoding/json"
+	"gokeeper/pkg/auth"
 	"gokeeper/pkg/domain"
 	"net/http"
 
@@ -19,10 +20,10 @@ func NewAuthClient(client *resty.Client) *AuthClient {
 	}
 }
 
-func (ac *AuthClient) Login(ctx context.Context, user domain.InUserRequest) (string, error) {
+func (ac *AuthClient) Login(ctx context.Context, user domain.InUserRequest) (auth.TokenPair, error) {
 	body, err := json.Marshal(user)
 	if err != nil {
-		return "", err
+		return auth.TokenPair{}, err
 	}
 	resp, err := ac.client.R().
 		SetContext(ctx).
@@ -30,24 +31,108 @@ func (ac *AuthClient) Login(ctx context.Context, user domain.InUserRequest) (str
 		SetBody(body).
 		Post("/api/user/login")
 	if err != nil {
-		return "", err
+		return auth.TokenPair{}, err
 	}
 
 	switch resp.StatusCode() {
 	case http.StatusUnauthorized:
-		return "", domain.ErrUserAuthentication
+		return auth.TokenPair{}, domain.ErrUserAuthentication
+	case http.StatusAccepted:
+		mfaToken := auth.Token(resp.Header().Get(auth.MFATokenHeader))
+		return auth.TokenPair{MFAToken: mfaToken}, domain.ErrMFARequired
 	case http.StatusOK:
-		jwt := resp.Header().Get("authorization")
-		return jwt, nil
+		return tokensFromResponse(resp), nil
 	default:
-		return "", domain.ErrInternalServerError
+		return auth.TokenPair{}, unexpectedStatus(resp)
 	}
 }
 
-func (ac *AuthClient) Register(ctx context.Context, user domain.InUserRequest) (string, error) {
+func (ac *AuthClient) LoginMFA(ctx context.Context, req domain.MFALoginRequest) (auth.TokenPair, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+	resp, err := ac.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		Post("/api/user/login/mfa")
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return auth.TokenPair{}, domain.ErrUserAuthentication
+	case http.StatusTooManyRequests:
+		return auth.TokenPair{}, tooManyRequests(resp)
+	case http.StatusOK:
+		return tokensFromResponse(resp), nil
+	default:
+		return auth.TokenPair{}, unexpectedStatus(resp)
+	}
+}
+
+func (ac *AuthClient) EnrollTOTP(ctx context.Context, jwt string) (domain.TOTPEnrollResponse, error) {
+	var enrollment domain.TOTPEnrollResponse
+	resp, err := ac.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		Post("/api/user/2fa/enroll")
+	if err != nil {
+		return enrollment, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return enrollment, domain.ErrUserAuthentication
+	case http.StatusConflict:
+		return enrollment, domain.ErrMFAAlreadyEnabled
+	case http.StatusOK:
+		err = json.Unmarshal(resp.Body(), &enrollment)
+		return enrollment, err
+	default:
+		return enrollment, unexpectedStatus(resp)
+	}
+}
+
+func (ac *AuthClient) VerifyTOTP(ctx context.Context, jwt string, code string) ([]string, error) {
+	body, err := json.Marshal(domain.TOTPVerifyRequest{Code: code})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ac.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		Post("/api/user/2fa/verify")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserAuthentication
+	case http.StatusConflict:
+		return nil, domain.ErrMFAAlreadyEnabled
+	case http.StatusPreconditionFailed:
+		return nil, domain.ErrMFANotEnrolled
+	case http.StatusOK:
+		var codes domain.RecoveryCodesResponse
+		if err = json.Unmarshal(resp.Body(), &codes); err != nil {
+			return nil, err
+		}
+		return codes.Codes, nil
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+func (ac *AuthClient) Register(ctx context.Context, user domain.InUserRequest) (auth.TokenPair, error) {
 	body, err := json.Marshal(user)
 	if err != nil {
-		return "", err
+		return auth.TokenPair{}, err
 	}
 	resp, err := ac.client.R().
 		SetContext(ctx).
@@ -55,16 +140,142 @@ func (ac *AuthClient) Register(ctx context.Context, user domain.InUserRequest) (
 		SetBody(body).
 		Post("/api/user/register")
 	if err != nil {
-		return "", err
+		return auth.TokenPair{}, err
 	}
 
 	switch resp.StatusCode() {
 	case http.StatusConflict:
-		return "", domain.ErrUserConflict
+		return auth.TokenPair{}, domain.ErrUserConflict
 	case http.StatusOK:
-		jwt := resp.Header().Get("authorization")
-		return jwt, nil
+		return tokensFromResponse(resp), nil
 	default:
-		return "", domain.ErrInternalServerError
+		return auth.TokenPair{}, unexpectedStatus(resp)
+	}
+}
+
+func (ac *AuthClient) Refresh(ctx context.Context, refreshToken string) (auth.TokenPair, error) {
+	body, err := json.Marshal(domain.RefreshRequest{RefreshToken: refreshToken})
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+	resp, err := ac.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		Post("/api/user/refresh")
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized, http.StatusBadRequest:
+		return auth.TokenPair{}, domain.ErrUserAuthentication
+	case http.StatusOK:
+		return tokensFromResponse(resp), nil
+	default:
+		return auth.TokenPair{}, unexpectedStatus(resp)
+	}
+}
+
+func (ac *AuthClient) Logout(ctx context.Context, jwt string, all bool) error {
+	path := "/api/user/logout"
+	if all {
+		path += "/all"
+	}
+	resp, err := ac.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		Post(path)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return domain.ErrUserAuthentication
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	default:
+		return unexpectedStatus(resp)
+	}
+}
+
+func (ac *AuthClient) ChangePassword(ctx context.Context, jwt string, req domain.ChangePasswordRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := ac.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		Put("/api/user/password")
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return domain.ErrUserAuthentication
+	case http.StatusForbidden:
+		return domain.ErrWrongPassword
+	case http.StatusBadRequest:
+		return domain.ErrPrivateDataBadFormat
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	default:
+		return unexpectedStatus(resp)
+	}
+}
+
+func (ac *AuthClient) GetVaultKey(ctx context.Context, jwt string) ([]byte, error) {
+	resp, err := ac.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		Get("/api/user/vault-key")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserAuthentication
+	case http.StatusNotFound:
+		return nil, domain.ErrVaultKeyNotFound
+	case http.StatusOK:
+		return resp.Body(), nil
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+func (ac *AuthClient) PutVaultKey(ctx context.Context, jwt string, wrappedKey []byte) error {
+	resp, err := ac.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		SetHeader("Content-Type", "application/octet-stream").
+		SetBody(wrappedKey).
+		Put("/api/user/vault-key")
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return domain.ErrUserAuthentication
+	case http.StatusConflict:
+		return domain.ErrVaultKeyConflict
+	case http.StatusCreated, http.StatusOK:
+		return nil
+	default:
+		return unexpectedStatus(resp)
+	}
+}
+
+func tokensFromResponse(resp *resty.Response) auth.TokenPair {
+	return auth.TokenPair{
+		AccessToken:  auth.Token(resp.Header().Get("authorization")),
+		RefreshToken: resp.Header().Get(auth.RefreshTokenHeader),
 	}
 }
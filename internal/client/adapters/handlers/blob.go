@@ -0,0 +1,177 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"gokeeper/pkg/domain"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-resty/resty/v2"
+)
+
+type BlobClient struct {
+	client *resty.Client
+}
+
+func NewBlobClient(client *resty.Client) *BlobClient {
+	return &BlobClient{
+		client: client,
+	}
+}
+
+func (bc *BlobClient) InitBlob(ctx context.Context, req domain.BlobInitRequest, jwt string) (domain.BlobStatus, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return domain.BlobStatus{}, err
+	}
+	resp, err := bc.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Authorization", jwt).
+		SetBody(body).
+		Post("/api/blobs")
+	if err != nil {
+		return domain.BlobStatus{}, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return domain.BlobStatus{}, domain.ErrUserAuthentication
+	case http.StatusBadRequest:
+		return domain.BlobStatus{}, domain.ErrPrivateDataBadFormat
+	case http.StatusRequestEntityTooLarge:
+		return domain.BlobStatus{}, domain.ErrBlobTooLarge
+	case http.StatusInsufficientStorage:
+		return domain.BlobStatus{}, domain.ErrBlobQuotaExceeded
+	case http.StatusCreated:
+		var status domain.BlobStatus
+		if err = json.Unmarshal(resp.Body(), &status); err != nil {
+			return domain.BlobStatus{}, err
+		}
+		return status, nil
+	default:
+		return domain.BlobStatus{}, unexpectedStatus(resp)
+	}
+}
+
+func (bc *BlobClient) PutChunk(ctx context.Context, id string, index int, chunk []byte, jwt string) error {
+	resp, err := bc.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/octet-stream").
+		SetHeader("Authorization", jwt).
+		SetBody(chunk).
+		Put("/api/blobs/" + id + "/chunks/" + strconv.Itoa(index))
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return domain.ErrUserAuthentication
+	case http.StatusNotFound:
+		return domain.ErrBlobNotFound
+	case http.StatusConflict:
+		return domain.ErrBlobCompleted
+	case http.StatusBadRequest:
+		return domain.ErrBlobChunkInvalid
+	case http.StatusNoContent:
+		return nil
+	default:
+		return unexpectedStatus(resp)
+	}
+}
+
+func (bc *BlobClient) Status(ctx context.Context, id string, jwt string) (domain.BlobStatus, error) {
+	resp, err := bc.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		Get("/api/blobs/" + id + "/status")
+	if err != nil {
+		return domain.BlobStatus{}, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return domain.BlobStatus{}, domain.ErrUserAuthentication
+	case http.StatusNotFound:
+		return domain.BlobStatus{}, domain.ErrBlobNotFound
+	case http.StatusOK:
+		var status domain.BlobStatus
+		if err = json.Unmarshal(resp.Body(), &status); err != nil {
+			return domain.BlobStatus{}, err
+		}
+		return status, nil
+	default:
+		return domain.BlobStatus{}, unexpectedStatus(resp)
+	}
+}
+
+func (bc *BlobClient) Complete(ctx context.Context, id string, req domain.BlobCompleteRequest, jwt string) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := bc.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Authorization", jwt).
+		SetBody(body).
+		Post("/api/blobs/" + id + "/complete")
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return domain.ErrUserAuthentication
+	case http.StatusNotFound:
+		return domain.ErrBlobNotFound
+	case http.StatusConflict:
+		return domain.ErrBlobIncomplete
+	case http.StatusUnprocessableEntity:
+		return domain.ErrBlobChecksumMismatch
+	case http.StatusBadRequest:
+		return domain.ErrPrivateDataBadFormat
+	case http.StatusNoContent:
+		return nil
+	default:
+		return unexpectedStatus(resp)
+	}
+}
+
+// Download returns the content of the blob from offset on. The server may
+// ignore the range and send the whole blob, so the offset the returned body
+// starts at is returned as well. The body must be closed by the caller.
+func (bc *BlobClient) Download(ctx context.Context, id string, offset int64, jwt string) (io.ReadCloser, int64, error) {
+	req := bc.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		SetDoNotParseResponse(true)
+	if offset > 0 {
+		req.SetHeader("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := req.Get("/api/blobs/" + id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusOK:
+		return resp.RawBody(), 0, nil
+	case http.StatusPartialContent:
+		return resp.RawBody(), offset, nil
+	}
+	resp.RawBody().Close()
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return nil, 0, domain.ErrUserAuthentication
+	case http.StatusNotFound:
+		return nil, 0, domain.ErrBlobNotFound
+	case http.StatusConflict:
+		return nil, 0, domain.ErrBlobIncomplete
+	default:
+		return nil, 0, unexpectedStatus(resp)
+	}
+}
@@ -9,6 +9,7 @@ import (
 type Clients struct {
 	AuthClient    *AuthClient
 	PrivateClient *PrivateClient
+	BlobClient    *BlobClient
 }
 
 func NewClients(cfg *config.Config) *Clients {
@@ -17,8 +18,15 @@ func NewClients(cfg *config.Config) *Clients {
 		SetContentLength(true).
 		SetRetryCount(cfg.ServerRetries).
 		SetTimeout(cfg.ServerTimeout)
+	// Chunks and downloads may take much longer than other requests.
+	blobClient := resty.New().
+		SetBaseURL("http://" + cfg.Addr).
+		SetContentLength(true).
+		SetRetryCount(cfg.ServerRetries).
+		SetTimeout(cfg.BlobTimeout)
 	return &Clients{
 		AuthClient:    NewAuthClient(restyClient),
 		PrivateClient: NewPrivateClient(restyClient),
+		BlobClient:    NewBlobClient(blobClient),
 	}
 }
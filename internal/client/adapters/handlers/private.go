@@ -3,9 +3,12 @@ package clients
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"gokeeper/pkg/domain"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 )
@@ -20,46 +23,63 @@ func NewPrivateClient(client *resty.Client) *PrivateClient {
 	}
 }
 
-func (pc *PrivateClient) Save(ctx context.Context, pd domain.Data, jwt string) error {
+// Save stores the record, provided the server's copy is still at the revision
+// pd is based on, and returns the new revision. A record that was changed in
+// the meantime is a conflict.
+func (pc *PrivateClient) Save(ctx context.Context, pd domain.Data, jwt string) (int64, error) {
 	body, err := json.Marshal(pd)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	resp, err := pc.client.R().
+	req := pc.client.R().
 		SetContext(ctx).
 		SetHeader("Content-Type", "application/json").
 		SetBody(body).
-		SetHeader("Authorization", jwt).
-		Post("/api/private")
+		SetHeader("Authorization", jwt)
+	if pd.Revision != 0 {
+		req.SetHeader("If-Match", revisionETag(pd.Revision))
+	}
+	resp, err := req.Post("/api/private")
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	switch resp.StatusCode() {
 	case http.StatusUnauthorized:
-		return domain.ErrUserAuthentication
-	case http.StatusConflict:
-		return domain.ErrPrivateDataConflict
+		return 0, domain.ErrUserAuthentication
+	case http.StatusTooManyRequests:
+		return 0, tooManyRequests(resp)
+	case http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired, http.StatusGone:
+		return 0, revisionError(resp.StatusCode())
 	case http.StatusBadRequest:
-		return domain.ErrPrivateDataBadFormat
+		return 0, domain.ErrPrivateDataBadFormat
 	case http.StatusOK:
-		return nil
+		revision, err := strconv.ParseInt(strings.Trim(resp.Header().Get("ETag"), `"`), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid revision of saved record: %w", err)
+		}
+		return revision, nil
 	default:
-		return domain.ErrInternalServerError
+		return 0, unexpectedStatus(resp)
 	}
 }
 
+// Delete moves the record to the trash, provided the server's copy is still
+// at the revision the deletion is based on.
 func (pc *PrivateClient) Delete(ctx context.Context, pd domain.DeleteRequest, jwt string) error {
 	body, err := json.Marshal(pd)
 	if err != nil {
 		return err
 	}
-	resp, err := pc.client.R().
+	req := pc.client.R().
 		SetContext(ctx).
 		SetHeader("Authorization", jwt).
 		SetHeader("Content-Type", "application/json").
-		SetBody(body).
-		Delete("/api/private")
+		SetBody(body)
+	if pd.Revision != 0 {
+		req.SetHeader("If-Match", revisionETag(pd.Revision))
+	}
+	resp, err := req.Delete("/api/private")
 	if err != nil {
 		return err
 	}
@@ -67,17 +87,96 @@ func (pc *PrivateClient) Delete(ctx context.Context, pd domain.DeleteRequest, jw
 	switch resp.StatusCode() {
 	case http.StatusUnauthorized:
 		return domain.ErrUserAuthentication
-	case http.StatusConflict:
-		return domain.ErrPrivateDataConflict
+	case http.StatusTooManyRequests:
+		return tooManyRequests(resp)
+	case http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return revisionError(resp.StatusCode())
 	case http.StatusBadRequest:
 		return domain.ErrPrivateDataBadFormat
-	case http.StatusOK:
+	case http.StatusOK, http.StatusNoContent:
 		return nil
 	default:
-		return domain.ErrInternalServerError
+		return unexpectedStatus(resp)
 	}
 }
 
+// Capabilities asks the server which optional features it supports. A server
+// that does not tell supports none.
+func (pc *PrivateClient) Capabilities(ctx context.Context) (domain.Capabilities, error) {
+	resp, err := pc.client.R().
+		SetContext(ctx).
+		Get("/api/capabilities")
+	if err != nil {
+		return domain.Capabilities{}, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusNotFound:
+		return domain.Capabilities{}, nil
+	case http.StatusOK:
+		var capabilities domain.Capabilities
+		if err = json.Unmarshal(resp.Body(), &capabilities); err != nil {
+			return domain.Capabilities{}, err
+		}
+		return capabilities, nil
+	default:
+		return domain.Capabilities{}, unexpectedStatus(resp)
+	}
+}
+
+// Batch saves and deletes records in one request and returns the error of
+// each operation, in order.
+func (pc *PrivateClient) Batch(ctx context.Context, ops []domain.BatchOp, bestEffort bool, jwt string) ([]error, error) {
+	body, err := json.Marshal(domain.BatchRequest{Ops: ops, BestEffort: bestEffort})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pc.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Authorization", jwt).
+		SetBody(body).
+		Post("/api/private/batch")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserAuthentication
+	case http.StatusTooManyRequests:
+		return nil, tooManyRequests(resp)
+	case http.StatusBadRequest:
+		return nil, domain.ErrPrivateDataBadFormat
+	case http.StatusOK:
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+
+	var batchResponse domain.BatchResponse
+	if err = json.Unmarshal(resp.Body(), &batchResponse); err != nil {
+		return nil, err
+	}
+	if len(batchResponse.Results) != len(ops) {
+		return nil, domain.ErrInternalServerError
+	}
+	errs := make([]error, len(ops))
+	for i, result := range batchResponse.Results {
+		switch result.Status {
+		case http.StatusOK, http.StatusNoContent:
+		case http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired, http.StatusGone:
+			errs[i] = revisionError(result.Status)
+		case http.StatusBadRequest:
+			errs[i] = domain.ErrPrivateDataBadFormat
+		case http.StatusFailedDependency:
+			errs[i] = domain.ErrBatchAborted
+		default:
+			errs[i] = domain.ErrInternalServerError
+		}
+	}
+	return errs, nil
+}
+
 func (pc *PrivateClient) Get(ctx context.Context, id string, jwt string) (*domain.Data, error) {
 	resp, err := pc.client.R().
 		SetContext(ctx).
@@ -103,15 +202,36 @@ func (pc *PrivateClient) Get(ctx context.Context, id string, jwt string) (*domai
 		}
 		return &pd, nil
 	default:
-		return nil, domain.ErrInternalServerError
+		return nil, unexpectedStatus(resp)
 	}
 }
 
-func (pc *PrivateClient) GetAll(ctx context.Context, pd domain.GetAllRequest, jwt string) ([]domain.Data, error) {
+// GetAll fetches a page of the user's records. Only the filters set in pd are
+// sent, the server defaults the others.
+func (pc *PrivateClient) GetAll(ctx context.Context, pd domain.GetAllRequest, jwt string) (*domain.Page, error) {
+	params := map[string]string{
+		"cursor":     pd.Cursor,
+		"order":      string(pd.Order),
+		"id_prefix":  pd.IDPrefix,
+		"meta_token": pd.MetaToken,
+	}
+	if pd.Limit > 0 {
+		params["limit"] = strconv.FormatUint(pd.Limit, 10)
+	}
+	if pd.Type != nil {
+		params["type"] = pd.Type.String()
+	}
+	for name, value := range params {
+		if value == "" {
+			delete(params, name)
+		}
+	}
+
 	resp, err := pc.client.R().
 		SetContext(ctx).
 		SetHeader("Authorization", jwt).
-		Get("/api/private?limit=" + strconv.FormatUint(pd.Limit, 10) + "&offset=" + strconv.FormatUint(pd.Offset, 10))
+		SetQueryParams(params).
+		Get("/api/private")
 	if err != nil {
 		return nil, err
 	}
@@ -122,14 +242,229 @@ func (pc *PrivateClient) GetAll(ctx context.Context, pd domain.GetAllRequest, jw
 	case http.StatusBadRequest:
 		return nil, domain.ErrPrivateDataBadFormat
 	case http.StatusOK:
-		respBody := resp.Body()
-		var pd []domain.Data
-		err = json.Unmarshal(respBody, &pd)
-		if err != nil {
+		var page domain.Page
+		if err = json.Unmarshal(resp.Body(), &page); err != nil {
 			return nil, err
 		}
-		return pd, nil
+		return &page, nil
 	default:
-		return nil, domain.ErrInternalServerError
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+// GetVersions lists the versions of a record without their payloads.
+func (pc *PrivateClient) GetVersions(ctx context.Context, id string, jwt string) ([]domain.Data, error) {
+	resp, err := pc.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		Get("/api/private/" + id + "/versions")
+	if err != nil {
+		return nil, err
 	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserAuthentication
+	case http.StatusNotFound:
+		return nil, domain.ErrPrivateDataNotFound
+	case http.StatusOK:
+		var versions []domain.Data
+		if err = json.Unmarshal(resp.Body(), &versions); err != nil {
+			return nil, err
+		}
+		return versions, nil
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+func (pc *PrivateClient) GetVersion(ctx context.Context, id string, version int64, jwt string) (*domain.Data, error) {
+	resp, err := pc.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		Get("/api/private/" + id + "/versions/" + strconv.FormatInt(version, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserAuthentication
+	case http.StatusNotFound:
+		return nil, domain.ErrVersionNotFound
+	case http.StatusOK:
+		var pd domain.Data
+		if err = json.Unmarshal(resp.Body(), &pd); err != nil {
+			return nil, err
+		}
+		return &pd, nil
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+// RestoreVersion makes a copy of the version the current one and returns the
+// record without its payload, provided the server's copy is still at
+// revision.
+func (pc *PrivateClient) RestoreVersion(ctx context.Context, id string, version int64, revision int64, jwt string) (*domain.Data, error) {
+	req := pc.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt)
+	if revision != 0 {
+		req.SetHeader("If-Match", revisionETag(revision))
+	}
+	resp, err := req.Post("/api/private/" + id + "/versions/" + strconv.FormatInt(version, 10) + "/restore")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserAuthentication
+	case http.StatusNotFound:
+		return nil, domain.ErrVersionNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return nil, revisionError(resp.StatusCode())
+	case http.StatusOK:
+		var pd domain.Data
+		if err = json.Unmarshal(resp.Body(), &pd); err != nil {
+			return nil, err
+		}
+		return &pd, nil
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+// GetTrash lists the deleted records without their payloads.
+func (pc *PrivateClient) GetTrash(ctx context.Context, jwt string) ([]domain.Data, error) {
+	resp, err := pc.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		Get("/api/trash")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserAuthentication
+	case http.StatusOK:
+		var pds []domain.Data
+		if err = json.Unmarshal(resp.Body(), &pds); err != nil {
+			return nil, err
+		}
+		return pds, nil
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+// Undelete takes the record out of the trash and returns it without its
+// payload, provided the server's copy is still at revision.
+func (pc *PrivateClient) Undelete(ctx context.Context, id string, revision int64, jwt string) (*domain.Data, error) {
+	req := pc.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt)
+	if revision != 0 {
+		req.SetHeader("If-Match", revisionETag(revision))
+	}
+	resp, err := req.Post("/api/private/" + id + "/undelete")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserAuthentication
+	case http.StatusNotFound:
+		return nil, domain.ErrPrivateDataNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return nil, revisionError(resp.StatusCode())
+	case http.StatusOK:
+		var pd domain.Data
+		if err = json.Unmarshal(resp.Body(), &pd); err != nil {
+			return nil, err
+		}
+		return &pd, nil
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+// GetChanges returns at most limit changes of the records after the revision
+// since.
+func (pc *PrivateClient) GetChanges(ctx context.Context, since int64, limit uint64, jwt string) (*domain.Changes, error) {
+	resp, err := pc.client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", jwt).
+		SetQueryParams(map[string]string{
+			"since": strconv.FormatInt(since, 10),
+			"limit": strconv.FormatUint(limit, 10),
+		}).
+		Get("/api/changes")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserAuthentication
+	case http.StatusBadRequest:
+		return nil, domain.ErrPrivateDataBadFormat
+	case http.StatusOK:
+		var changes domain.Changes
+		if err = json.Unmarshal(resp.Body(), &changes); err != nil {
+			return nil, err
+		}
+		return &changes, nil
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+// tooManyRequests returns the error of a throttled request with the pause the
+// server asked for in Retry-After, given in seconds or as a date.
+func tooManyRequests(resp *resty.Response) error {
+	var retryAfter time.Duration
+	value := resp.Header().Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		retryAfter = time.Until(at)
+	}
+	return &domain.TooManyRequestsError{RetryAfter: max(retryAfter, 0)}
+}
+
+// revisionError returns the error of a change the server refused because of
+// the revision it is based on. All of them are conflicts; the cause tells
+// whether the record changed, exists but was not read, or is in the trash.
+func revisionError(status int) error {
+	switch status {
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %w", domain.ErrPrivateDataConflict, domain.ErrRevisionMismatch)
+	case http.StatusPreconditionRequired:
+		return fmt.Errorf("%w: %w", domain.ErrPrivateDataConflict, domain.ErrRevisionRequired)
+	case http.StatusGone:
+		return fmt.Errorf("%w: %w", domain.ErrPrivateDataConflict, domain.ErrPrivateDataDeleted)
+	default:
+		return domain.ErrPrivateDataConflict
+	}
+}
+
+// unexpectedStatus returns the error of a response with a status the request
+// does not expect. Gateway errors mean the server is temporarily out of
+// reach; anything else is a failure of the server itself.
+func unexpectedStatus(resp *resty.Response) error {
+	switch resp.StatusCode() {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return domain.ErrServerUnavailable
+	default:
+		return domain.ErrInternalServerError
+	}
+}
+
+// revisionETag returns the entity tag the server gives a record at revision.
+func revisionETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
 }
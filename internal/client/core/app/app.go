@@ -8,6 +8,7 @@ import (
 	"gokeeper/internal/client/core/config"
 	"gokeeper/internal/client/core/service"
 	"gokeeper/internal/client/core/service/workers"
+	"gokeeper/pkg/domain"
 	"gokeeper/pkg/encrypter"
 
 	"github.com/spf13/cobra"
@@ -17,20 +18,40 @@ type Client struct {
 	CLI *cli.CLI
 }
 
-func NewClient(cfg *config.Config) *Client {
+func NewClient(cfg *config.Config) (*Client, error) {
+	algorithm, err := encrypter.ParseAlgorithm(cfg.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	syncPolicy, err := domain.ParseConflictPolicy(cfg.SyncPolicy)
+	if err != nil {
+		return nil, err
+	}
 	c := clients.NewClients(cfg)
 	w := workers.NewWorkers(cfg, c.PrivateClient)
 	services := service.NewServices(
 		w.FileWorker.JWTWorker,
+		w.FileWorker.RefreshTokenWorker,
+		w.FileWorker.VaultKeyWorker,
 		c.AuthClient,
 		c.PrivateClient,
-		encrypter.NewEncrypter(),
+		encrypter.NewEncrypter(encrypter.KDFParams{
+			Time:    cfg.KDFTime,
+			Memory:  cfg.KDFMemory,
+			Threads: cfg.KDFThreads,
+		}, algorithm),
 		w.FileWorker.PrivateFileWorker,
 		w.Sender,
+		c.BlobClient,
+		w.FileWorker.BlobFileWorker,
+		w.FileWorker.ReplicaFileWorker,
+		cfg.EncryptMeta,
+		cfg.BlobChunkSize,
+		syncPolicy,
 	)
 	return &Client{
 		CLI: cli.NewCLI(services.PrivateService, services.AuthService),
-	}
+	}, nil
 }
 
 func (a *Client) Run(ctx context.Context) error {
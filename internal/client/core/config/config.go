@@ -1,27 +1,85 @@
 package config
 
 import (
+	"gokeeper/pkg/domain"
+	"gokeeper/pkg/encrypter"
+	"os"
+	"path/filepath"
 	"time"
 )
 
 type Config struct {
 	Addr             string        `env:"CLI_ADDRESS"`
 	JWTPath          string        `env:"CLI_JWT_PATH"`
+	RefreshTokenPath string        `env:"CLI_REFRESH_TOKEN_PATH"`
+	VaultKeyPath     string        `env:"CLI_VAULT_KEY_PATH"`
 	PrivateDataPath  string        `env:"CLI_DATA_PATH"`
 	ServerTimeout    time.Duration `env:"CLI_SERVER_TIMEOUT"`
 	ServerRetries    int           `env:"CLI_SERVER_RETRIES"`
 	SenderWorkersNum int           `env:"CLI_SENDER_WORKERS_NUM"`
+	SenderRate       float64       `env:"CLI_SENDER_RATE"`
+	SenderBurst      int           `env:"CLI_SENDER_BURST"`
+	QueueRetries     int           `env:"CLI_QUEUE_RETRIES"`
+	QueueBackoff     time.Duration `env:"CLI_QUEUE_BACKOFF"`
+
+	KDFTime    uint32 `env:"CLI_KDF_TIME"`
+	KDFMemory  uint32 `env:"CLI_KDF_MEMORY"`
+	KDFThreads uint8  `env:"CLI_KDF_THREADS"`
+	Cipher     string `env:"CLI_CIPHER"`
+
+	EncryptMeta bool `env:"CLI_ENCRYPT_META"`
+
+	BlobDir       string        `env:"CLI_BLOB_DIR"`
+	BlobChunkSize int64         `env:"CLI_BLOB_CHUNK_SIZE"`
+	BlobTimeout   time.Duration `env:"CLI_BLOB_TIMEOUT"`
+
+	ReplicaPath string `env:"CLI_REPLICA_PATH"`
+	SyncPolicy  string `env:"CLI_SYNC_POLICY"`
 }
 
+// NewConfig returns the default configuration. The client's tokens, keys and
+// data are kept in a gokeeper directory in the user's configuration
+// directory, which is created with mode 0700.
 func NewConfig() *Config {
+	dir := defaultDir()
 	cfg := &Config{
-		JWTPath:          "/tmp/gophkeeper.jwt",
-		PrivateDataPath:  "./data.json",
+		JWTPath:          filepath.Join(dir, "jwt"),
+		RefreshTokenPath: filepath.Join(dir, "refresh"),
+		VaultKeyPath:     filepath.Join(dir, "vault"),
+		PrivateDataPath:  filepath.Join(dir, "data.json"),
 		Addr:             "localhost:8080",
 		ServerTimeout:    time.Second * 2,
 		ServerRetries:    3,
 		SenderWorkersNum: 10,
+		SenderRate:       10,
+		SenderBurst:      10,
+		QueueRetries:     3,
+		QueueBackoff:     time.Millisecond * 500,
+
+		KDFTime:    encrypter.DefaultKDFParams.Time,
+		KDFMemory:  encrypter.DefaultKDFParams.Memory,
+		KDFThreads: encrypter.DefaultKDFParams.Threads,
+		Cipher:     "aes-256-gcm",
+
+		EncryptMeta: true,
+
+		BlobDir:       filepath.Join(dir, "blobs"),
+		BlobChunkSize: 4 * 1024 * 1024,
+		BlobTimeout:   time.Minute * 5,
+
+		ReplicaPath: filepath.Join(dir, "replica.enc"),
+		SyncPolicy:  string(domain.Prompt),
 	}
 
 	return cfg
 }
+
+// defaultDir returns the client's directory in the user's configuration
+// directory, or the working directory if the user has none.
+func defaultDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(dir, "gokeeper")
+}
@@ -2,39 +2,70 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"gokeeper/pkg/auth"
 	"gokeeper/pkg/domain"
 	"log"
 )
 
 type Service struct {
-	jwtFileWorker JwtFileWorker
-	authClient    Client
+	jwtFileWorker      JwtFileWorker
+	refreshFileWorker  RefreshTokenFileWorker
+	vaultKeyFileWorker VaultKeyFileWorker
+	authClient         Client
 }
 type JwtFileWorker interface {
 	Set(jwt string) error
 	Get() (string, error)
+	Delete() error
+}
+
+type RefreshTokenFileWorker interface {
+	Set(token string) error
+	Get() (string, error)
+	Delete() error
+}
+
+type VaultKeyFileWorker interface {
+	Set(wrappedKey []byte) error
+	Get() ([]byte, error)
 }
 
 type Client interface {
-	Login(ctx context.Context, user domain.InUserRequest) (string, error)
-	Register(ctx context.Context, user domain.InUserRequest) (string, error)
+	Login(ctx context.Context, user domain.InUserRequest) (auth.TokenPair, error)
+	Register(ctx context.Context, user domain.InUserRequest) (auth.TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (auth.TokenPair, error)
+	Logout(ctx context.Context, jwt string, all bool) error
+	LoginMFA(ctx context.Context, req domain.MFALoginRequest) (auth.TokenPair, error)
+	EnrollTOTP(ctx context.Context, jwt string) (domain.TOTPEnrollResponse, error)
+	VerifyTOTP(ctx context.Context, jwt string, code string) ([]string, error)
+	GetVaultKey(ctx context.Context, jwt string) ([]byte, error)
+	PutVaultKey(ctx context.Context, jwt string, wrappedKey []byte) error
+	ChangePassword(ctx context.Context, jwt string, req domain.ChangePasswordRequest) error
 }
 
-func NewAuthService(jwtFileWorker JwtFileWorker, authClient Client) *Service {
+func NewAuthService(
+	jwtFileWorker JwtFileWorker,
+	refreshFileWorker RefreshTokenFileWorker,
+	vaultKeyFileWorker VaultKeyFileWorker,
+	authClient Client,
+) *Service {
 	return &Service{
-		jwtFileWorker: jwtFileWorker,
-		authClient:    authClient,
+		jwtFileWorker:      jwtFileWorker,
+		refreshFileWorker:  refreshFileWorker,
+		vaultKeyFileWorker: vaultKeyFileWorker,
+		authClient:         authClient,
 	}
 }
 
 func (as *Service) Register(ctx context.Context, user domain.InUserRequest, saveJWT bool) error {
-	jwt, err := as.authClient.Register(ctx, user)
+	tokens, err := as.authClient.Register(ctx, user)
 	if err != nil {
 		return err
 	}
 
-	if saveJWT && jwt != "" {
-		err = as.jwtFileWorker.Set(jwt)
+	if saveJWT && tokens.AccessToken != "" {
+		err = as.saveTokens(tokens)
 		if err != nil {
 			log.Printf("Warn: Не удалось сохранить токен на диск: %v", err)
 		}
@@ -42,21 +73,182 @@ func (as *Service) Register(ctx context.Context, user domain.InUserRequest, save
 	return nil
 }
 
+// Login authenticates the user. If the account has two-factor authentication
+// enabled it returns the MFA pending token together with ErrMFARequired; the
+// login is then completed with LoginMFA.
 func (as *Service) Login(ctx context.Context, user domain.InUserRequest, saveJWT bool) (string, error) {
-	jwt, err := as.authClient.Login(ctx, user)
+	tokens, err := as.authClient.Login(ctx, user)
+	if errors.Is(err, domain.ErrMFARequired) {
+		return string(tokens.MFAToken), err
+	}
 	if err != nil {
 		return "", err
 	}
 
-	if saveJWT && jwt != "" {
-		err = as.jwtFileWorker.Set(jwt)
+	if saveJWT && tokens.AccessToken != "" {
+		err = as.saveTokens(tokens)
 		if err != nil {
 			log.Printf("Warn: %v", err)
 		}
 	}
-	return jwt, nil
+	return string(tokens.AccessToken), nil
+}
+
+func (as *Service) LoginMFA(ctx context.Context, mfaToken string, code string, saveJWT bool) (string, error) {
+	tokens, err := as.authClient.LoginMFA(ctx, domain.MFALoginRequest{MFAToken: mfaToken, Code: code})
+	if err != nil {
+		return "", err
+	}
+
+	if saveJWT && tokens.AccessToken != "" {
+		err = as.saveTokens(tokens)
+		if err != nil {
+			log.Printf("Warn: %v", err)
+		}
+	}
+	return string(tokens.AccessToken), nil
+}
+
+func (as *Service) EnrollTOTP(ctx context.Context) (domain.TOTPEnrollResponse, error) {
+	jwt, err := as.GetJwt(ctx)
+	if err != nil {
+		return domain.TOTPEnrollResponse{}, err
+	}
+	return as.authClient.EnrollTOTP(ctx, jwt)
+}
+
+func (as *Service) VerifyTOTP(ctx context.Context, code string) ([]string, error) {
+	jwt, err := as.GetJwt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return as.authClient.VerifyTOTP(ctx, jwt, code)
+}
+
+// GetVaultKey returns the wrapped vault key. The copy cached on disk is used
+// when the server can not be reached.
+func (as *Service) GetVaultKey(ctx context.Context) ([]byte, error) {
+	jwt, err := as.GetJwt(ctx)
+	if err != nil {
+		return as.vaultKeyFileWorker.Get()
+	}
+
+	wrappedKey, err := as.authClient.GetVaultKey(ctx, jwt)
+	if err != nil {
+		if errors.Is(err, domain.ErrVaultKeyNotFound) || errors.Is(err, domain.ErrUserAuthentication) {
+			return nil, err
+		}
+		return as.vaultKeyFileWorker.Get()
+	}
+	if err = as.vaultKeyFileWorker.Set(wrappedKey); err != nil {
+		log.Printf("Warn: %v", err)
+	}
+	return wrappedKey, nil
+}
+
+// GetCachedVaultKey returns the wrapped vault key cached on disk by the last
+// GetVaultKey without contacting the server.
+func (as *Service) GetCachedVaultKey() ([]byte, error) {
+	return as.vaultKeyFileWorker.Get()
+}
+
+// PutVaultKey stores a newly created wrapped vault key on the server.
+func (as *Service) PutVaultKey(ctx context.Context, wrappedKey []byte) error {
+	jwt, err := as.GetJwt(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = as.authClient.PutVaultKey(ctx, jwt, wrappedKey); err != nil {
+		return err
+	}
+	if err = as.vaultKeyFileWorker.Set(wrappedKey); err != nil {
+		log.Printf("Warn: %v", err)
+	}
+	return nil
+}
+
+// ChangePassword changes the account password on the server together with
+// the re-wrapped vault key. The server revokes every session, so the stored
+// tokens are dropped and the next command logs in with the new password.
+func (as *Service) ChangePassword(ctx context.Context, req domain.ChangePasswordRequest) error {
+	jwt, err := as.GetJwt(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = as.authClient.ChangePassword(ctx, jwt, req); err != nil {
+		return err
+	}
+	if len(req.VaultKey) != 0 {
+		if err = as.vaultKeyFileWorker.Set(req.VaultKey); err != nil {
+			log.Printf("Warn: %v", err)
+		}
+	}
+	if err = as.jwtFileWorker.Delete(); err != nil {
+		log.Printf("Warn: %v", err)
+	}
+	if err = as.refreshFileWorker.Delete(); err != nil {
+		log.Printf("Warn: %v", err)
+	}
+	return nil
 }
 
+// GetJwt returns the stored access token, refreshing it first when it is
+// missing or expired.
 func (as *Service) GetJwt(ctx context.Context) (string, error) {
-	return as.jwtFileWorker.Get()
+	jwt, err := as.jwtFileWorker.Get()
+	if err == nil {
+		return jwt, nil
+	}
+	if jwt, refreshErr := as.Refresh(ctx); refreshErr == nil {
+		return jwt, nil
+	}
+	return "", err
+}
+
+// Refresh exchanges the stored refresh token for a new token pair and returns
+// the new access token.
+func (as *Service) Refresh(ctx context.Context) (string, error) {
+	refreshToken, err := as.refreshFileWorker.Get()
+	if err != nil {
+		return "", err
+	}
+
+	tokens, err := as.authClient.Refresh(ctx, refreshToken)
+	if err != nil {
+		return "", err
+	}
+	if err = as.saveTokens(tokens); err != nil {
+		log.Printf("Warn: %v", err)
+	}
+	return string(tokens.AccessToken), nil
+}
+
+// Logout revokes the current session, or every session of the user if all is
+// set, and removes the locally stored tokens. Local tokens are removed even if
+// the server could not be reached.
+func (as *Service) Logout(ctx context.Context, all bool) error {
+	var logoutErr error
+	if jwt, err := as.GetJwt(ctx); err == nil {
+		logoutErr = as.authClient.Logout(ctx, jwt, all)
+	}
+
+	if err := as.jwtFileWorker.Delete(); err != nil {
+		return err
+	}
+	if err := as.refreshFileWorker.Delete(); err != nil {
+		return err
+	}
+	return logoutErr
+}
+
+func (as *Service) saveTokens(tokens auth.TokenPair) error {
+	if err := as.jwtFileWorker.Set(string(tokens.AccessToken)); err != nil {
+		return err
+	}
+	if tokens.RefreshToken == "" {
+		return nil
+	}
+	return as.refreshFileWorker.Set(tokens.RefreshToken)
 }
@@ -0,0 +1,313 @@
+package private
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"gokeeper/pkg/domain"
+	"io"
+	"log"
+	"os"
+)
+
+// headerPrefixLen is enough of a blob to hold the header of an encrypted
+// stream.
+const headerPrefixLen = 1024
+
+// spoolBlob encrypts everything read from r into a new spool file and
+// returns the upload of it for pd.
+func (ps *Service) spoolBlob(pd domain.Data, r io.Reader, ad, vaultKey []byte) (domain.BlobUpload, error) {
+	spool, err := ps.blobFileWorker.CreateSpool()
+	if err != nil {
+		return domain.BlobUpload{}, err
+	}
+	defer spool.Close()
+	upload := domain.BlobUpload{Record: pd, Spool: spool.Name()}
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(spool, hash)}
+	w, err := ps.encrypter.NewEncryptWriter(counter, ad, vaultKey)
+	if err == nil {
+		if _, err = io.Copy(w, r); err == nil {
+			err = w.Close()
+		}
+	}
+	if err == nil {
+		err = spool.Sync()
+	}
+	if err != nil {
+		ps.discardUpload(upload)
+		return domain.BlobUpload{}, err
+	}
+
+	upload.Record.Data = nil
+	upload.Size = counter.n
+	upload.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return upload, nil
+}
+
+// sendBlob uploads the chunks of the spool the server has not received yet,
+// completes the blob and saves the record referencing it, which it returns
+// at its new revision. The upload state is kept up to date, so that an
+// interrupted upload can be resumed by calling sendBlob again; it is removed
+// once the record is saved.
+func (ps *Service) sendBlob(ctx context.Context, jwt string, upload domain.BlobUpload) (domain.Data, error) {
+	var status domain.BlobStatus
+	var err error
+	if upload.BlobID != "" {
+		err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+			status, err = ps.blobClient.Status(ctx, upload.BlobID, jwt)
+			return err
+		})
+		if errors.Is(err, domain.ErrBlobNotFound) {
+			// The server dropped the blob, so the upload starts over.
+			upload.BlobID = ""
+		} else if err != nil {
+			return domain.Data{}, err
+		}
+	}
+	if upload.BlobID == "" {
+		err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+			status, err = ps.blobClient.InitBlob(ctx, domain.BlobInitRequest{Size: upload.Size, ChunkSize: ps.blobChunkSize}, jwt)
+			return err
+		})
+		if err != nil {
+			return domain.Data{}, err
+		}
+		upload.BlobID = status.ID
+		if err = ps.blobFileWorker.SaveUpload(upload); err != nil {
+			return domain.Data{}, err
+		}
+	}
+
+	if !status.Completed {
+		if err = ps.sendChunks(ctx, jwt, upload, status); err != nil {
+			return domain.Data{}, err
+		}
+		err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+			return ps.blobClient.Complete(ctx, upload.BlobID, domain.BlobCompleteRequest{SHA256: upload.SHA256}, jwt)
+		})
+		if err != nil {
+			return domain.Data{}, err
+		}
+	}
+
+	pd := upload.Record
+	pd.BlobID = upload.BlobID
+	err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+		pd.Revision, err = ps.privateClient.Save(ctx, pd, jwt)
+		return err
+	})
+	if err != nil {
+		return domain.Data{}, err
+	}
+	ps.discardUpload(upload)
+	return pd, nil
+}
+
+func (ps *Service) sendChunks(ctx context.Context, jwt string, upload domain.BlobUpload, status domain.BlobStatus) error {
+	spool, err := ps.blobFileWorker.OpenSpool(upload.Spool)
+	if err != nil {
+		return err
+	}
+	defer spool.Close()
+
+	received := make(map[int]bool, len(status.Received))
+	for _, index := range status.Received {
+		received[index] = true
+	}
+	chunk := make([]byte, status.ChunkSize)
+	for index := 0; index < status.Chunks; index++ {
+		if received[index] {
+			continue
+		}
+		offset := int64(index) * status.ChunkSize
+		n, err := spool.ReadAt(chunk[:min(status.ChunkSize, status.Size-offset)], offset)
+		if err != nil && !(errors.Is(err, io.EOF) && int64(n) == status.Size-offset) {
+			return err
+		}
+		err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+			return ps.blobClient.PutChunk(ctx, upload.BlobID, index, chunk[:n], jwt)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeUploads finishes the blob uploads that were interrupted. Uploads the
+// server rejects are dropped, the others are kept for the next attempt.
+func (ps *Service) resumeUploads(ctx context.Context, jwt string) error {
+	uploads, err := ps.blobFileWorker.GetUploads()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, upload := range uploads {
+		_, err = ps.sendBlob(ctx, jwt, upload)
+		if isRejected(err) {
+			log.Printf("Warn: dropping upload of record %s: %v", upload.Record.ID, err)
+			ps.discardUpload(upload)
+			continue
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (ps *Service) discardUpload(upload domain.BlobUpload) {
+	if err := ps.blobFileWorker.DeleteUpload(upload); err != nil {
+		log.Printf("Warn: failed to delete upload %s: %v", upload.Spool, err)
+	}
+}
+
+// downloadBlob downloads a blob to a local file, continuing a previous
+// partial download. The returned file is positioned at its start.
+func (ps *Service) downloadBlob(ctx context.Context, jwt, blobID string) (*os.File, error) {
+	var status domain.BlobStatus
+	err := ps.withRefresh(ctx, jwt, func(jwt string) error {
+		var err error
+		status, err = ps.blobClient.Status(ctx, blobID, jwt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := ps.blobFileWorker.OpenDownload(blobID)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err == nil && offset > status.Size {
+		offset, err = 0, file.Truncate(0)
+	}
+	if err == nil && offset < status.Size {
+		err = ps.download(ctx, jwt, blobID, file, offset)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (ps *Service) download(ctx context.Context, jwt, blobID string, file *os.File, offset int64) error {
+	var body io.ReadCloser
+	var start int64
+	err := ps.withRefresh(ctx, jwt, func(jwt string) error {
+		var err error
+		body, start, err = ps.blobClient.Download(ctx, blobID, offset, jwt)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if start != offset {
+		if err = file.Truncate(start); err != nil {
+			return err
+		}
+		if _, err = file.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if _, err = io.Copy(file, body); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// openBlob downloads a blob and returns a reader of its decrypted content.
+// The downloaded copy is removed once it was read.
+func (ps *Service) openBlob(ctx context.Context, jwt, blobID string, ad, vaultKey []byte) (io.Reader, error) {
+	file, err := ps.downloadBlob(ctx, jwt, blobID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ps.encrypter.NewDecryptReader(file, ad, vaultKey)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &downloadReader{r: r, file: file, remove: func() {
+		if err := ps.blobFileWorker.DeleteDownload(blobID); err != nil {
+			log.Printf("Warn: failed to delete download of blob %s: %v", blobID, err)
+		}
+	}}, nil
+}
+
+// blobHeader returns the beginning of a blob, which holds its encryption
+// header.
+func (ps *Service) blobHeader(ctx context.Context, jwt, blobID string) ([]byte, error) {
+	var body io.ReadCloser
+	err := ps.withRefresh(ctx, jwt, func(jwt string) error {
+		var err error
+		body, _, err = ps.blobClient.Download(ctx, blobID, 0, jwt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	header := make([]byte, headerPrefixLen)
+	n, err := io.ReadFull(body, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// isRejected reports whether the server refused the data itself, in which
+// case retrying is pointless.
+func isRejected(err error) bool {
+	return errors.Is(err, domain.ErrPrivateDataConflict) ||
+		errors.Is(err, domain.ErrPrivateDataBadFormat) ||
+		errors.Is(err, domain.ErrBlobTooLarge) ||
+		errors.Is(err, domain.ErrBlobQuotaExceeded) ||
+		errors.Is(err, domain.ErrBlobChecksumMismatch)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// downloadReader reads a downloaded blob and closes the file once reading
+// ends. The file is removed if it was read to the end or turned out to be
+// corrupt.
+type downloadReader struct {
+	r      io.Reader
+	file   *os.File
+	remove func()
+	err    error
+}
+
+func (dr *downloadReader) Read(p []byte) (int, error) {
+	if dr.err != nil {
+		return 0, dr.err
+	}
+	n, err := dr.r.Read(p)
+	if err != nil {
+		dr.err = err
+		dr.file.Close()
+		var pathErr *os.PathError
+		if !errors.As(err, &pathErr) {
+			dr.remove()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,124 @@
+package private
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"gokeeper/pkg/domain"
+	"strings"
+	"unicode"
+)
+
+// encryptedMetaPrefix marks encrypted metadata. The server stores metadata as
+// text, so the ciphertext is base64 encoded.
+var encryptedMetaPrefix = []byte("enc:")
+
+const metaTokenLen = 16
+
+// metaIndexInfo separates the blind index key from other keys derived from
+// the vault key.
+var metaIndexInfo = []byte("gokeeper meta index")
+
+// sealMeta encrypts the metadata of pd and fills its blind index.
+func (ps *Service) sealMeta(pd *domain.Data, login string, vaultKey []byte) error {
+	if len(pd.MetaData) == 0 || isEncryptedMeta(pd.MetaData) {
+		return nil
+	}
+
+	ct, err := ps.encrypter.EncryptMessage(pd.MetaData, metaAD(login, pd.ID, pd.DataType), vaultKey)
+	if err != nil {
+		return err
+	}
+	pd.MetaIndex = metaIndex(pd.MetaData, vaultKey)
+	pd.MetaData = append(append([]byte{}, encryptedMetaPrefix...), base64.StdEncoding.EncodeToString(ct)...)
+	return nil
+}
+
+// openMeta decrypts the metadata of pd if it is encrypted. Plaintext
+// metadata of older records is left as is.
+func (ps *Service) openMeta(pd *domain.Data, login string, vaultKey []byte) error {
+	pd.MetaIndex = nil
+	if !isEncryptedMeta(pd.MetaData) {
+		return nil
+	}
+
+	ct, err := base64.StdEncoding.DecodeString(string(pd.MetaData[len(encryptedMetaPrefix):]))
+	if err != nil {
+		return domain.ErrPrivateDataBadFormat
+	}
+	pd.MetaData, err = ps.encrypter.DecryptMessage(ct, metaAD(login, pd.ID, pd.DataType), vaultKey)
+	return err
+}
+
+// metaNeedsUpgrade reports whether rekeying should rewrite the metadata of pd:
+// it is plaintext while metadata encryption is enabled, or it is encrypted in
+// an outdated envelope.
+func (ps *Service) metaNeedsUpgrade(pd domain.Data) bool {
+	if !isEncryptedMeta(pd.MetaData) {
+		return ps.encryptMeta && len(pd.MetaData) != 0
+	}
+	ct, err := base64.StdEncoding.DecodeString(string(pd.MetaData[len(encryptedMetaPrefix):]))
+	return err == nil && ps.encrypter.NeedsUpgrade(ct)
+}
+
+func isEncryptedMeta(meta []byte) bool {
+	return bytes.HasPrefix(meta, encryptedMetaPrefix)
+}
+
+// metaAD differs from the record's own associated data so that the payload
+// and the metadata ciphertexts can not be swapped either.
+func metaAD(login, id string, dataType domain.Type) []byte {
+	return append(recordAD(login, id, dataType), "meta"...)
+}
+
+// metaIndex returns the blind index tokens of the words of meta. Tokens are
+// keyed hashes, so the server can match a search token against them without
+// learning the words; it only sees which records share a word.
+func metaIndex(meta, vaultKey []byte) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	for _, word := range metaWords(string(meta)) {
+		token := metaToken(word, vaultKey)
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// metaToken returns the blind index token of a single search word. The index
+// is keyed with a subkey derived from the vault key, so the tokens reveal
+// nothing about the key the records are encrypted with.
+func metaToken(word string, vaultKey []byte) string {
+	mac := hmac.New(sha256.New, metaIndexKey(vaultKey))
+	mac.Write([]byte(strings.ToLower(word)))
+	return hex.EncodeToString(mac.Sum(nil)[:metaTokenLen])
+}
+
+// metaIndexKey derives the key of the blind index from the vault key. The
+// vault key is uniform, so HMAC-SHA256 keyed with it serves as the KDF.
+func metaIndexKey(vaultKey []byte) []byte {
+	mac := hmac.New(sha256.New, vaultKey)
+	mac.Write(metaIndexInfo)
+	return mac.Sum(nil)
+}
+
+func metaWords(meta string) []string {
+	return strings.FieldsFunc(meta, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// metaContains reports whether meta contains word, compared the same way as
+// index tokens.
+func metaContains(meta []byte, word string) bool {
+	for _, w := range metaWords(string(meta)) {
+		if strings.EqualFold(w, word) {
+			return true
+		}
+	}
+	return false
+}
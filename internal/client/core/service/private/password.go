@@ -0,0 +1,246 @@
+package private
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gokeeper/pkg/domain"
+	"time"
+)
+
+const fetchPageSize = 100
+
+// ChangePassword changes the account password. Every record is first
+// checked to open with the vault key, then the vault key is re-wrapped with
+// the new password and replaced on the server at once. Records of the
+// baseline format are encrypted with the password itself, so they have to be
+// migrated by Rekey first. progress, if set, is called after each record.
+func (ps *Service) ChangePassword(
+	ctx context.Context,
+	inputUser domain.InUserRequest,
+	newPassword string,
+	progress func(done, total int),
+) error {
+	jwt, err := ps.authorizeUser(ctx, &inputUser)
+	if err != nil {
+		return err
+	}
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
+	if err != nil {
+		return err
+	}
+
+	if err = ps.reencrypt(ctx, jwt, inputUser, vaultKey, false, false, progress); err != nil {
+		return err
+	}
+
+	wrappedKey, err := ps.encrypter.WrapKey(vaultKey, newPassword)
+	if err != nil {
+		return err
+	}
+	err = ps.authService.ChangePassword(ctx, domain.ChangePasswordRequest{
+		OldPassword: inputUser.Password,
+		NewPassword: newPassword,
+		VaultKey:    wrappedKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	ps.vaultKey = nil
+	return nil
+}
+
+// Rekey re-encrypts every record that is not sealed with the current cipher
+// or not encrypted with the vault key. Records of the baseline format, which
+// are encrypted with the login and password and not bound to the record, are
+// only migrated if legacy is set. progress, if set, is called after each
+// record. Like ChangePassword it can simply be run again if interrupted.
+func (ps *Service) Rekey(
+	ctx context.Context,
+	inputUser domain.InUserRequest,
+	legacy bool,
+	progress func(done, total int),
+) error {
+	jwt, err := ps.authorizeUser(ctx, &inputUser)
+	if err != nil {
+		return err
+	}
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
+	if err != nil {
+		return err
+	}
+	return ps.reencrypt(ctx, jwt, inputUser, vaultKey, true, legacy, progress)
+}
+
+// reencrypt re-encrypts with the vault key every record that can not be
+// opened with it and, if upgrade is set, every record in an outdated
+// envelope. Records of the baseline format are only opened if legacy is set.
+func (ps *Service) reencrypt(
+	ctx context.Context,
+	jwt string,
+	inputUser domain.InUserRequest,
+	vaultKey []byte,
+	upgrade bool,
+	legacy bool,
+	progress func(done, total int),
+) error {
+	pds, err := ps.fetchAll(ctx, jwt)
+	if err != nil {
+		return err
+	}
+
+	// The replica learns the new revisions of the rewritten records, even
+	// if rewriting is interrupted.
+	var saved []domain.Data
+	defer func() {
+		if len(saved) > 0 {
+			ps.cacheRecords(inputUser.Login, vaultKey, saved...)
+		}
+	}()
+	for idx, pd := range pds {
+		if pd.BlobID != "" {
+			if upgrade {
+				if err = ps.upgradeBlob(ctx, jwt, inputUser, vaultKey, pd); err != nil {
+					return err
+				}
+			}
+			if progress != nil {
+				progress(idx+1, len(pds))
+			}
+			continue
+		}
+
+		ad := recordAD(inputUser.Login, pd.ID, pd.DataType)
+		plain, err := ps.encrypter.DecryptMessage(pd.Data, ad, vaultKey)
+		upgradeMeta := upgrade && ps.metaNeedsUpgrade(pd)
+		if err != nil || upgradeMeta || (upgrade && ps.encrypter.NeedsUpgrade(pd.Data)) {
+			if err != nil {
+				if legacy {
+					plain, err = ps.decryptBaseline(pd.Data, ad, vaultKey, inputUser)
+				} else {
+					plain, err = ps.decrypt(pd.Data, ad, vaultKey)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to decrypt record %s: %w", pd.ID, err)
+				}
+			}
+			if pd.DataType == domain.BYTES {
+				pd.Data, err = ps.encryptStream(bytes.NewReader(plain), ad, vaultKey)
+			} else {
+				pd.Data, err = ps.encrypter.EncryptMessage(plain, ad, vaultKey)
+			}
+			if err != nil {
+				return err
+			}
+			if upgradeMeta {
+				if err = ps.openMeta(&pd, inputUser.Login, vaultKey); err != nil {
+					return fmt.Errorf("failed to decrypt metadata of record %s: %w", pd.ID, err)
+				}
+				if err = ps.sealMeta(&pd, inputUser.Login, vaultKey); err != nil {
+					return err
+				}
+			}
+			pd.SavedAt = time.Now()
+			err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+				pd.Revision, err = ps.privateClient.Save(ctx, pd, jwt)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to save record %s: %w", pd.ID, err)
+			}
+			saved = append(saved, pd)
+		}
+		if progress != nil {
+			progress(idx+1, len(pds))
+		}
+	}
+	return nil
+}
+
+// upgradeBlob rewrites a record stored in a blob if its payload or its
+// metadata is in an outdated format. Blobs are always encrypted with the
+// vault key, so only upgrades are needed. Only the header of the blob is
+// downloaded unless the payload has to be re-encrypted.
+func (ps *Service) upgradeBlob(
+	ctx context.Context,
+	jwt string,
+	inputUser domain.InUserRequest,
+	vaultKey []byte,
+	pd domain.Data,
+) error {
+	header, err := ps.blobHeader(ctx, jwt, pd.BlobID)
+	if err != nil {
+		return fmt.Errorf("failed to download record %s: %w", pd.ID, err)
+	}
+	upgradePayload := ps.encrypter.NeedsUpgrade(header)
+	upgradeMeta := ps.metaNeedsUpgrade(pd)
+	if !upgradePayload && !upgradeMeta {
+		return nil
+	}
+
+	if upgradeMeta {
+		if err = ps.openMeta(&pd, inputUser.Login, vaultKey); err != nil {
+			return fmt.Errorf("failed to decrypt metadata of record %s: %w", pd.ID, err)
+		}
+		if err = ps.sealMeta(&pd, inputUser.Login, vaultKey); err != nil {
+			return err
+		}
+	}
+	pd.SavedAt = time.Now()
+	if !upgradePayload {
+		err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+			pd.Revision, err = ps.privateClient.Save(ctx, pd, jwt)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save record %s: %w", pd.ID, err)
+		}
+		ps.cacheRecords(inputUser.Login, vaultKey, pd)
+		return nil
+	}
+
+	ad := recordAD(inputUser.Login, pd.ID, pd.DataType)
+	plain, err := ps.openBlob(ctx, jwt, pd.BlobID, ad, vaultKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt record %s: %w", pd.ID, err)
+	}
+	upload, err := ps.spoolBlob(pd, plain, ad, vaultKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt record %s: %w", pd.ID, err)
+	}
+	if err = ps.blobFileWorker.SaveUpload(upload); err != nil {
+		ps.discardUpload(upload)
+		return err
+	}
+	saved, err := ps.sendBlob(ctx, jwt, upload)
+	if err != nil {
+		return fmt.Errorf("failed to save record %s: %w", pd.ID, err)
+	}
+	ps.cacheRecords(inputUser.Login, vaultKey, saved)
+	return nil
+}
+
+// fetchAll downloads every record of the user without decrypting it. All
+// pages are fetched before any record is rewritten, so that updates can not
+// shift records between pages.
+func (ps *Service) fetchAll(ctx context.Context, jwt string) ([]domain.Data, error) {
+	var all []domain.Data
+	req := domain.GetAllRequest{Limit: fetchPageSize}
+	for {
+		var page *domain.Page
+		err := ps.withRefresh(ctx, jwt, func(jwt string) error {
+			var err error
+			page, err = ps.privateClient.GetAll(ctx, req, jwt)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Records...)
+		if page.NextCursor == "" {
+			return all, nil
+		}
+		req.Cursor = page.NextCursor
+	}
+}
@@ -1,38 +1,89 @@
 package private
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"gokeeper/pkg/domain"
+	"gokeeper/pkg/encrypter"
+	"io"
 	"log"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
 )
 
 type AuthService interface {
 	Register(ctx context.Context, user domain.InUserRequest, saveJWT bool) error
 	Login(ctx context.Context, user domain.InUserRequest, saveJWT bool) (string, error)
 	GetJwt(ctx context.Context) (string, error)
+	Refresh(ctx context.Context) (string, error)
+	GetVaultKey(ctx context.Context) ([]byte, error)
+	GetCachedVaultKey() ([]byte, error)
+	PutVaultKey(ctx context.Context, wrappedKey []byte) error
+	ChangePassword(ctx context.Context, req domain.ChangePasswordRequest) error
 }
 
 type Client interface {
-	Save(ctx context.Context, pd domain.Data, jwt string) error
+	Save(ctx context.Context, pd domain.Data, jwt string) (int64, error)
 	Delete(ctx context.Context, pd domain.DeleteRequest, jwt string) error
 	Get(ctx context.Context, id string, jwt string) (*domain.Data, error)
-	GetAll(ctx context.Context, pd domain.GetAllRequest, jwt string) ([]domain.Data, error)
+	GetAll(ctx context.Context, pd domain.GetAllRequest, jwt string) (*domain.Page, error)
+	GetVersions(ctx context.Context, id string, jwt string) ([]domain.Data, error)
+	GetVersion(ctx context.Context, id string, version int64, jwt string) (*domain.Data, error)
+	RestoreVersion(ctx context.Context, id string, version int64, revision int64, jwt string) (*domain.Data, error)
+	GetTrash(ctx context.Context, jwt string) ([]domain.Data, error)
+	Undelete(ctx context.Context, id string, revision int64, jwt string) (*domain.Data, error)
+	GetChanges(ctx context.Context, since int64, limit uint64, jwt string) (*domain.Changes, error)
 }
 
 type FileWorker interface {
-	SaveMany(pd []domain.Data) error
-	GetAll() ([]domain.Data, error)
-	DeleteAll() error
+	Append(entries ...domain.QueueEntry) error
+	GetAll() ([]domain.QueueEntry, error)
+	Update(entry domain.QueueEntry) error
+	Remove(ids ...int64) error
+	Drop(id int64) error
 }
 
 type Encrypter interface {
-	EncryptMessage(msg []byte, secrets ...string) ([]byte, error)
-	DecryptMessage(msg []byte, secrets ...string) ([]byte, error)
+	EncryptMessage(msg, ad, key []byte) ([]byte, error)
+	DecryptMessage(msg, ad, key []byte) ([]byte, error)
+	DecryptBaselineMessage(msg []byte, secrets ...string) ([]byte, error)
+	WrapKey(key []byte, password string) ([]byte, error)
+	UnwrapKey(wrappedKey []byte, password string) ([]byte, error)
+	NeedsUpgrade(msg []byte) bool
+	NewEncryptWriter(dst io.Writer, ad, key []byte) (io.WriteCloser, error)
+	NewDecryptReader(src io.Reader, ad, key []byte) (io.Reader, error)
+}
+
+type BlobClient interface {
+	InitBlob(ctx context.Context, req domain.BlobInitRequest, jwt string) (domain.BlobStatus, error)
+	PutChunk(ctx context.Context, id string, index int, chunk []byte, jwt string) error
+	Status(ctx context.Context, id string, jwt string) (domain.BlobStatus, error)
+	Complete(ctx context.Context, id string, req domain.BlobCompleteRequest, jwt string) error
+	Download(ctx context.Context, id string, offset int64, jwt string) (io.ReadCloser, int64, error)
+}
+
+type BlobFileWorker interface {
+	CreateSpool() (*os.File, error)
+	OpenSpool(path string) (*os.File, error)
+	SaveUpload(upload domain.BlobUpload) error
+	GetUploads() ([]domain.BlobUpload, error)
+	DeleteUpload(upload domain.BlobUpload) error
+	OpenDownload(blobID string) (*os.File, error)
+	DeleteDownload(blobID string) error
+}
+
+type ReplicaFileWorker interface {
+	Set(replica []byte) error
+	Get() ([]byte, error)
 }
 
 type BulkSender interface {
-	Send(ctx context.Context, pds []domain.Data, jwt string) error
+	Send(ctx context.Context, entries []domain.QueueEntry, jwt string, done func(entry domain.QueueEntry, err error)) error
 }
 
 type Service struct {
@@ -41,6 +92,16 @@ type Service struct {
 	encrypter         Encrypter
 	privateFileWorker FileWorker
 	privateBulkSender BulkSender
+	blobClient        BlobClient
+	blobFileWorker    BlobFileWorker
+	replicaFileWorker ReplicaFileWorker
+
+	encryptMeta   bool
+	blobChunkSize int64
+	syncPolicy    domain.ConflictPolicy
+
+	vaultKey      []byte
+	vaultKeyLogin string
 }
 
 func NewPrivateService(
@@ -49,6 +110,12 @@ func NewPrivateService(
 	encrypter Encrypter,
 	privateFileWorker FileWorker,
 	privateBulkSender BulkSender,
+	blobClient BlobClient,
+	blobFileWorker BlobFileWorker,
+	replicaFileWorker ReplicaFileWorker,
+	encryptMeta bool,
+	blobChunkSize int64,
+	syncPolicy domain.ConflictPolicy,
 ) *Service {
 	return &Service{
 		authService:       authService,
@@ -56,6 +123,12 @@ func NewPrivateService(
 		encrypter:         encrypter,
 		privateFileWorker: privateFileWorker,
 		privateBulkSender: privateBulkSender,
+		blobClient:        blobClient,
+		blobFileWorker:    blobFileWorker,
+		replicaFileWorker: replicaFileWorker,
+		encryptMeta:       encryptMeta,
+		blobChunkSize:     blobChunkSize,
+		syncPolicy:        syncPolicy,
 	}
 }
 
@@ -74,24 +147,75 @@ func (ps *Service) authorizeUser(ctx context.Context, inputUser *domain.InUserRe
 	return jwt, nil
 }
 
+// withRefresh runs call with jwt and, if the server rejects the token, retries
+// it once with a freshly refreshed one.
+func (ps *Service) withRefresh(ctx context.Context, jwt string, call func(jwt string) error) error {
+	err := call(jwt)
+	if !errors.Is(err, domain.ErrUserAuthentication) {
+		return err
+	}
+	jwt, refreshErr := ps.authService.Refresh(ctx)
+	if refreshErr != nil {
+		return err
+	}
+	return call(jwt)
+}
+
+// isUnreachable reports whether the request failed because the server could
+// not be reached, rather than because it refused or failed the request. Only
+// connection failures, timeouts and gateway errors count; a server error or
+// a rejected certificate would fail again just the same.
+func isUnreachable(err error) bool {
+	if errors.Is(err, domain.ErrServerUnavailable) {
+		return true
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return false
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return urlErr.Timeout() ||
+		errors.As(urlErr, &opErr) ||
+		errors.As(urlErr, &dnsErr) ||
+		errors.Is(urlErr, io.EOF) ||
+		errors.Is(urlErr, io.ErrUnexpectedEOF)
+}
+
 func (ps *Service) Save(ctx context.Context, pd domain.Data, inputUser domain.InUserRequest, saveLocalOnError bool) error {
 	jwt, err := ps.authorizeUser(ctx, &inputUser)
 	if err != nil {
 		return err
 	}
 
-	pd.Data, err = ps.encrypter.EncryptMessage(pd.Data, inputUser.Login, inputUser.Password)
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
 	if err != nil {
 		return err
 	}
+	pd.Data, err = ps.encrypter.EncryptMessage(pd.Data, recordAD(inputUser.Login, pd.ID, pd.DataType), vaultKey)
+	if err != nil {
+		return err
+	}
+	if ps.encryptMeta {
+		if err = ps.sealMeta(&pd, inputUser.Login, vaultKey); err != nil {
+			return err
+		}
+	}
 
-	clientErr := ps.privateClient.Save(ctx, pd, jwt)
+	// The change is based on the revision the replica knows, which tells the
+	// server, or sync, whether the record was changed in the meantime. A
+	// record the replica does not know is saved as a new one, which the
+	// server refuses if it already has it.
+	pd.Revision = ps.replicaRevision(inputUser.Login, vaultKey, pd.ID)
+	var revision int64
+	clientErr := ps.withRefresh(ctx, jwt, func(jwt string) error {
+		var err error
+		revision, err = ps.privateClient.Save(ctx, pd, jwt)
+		return err
+	})
 	if clientErr != nil {
-		if errors.Is(clientErr, domain.ErrPrivateDataConflict) || errors.Is(clientErr, domain.ErrPrivateDataBadFormat) {
-			return clientErr
-		}
-		if saveLocalOnError {
-			fileWorkerErr := ps.privateFileWorker.SaveMany([]domain.Data{pd})
+		if saveLocalOnError && isUnreachable(clientErr) {
+			fileWorkerErr := ps.privateFileWorker.Append(domain.QueueEntry{Op: domain.QueueSave, Data: pd, QueuedAt: time.Now()})
 			if fileWorkerErr != nil {
 				return fileWorkerErr
 			}
@@ -99,75 +223,334 @@ func (ps *Service) Save(ctx context.Context, pd domain.Data, inputUser domain.In
 		}
 		return clientErr
 	}
+	// The replica learns the new revision, so that the next change of the
+	// record is based on it.
+	pd.Revision = revision
+	ps.cacheRecords(inputUser.Login, vaultKey, pd)
 	return nil
 }
 
-func (ps *Service) GetAll(ctx context.Context, gpr domain.GetAllRequest, inputUser domain.InUserRequest) ([]domain.Data, error) {
+// SaveStream saves a record whose payload is read from r. The payload is
+// encrypted in segments to a local spool file and uploaded as a blob in
+// chunks, which is meant for large files. If the transfer is interrupted,
+// Upload resumes it.
+func (ps *Service) SaveStream(
+	ctx context.Context,
+	pd domain.Data,
+	r io.Reader,
+	inputUser domain.InUserRequest,
+	saveLocalOnError bool,
+) error {
 	jwt, err := ps.authorizeUser(ctx, &inputUser)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	pds, err := ps.privateClient.GetAll(ctx, gpr, jwt)
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if ps.encryptMeta {
+		if err = ps.sealMeta(&pd, inputUser.Login, vaultKey); err != nil {
+			return err
+		}
+	}
+	pd.Revision = ps.replicaRevision(inputUser.Login, vaultKey, pd.ID)
+	upload, err := ps.spoolBlob(pd, r, recordAD(inputUser.Login, pd.ID, pd.DataType), vaultKey)
+	if err != nil {
+		return err
+	}
+	if err = ps.blobFileWorker.SaveUpload(upload); err != nil {
+		ps.discardUpload(upload)
+		return err
 	}
 
-	for idx := range pds {
-		pds[idx].Data, err = ps.encrypter.DecryptMessage(pds[idx].Data, inputUser.Login, inputUser.Password)
-		if err != nil {
-			return nil, err
+	saved, err := ps.sendBlob(ctx, jwt, upload)
+	if err != nil {
+		if isUnreachable(err) && saveLocalOnError {
+			return domain.WarnServerUnavailable
 		}
+		ps.discardUpload(upload)
+		return err
 	}
-	return pds, nil
+	ps.cacheRecords(inputUser.Login, vaultKey, saved)
+	return nil
 }
 
-func (ps *Service) Get(ctx context.Context, id string, inputUser domain.InUserRequest) (*domain.Data, error) {
+// GetAll lists a page of the user's records. If the server can not be
+// reached, or offline is set, the page is listed from the local replica
+// instead and returned together with a *domain.StaleDataWarning.
+func (ps *Service) GetAll(ctx context.Context, gpr domain.GetAllRequest, inputUser domain.InUserRequest, offline bool) (*domain.Page, error) {
+	if offline {
+		return ps.getAllCached(gpr, inputUser)
+	}
 	jwt, err := ps.authorizeUser(ctx, &inputUser)
+	if isUnreachable(err) {
+		return ps.getAllCached(gpr, inputUser)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	pd, err := ps.privateClient.Get(ctx, id, jwt)
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
+	if err != nil {
+		return nil, err
+	}
+	if gpr.Search != "" {
+		gpr.MetaToken = metaToken(gpr.Search, vaultKey)
+	}
+
+	var page *domain.Page
+	err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+		page, err = ps.privateClient.GetAll(ctx, gpr, jwt)
+		return err
+	})
+	if isUnreachable(err) {
+		return ps.getAllCached(gpr, inputUser)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	pd.Data, err = ps.encrypter.DecryptMessage(pd.Data, inputUser.Login, inputUser.Password)
+	ps.cacheRecords(inputUser.Login, vaultKey, page.Records...)
+	if page.Records, err = ps.openRecords(page.Records, gpr.Search, inputUser, vaultKey); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// openRecords decrypts fetched records and keeps those whose metadata
+// contains search.
+func (ps *Service) openRecords(pds []domain.Data, search string, inputUser domain.InUserRequest, vaultKey []byte) ([]domain.Data, error) {
+	var err error
+	found := pds[:0]
+	for _, pd := range pds {
+		// The payload of a blob is only downloaded when the record is
+		// opened.
+		if pd.BlobID == "" {
+			ad := recordAD(inputUser.Login, pd.ID, pd.DataType)
+			pd.Data, err = ps.decrypt(pd.Data, ad, vaultKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err = ps.openMeta(&pd, inputUser.Login, vaultKey); err != nil {
+			return nil, err
+		}
+		// The server can only match tokens, so results are checked against
+		// the decrypted metadata as well.
+		if search == "" || metaContains(pd.MetaData, search) {
+			found = append(found, pd)
+		}
+	}
+	return found, nil
+}
+
+func (ps *Service) Get(ctx context.Context, id string, inputUser domain.InUserRequest) (*domain.Data, error) {
+	pd, r, err := ps.Open(ctx, id, inputUser, false)
 	if err != nil {
 		return nil, err
 	}
+	if pd.Data, err = io.ReadAll(r); err != nil {
+		return nil, err
+	}
 	return pd, nil
 }
 
-func (ps *Service) Delete(ctx context.Context, pd domain.DeleteRequest) error {
-	jwt, err := ps.authorizeUser(ctx, nil)
+// Open fetches a record and returns it with its metadata decrypted together
+// with a reader of the decrypted payload. Payloads encrypted in segments are
+// decrypted while being read, and blobs are downloaded first, resuming an
+// earlier partial download. The Data field of the returned record is not
+// set. If the server can not be reached, or offline is set, the record is
+// read from the local replica instead and returned together with a
+// *domain.StaleDataWarning.
+func (ps *Service) Open(ctx context.Context, id string, inputUser domain.InUserRequest, offline bool) (*domain.Data, io.Reader, error) {
+	if offline {
+		return ps.openCached(ctx, id, inputUser)
+	}
+	jwt, err := ps.authorizeUser(ctx, &inputUser)
+	var pd *domain.Data
+	if err == nil {
+		err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+			pd, err = ps.privateClient.Get(ctx, id, jwt)
+			return err
+		})
+	}
+	if isUnreachable(err) {
+		return ps.openCached(ctx, id, inputUser)
+	}
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	return ps.privateClient.Delete(ctx, pd, jwt)
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
+	if err != nil {
+		return nil, nil, err
+	}
+	fetched := *pd
+	fetched.ID = id
+	ps.cacheRecords(inputUser.Login, vaultKey, fetched)
+	return ps.open(ctx, jwt, pd, id, inputUser)
 }
 
-func (ps *Service) Upload(ctx context.Context) error {
-	jwt, err := ps.authorizeUser(ctx, nil)
+// open decrypts a record fetched by id, as described for Open.
+func (ps *Service) open(
+	ctx context.Context,
+	jwt string,
+	pd *domain.Data,
+	id string,
+	inputUser domain.InUserRequest,
+) (*domain.Data, io.Reader, error) {
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	// The requested id is bound rather than the returned one, so the server
+	// can not answer with another record.
+	pd.ID = id
+	ad := recordAD(inputUser.Login, id, pd.DataType)
 
-	pds, err := ps.privateFileWorker.GetAll()
+	var r io.Reader
+	switch {
+	case pd.BlobID != "":
+		r, err = ps.openBlob(ctx, jwt, pd.BlobID, ad, vaultKey)
+	case encrypter.IsStream(pd.Data):
+		r, err = ps.encrypter.NewDecryptReader(bytes.NewReader(pd.Data), ad, vaultKey)
+	default:
+		var plain []byte
+		plain, err = ps.decrypt(pd.Data, ad, vaultKey)
+		r = bytes.NewReader(plain)
+	}
 	if err != nil {
+		return nil, nil, err
+	}
+	pd.Data = nil
+
+	if err = ps.openMeta(pd, inputUser.Login, vaultKey); err != nil {
+		return nil, nil, err
+	}
+	return pd, r, nil
+}
+
+// Delete moves a record to the trash, based on the revision of the record in
+// the local replica, which takes the user to read. Without it the server
+// refuses to delete a record. If the server can not be reached and
+// saveLocalOnError is set, the deletion is queued instead.
+func (ps *Service) Delete(
+	ctx context.Context,
+	pd domain.DeleteRequest,
+	inputUser *domain.InUserRequest,
+	saveLocalOnError bool,
+) error {
+	if inputUser != nil {
+		if vaultKey, err := ps.loadOfflineVaultKey(*inputUser); err == nil {
+			pd.Revision = ps.replicaRevision(inputUser.Login, vaultKey, pd.ID)
+		}
+	}
+	jwt, err := ps.authorizeUser(ctx, inputUser)
+	if err == nil {
+		err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+			return ps.privateClient.Delete(ctx, pd, jwt)
+		})
+	}
+	if !isUnreachable(err) || !saveLocalOnError {
 		return err
 	}
 
-	err = ps.privateBulkSender.Send(ctx, pds, jwt)
+	deletion := domain.Data{ID: pd.ID, DeletedAt: &pd.DeletedAt, Revision: pd.Revision}
+	err = ps.privateFileWorker.Append(domain.QueueEntry{Op: domain.QueueDelete, Data: deletion, QueuedAt: time.Now()})
 	if err != nil {
 		return err
 	}
+	return domain.WarnServerUnavailable
+}
 
-	err = ps.privateFileWorker.DeleteAll()
+// Upload sends the changes queued while the server was unavailable and
+// resumes interrupted blob uploads. Only the latest change of each record is
+// sent, and it leaves the queue together with the ones it replaces once the
+// server acknowledged it; a change that fails stays with its error. progress
+// is called after each change. With dryRun nothing is sent.
+func (ps *Service) Upload(
+	ctx context.Context,
+	dryRun bool,
+	progress func(done, total int),
+) (domain.UploadReport, error) {
+	var report domain.UploadReport
+	entries, err := ps.privateFileWorker.GetAll()
 	if err != nil {
-		log.Printf("Warn: failed to delete data form dist: %v", err)
+		return report, err
 	}
-	return nil
+	changes, replaced := latestChanges(entries)
+	report.Changes = changes
+	if dryRun {
+		return report, nil
+	}
+
+	jwt, err := ps.authorizeUser(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	// Blobs are resumed independently of the other records, so that a
+	// failing upload does not hold back the rest.
+	blobErr := ps.resumeUploads(ctx, jwt)
+
+	var mu sync.Mutex
+	reported := make(map[int64]bool)
+	done := func(entry domain.QueueEntry, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported[entry.ID] = true
+		if err != nil {
+			entry.LastError = err.Error()
+			report.Failed = append(report.Failed, entry)
+			err = ps.privateFileWorker.Update(entry)
+		} else {
+			report.Succeeded = append(report.Succeeded, entry)
+			err = ps.privateFileWorker.Remove(append([]int64{entry.ID}, replaced[entry.Data.ID]...)...)
+		}
+		if err != nil {
+			log.Printf("Warn: failed to update queue entry %d: %v", entry.ID, err)
+		}
+		progress(len(reported), len(changes))
+	}
+	err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+		// Changes sent before the token was refreshed are not sent again.
+		var left []domain.QueueEntry
+		for _, change := range changes {
+			if !reported[change.ID] {
+				left = append(left, change)
+			}
+		}
+		return ps.privateBulkSender.Send(ctx, left, jwt, done)
+	})
+	if err == nil && len(report.Failed) > 0 {
+		err = fmt.Errorf("%d of %d changes were not uploaded", len(report.Failed), len(changes))
+	}
+	return report, errors.Join(err, blobErr)
+}
+
+// Queue returns the changes waiting to be sent.
+func (ps *Service) Queue() ([]domain.QueueEntry, error) {
+	return ps.privateFileWorker.GetAll()
+}
+
+// DropQueued removes a change from the queue without sending it.
+func (ps *Service) DropQueued(id int64) error {
+	return ps.privateFileWorker.Drop(id)
+}
+
+// encryptStream encrypts everything read from r in segments.
+func (ps *Service) encryptStream(r io.Reader, ad, vaultKey []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := ps.encrypter.NewEncryptWriter(&buf, ad, vaultKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,160 @@
+package private
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gokeeper/pkg/domain"
+	"io"
+	"log"
+	"sort"
+)
+
+// loadReplica returns the local replica of the user's records. The replica
+// is only a copy of what the server has, so a missing one, or one that can
+// not be decrypted, e.g. because it belongs to another user, is replaced by
+// an empty one.
+func (ps *Service) loadReplica(login string, vaultKey []byte) (*domain.Replica, error) {
+	replica := &domain.Replica{Records: make(map[string]domain.Data)}
+	ct, err := ps.replicaFileWorker.Get()
+	if errors.Is(err, domain.ErrReplicaNotFound) {
+		return replica, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := ps.encrypter.DecryptMessage(ct, replicaAD(login), vaultKey)
+	if err != nil {
+		return replica, nil
+	}
+	var stored domain.Replica
+	if err = json.Unmarshal(plain, &stored); err != nil || stored.Records == nil {
+		return replica, nil
+	}
+	return &stored, nil
+}
+
+// saveReplica stores the replica encrypted with the vault key.
+func (ps *Service) saveReplica(replica *domain.Replica, login string, vaultKey []byte) error {
+	plain, err := json.Marshal(replica)
+	if err != nil {
+		return err
+	}
+	ct, err := ps.encrypter.EncryptMessage(plain, replicaAD(login), vaultKey)
+	if err != nil {
+		return err
+	}
+	return ps.replicaFileWorker.Set(ct)
+}
+
+// replicaRevision returns the revision of the record in the local replica, or
+// 0 if the replica does not know it.
+func (ps *Service) replicaRevision(login string, vaultKey []byte, id string) int64 {
+	replica, err := ps.loadReplica(login, vaultKey)
+	if err != nil {
+		return 0
+	}
+	return replica.Records[id].Revision
+}
+
+// cacheRecords keeps records fetched from the server in the replica, so that
+// they can be read while the server is unavailable. A failure only costs the
+// offline copy, so it is logged rather than returned.
+func (ps *Service) cacheRecords(login string, vaultKey []byte, pds ...domain.Data) {
+	replica, err := ps.loadReplica(login, vaultKey)
+	if err == nil {
+		for _, pd := range pds {
+			if cached, ok := replica.Records[pd.ID]; !ok || cached.Revision <= pd.Revision {
+				replica.Records[pd.ID] = pd
+			}
+		}
+		err = ps.saveReplica(replica, login, vaultKey)
+	}
+	if err != nil {
+		log.Printf("Warn: failed to cache records locally: %v", err)
+	}
+}
+
+// getAllCached lists a page of the local replica like GetAll, with the same
+// filters, order and cursors.
+func (ps *Service) getAllCached(gpr domain.GetAllRequest, inputUser domain.InUserRequest) (*domain.Page, error) {
+	order, err := domain.ParseOrder(string(gpr.Order))
+	if err != nil {
+		return nil, err
+	}
+	var after *domain.PageKey
+	if gpr.Cursor != "" {
+		if after, err = domain.ParseCursor(gpr.Cursor, order); err != nil {
+			return nil, err
+		}
+	}
+	vaultKey, err := ps.loadOfflineVaultKey(inputUser)
+	if err != nil {
+		return nil, err
+	}
+	replica, err := ps.loadReplica(inputUser.Login, vaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var pds []domain.Data
+	for _, pd := range replica.Records {
+		if pd.DeletedAt == nil && gpr.Matches(pd) {
+			pds = append(pds, pd)
+		}
+	}
+	sort.Slice(pds, func(i, j int) bool {
+		return domain.KeyOf(pds[i], order).Less(domain.KeyOf(pds[j], order))
+	})
+	found, err := ps.openRecords(pds, gpr.Search, inputUser, vaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &domain.Page{Total: int64(len(found))}
+	if after != nil {
+		found = found[sort.Search(len(found), func(i int) bool {
+			return after.Less(domain.KeyOf(found[i], order))
+		}):]
+	}
+	if gpr.Limit > 0 && uint64(len(found)) > gpr.Limit {
+		found = found[:gpr.Limit]
+		page.NextCursor = domain.KeyOf(found[len(found)-1], order).Cursor()
+	}
+	page.Records = found
+	return page, &domain.StaleDataWarning{SyncedAt: replica.SyncedAt}
+}
+
+// openCached opens a record of the local replica like Open. Blobs are not
+// kept in the replica, so they can only be opened online.
+func (ps *Service) openCached(ctx context.Context, id string, inputUser domain.InUserRequest) (*domain.Data, io.Reader, error) {
+	vaultKey, err := ps.loadOfflineVaultKey(inputUser)
+	if err != nil {
+		return nil, nil, err
+	}
+	replica, err := ps.loadReplica(inputUser.Login, vaultKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pd, ok := replica.Records[id]
+	if !ok || pd.DeletedAt != nil {
+		return nil, nil, domain.ErrPrivateDataNotFound
+	}
+	if pd.BlobID != "" {
+		return nil, nil, fmt.Errorf("record %s is stored in a blob and can only be opened online", id)
+	}
+	opened, r, err := ps.open(ctx, "", &pd, id, inputUser)
+	if err != nil {
+		return nil, nil, err
+	}
+	return opened, r, &domain.StaleDataWarning{SyncedAt: replica.SyncedAt}
+}
+
+// replicaAD binds the replica to its owner. Record ids are never empty, so
+// it differs from the associated data of any record.
+func replicaAD(login string) []byte {
+	return append(recordAD(login, "", domain.UNKNOWN), "replica"...)
+}
@@ -0,0 +1,306 @@
+package private
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gokeeper/pkg/domain"
+	"gokeeper/pkg/encrypter"
+	"io"
+	"log"
+	"time"
+)
+
+// Sync brings the local replica up to date with the server and sends the
+// changes saved locally while the server was unavailable. A local change
+// conflicts if the record was changed on the server since the revision the
+// change is based on. Conflicts are resolved by policy, or by the configured
+// policy if it is empty; the Prompt policy lets prompt decide each of them.
+// Interrupted blob uploads are resumed as well.
+func (ps *Service) Sync(
+	ctx context.Context,
+	inputUser domain.InUserRequest,
+	policy domain.ConflictPolicy,
+	prompt func(conflict domain.SyncConflict) domain.ConflictPolicy,
+) (domain.SyncReport, error) {
+	var report domain.SyncReport
+	if policy == "" {
+		policy = ps.syncPolicy
+	}
+
+	jwt, err := ps.authorizeUser(ctx, &inputUser)
+	if err != nil {
+		return report, err
+	}
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
+	if err != nil {
+		return report, err
+	}
+	replica, err := ps.loadReplica(inputUser.Login, vaultKey)
+	if err != nil {
+		return report, err
+	}
+	blobErr := ps.resumeUploads(ctx, jwt)
+
+	if report.Pulled, err = ps.pull(ctx, jwt, replica); err != nil {
+		return report, err
+	}
+
+	entries, err := ps.privateFileWorker.GetAll()
+	if err != nil {
+		return report, err
+	}
+	pending, replaced := latestChanges(entries)
+	pushErr := ps.push(ctx, jwt, inputUser, vaultKey, replica, pending, replaced, policy, prompt, &report)
+	if entries, err = ps.privateFileWorker.GetAll(); err != nil && pushErr == nil {
+		pushErr = err
+	}
+	left, _ := latestChanges(entries)
+	report.Pending = len(left)
+	if report.Pushed > 0 && pushErr == nil {
+		// The pushed changes are pulled again, so that the replica knows
+		// their revisions.
+		_, pushErr = ps.pull(ctx, jwt, replica)
+	}
+
+	if err = ps.saveReplica(replica, inputUser.Login, vaultKey); err != nil {
+		return report, err
+	}
+	if pushErr != nil {
+		return report, pushErr
+	}
+	return report, blobErr
+}
+
+// pull applies the server's changes since the cursor of the replica and
+// returns their number. If the server no longer knows the changes since the
+// cursor, the replica is rebuilt from scratch.
+func (ps *Service) pull(ctx context.Context, jwt string, replica *domain.Replica) (int, error) {
+	records, cursor := replica.Records, replica.Cursor
+	pulled := 0
+	for {
+		var changes *domain.Changes
+		err := ps.withRefresh(ctx, jwt, func(jwt string) error {
+			var err error
+			changes, err = ps.privateClient.GetChanges(ctx, cursor, fetchPageSize, jwt)
+			return err
+		})
+		if err != nil {
+			return pulled, err
+		}
+		if changes.Reset {
+			records = make(map[string]domain.Data)
+		}
+		for _, pd := range changes.Changes {
+			records[pd.ID] = pd
+		}
+		pulled += len(changes.Changes)
+		cursor = changes.Cursor
+		if !changes.HasMore {
+			break
+		}
+	}
+	replica.Records, replica.Cursor, replica.SyncedAt = records, cursor, time.Now()
+	return pulled, nil
+}
+
+// push sends the local changes, resolving conflicts with the replica. Sent
+// and discarded changes leave the queue together with the ones they replace.
+func (ps *Service) push(
+	ctx context.Context,
+	jwt string,
+	inputUser domain.InUserRequest,
+	vaultKey []byte,
+	replica *domain.Replica,
+	pending []domain.QueueEntry,
+	replaced map[string][]int64,
+	policy domain.ConflictPolicy,
+	prompt func(conflict domain.SyncConflict) domain.ConflictPolicy,
+	report *domain.SyncReport,
+) error {
+	for _, entry := range pending {
+		change := entry
+		server, known := replica.Records[entry.Data.ID]
+		switch {
+		case known && entry.Op == domain.QueueDelete && server.DeletedAt != nil:
+			// The record is deleted on both sides.
+			ps.ack(entry, replaced)
+			continue
+		case known && conflicts(entry, server):
+			report.Conflicts++
+			resolved, keep, err := ps.resolve(entry, server, replica, inputUser, vaultKey, policy, prompt)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				report.Discarded++
+				ps.ack(entry, replaced)
+				continue
+			}
+			change = resolved
+		}
+
+		err := ps.withRefresh(ctx, jwt, func(jwt string) error {
+			if change.Op == domain.QueueDelete {
+				return ps.privateClient.Delete(ctx, change.DeleteRequest(), jwt)
+			}
+			_, err := ps.privateClient.Save(ctx, change.Data, jwt)
+			return err
+		})
+		if errors.Is(err, domain.ErrPrivateDataConflict) {
+			// The record was changed after it was pulled. The next sync
+			// sees the change and resolves the conflict.
+			entry.Attempts++
+			entry.LastError = err.Error()
+			if err = ps.privateFileWorker.Update(entry); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ps.ack(entry, replaced)
+		report.Pushed++
+	}
+	return nil
+}
+
+// resolve applies the conflict policy to a local change. It returns the
+// change to send instead, or false if the change is dropped.
+func (ps *Service) resolve(
+	local domain.QueueEntry,
+	server domain.Data,
+	replica *domain.Replica,
+	inputUser domain.InUserRequest,
+	vaultKey []byte,
+	policy domain.ConflictPolicy,
+	prompt func(conflict domain.SyncConflict) domain.ConflictPolicy,
+) (domain.QueueEntry, bool, error) {
+	if policy == domain.Prompt {
+		conflict, err := ps.describeConflict(local.Data, server, inputUser.Login, vaultKey)
+		if err != nil {
+			return local, false, err
+		}
+		policy = prompt(conflict)
+	}
+
+	switch policy {
+	case domain.ServerWins:
+		return local, false, nil
+	case domain.LocalWins:
+		// The change is based on the server's record, so that it overwrites
+		// it.
+		local.Data.Revision = server.Revision
+		return local, true, nil
+	case domain.KeepBoth:
+		if local.Op == domain.QueueDelete {
+			// A deletion leaves nothing to keep but the server's record.
+			return local, false, nil
+		}
+		moved, err := ps.moveRecord(local.Data, conflictID(local.Data.ID, replica), inputUser, vaultKey)
+		local.Data = moved
+		return local, err == nil, err
+	default:
+		return local, false, fmt.Errorf("unknown conflict policy %q", policy)
+	}
+}
+
+// describeConflict returns the conflict with the metadata of both records
+// decrypted.
+func (ps *Service) describeConflict(local, server domain.Data, login string, vaultKey []byte) (domain.SyncConflict, error) {
+	if err := ps.openMeta(&local, login, vaultKey); err != nil {
+		return domain.SyncConflict{}, err
+	}
+	if err := ps.openMeta(&server, login, vaultKey); err != nil {
+		return domain.SyncConflict{}, err
+	}
+	return domain.SyncConflict{Local: local, Server: server}, nil
+}
+
+// moveRecord returns the local change as a new record with another id. The
+// payload and the metadata are bound to the id, so they are encrypted again.
+func (ps *Service) moveRecord(pd domain.Data, id string, inputUser domain.InUserRequest, vaultKey []byte) (domain.Data, error) {
+	if pd.BlobID != "" {
+		return pd, fmt.Errorf("record %s is stored in a blob and can not be moved", pd.ID)
+	}
+
+	ad := recordAD(inputUser.Login, pd.ID, pd.DataType)
+	newAD := recordAD(inputUser.Login, id, pd.DataType)
+	var err error
+	if encrypter.IsStream(pd.Data) {
+		var r io.Reader
+		if r, err = ps.encrypter.NewDecryptReader(bytes.NewReader(pd.Data), ad, vaultKey); err != nil {
+			return pd, err
+		}
+		pd.Data, err = ps.encryptStream(r, newAD, vaultKey)
+	} else {
+		var plain []byte
+		if plain, err = ps.decrypt(pd.Data, ad, vaultKey); err != nil {
+			return pd, err
+		}
+		pd.Data, err = ps.encrypter.EncryptMessage(plain, newAD, vaultKey)
+	}
+	if err != nil {
+		return pd, err
+	}
+
+	sealed := isEncryptedMeta(pd.MetaData)
+	if err = ps.openMeta(&pd, inputUser.Login, vaultKey); err != nil {
+		return pd, err
+	}
+	pd.ID = id
+	pd.Revision = 0
+	if sealed {
+		err = ps.sealMeta(&pd, inputUser.Login, vaultKey)
+	}
+	return pd, err
+}
+
+// ack takes a change that needs no sending anymore off the queue, together
+// with the ones it replaces. Failing that, the change is sent again by the
+// next sync, which does no harm.
+func (ps *Service) ack(entry domain.QueueEntry, replaced map[string][]int64) {
+	if err := ps.privateFileWorker.Remove(append([]int64{entry.ID}, replaced[entry.Data.ID]...)...); err != nil {
+		log.Printf("Warn: failed to remove queue entry %d: %v", entry.ID, err)
+	}
+}
+
+// conflicts reports whether the server's record was changed since the
+// revision the local change is based on.
+func conflicts(local domain.QueueEntry, server domain.Data) bool {
+	return server.Revision != local.Data.Revision
+}
+
+// conflictID returns an id for the local copy of a conflicting record that is
+// not taken yet.
+func conflictID(id string, replica *domain.Replica) string {
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s-conflict-%d", id, n)
+		if _, taken := replica.Records[candidate]; !taken {
+			return candidate
+		}
+	}
+}
+
+// latestChanges keeps only the latest queued change of each record. The ids
+// of the changes it replaces are returned by record id.
+func latestChanges(entries []domain.QueueEntry) ([]domain.QueueEntry, map[string][]int64) {
+	latest := make(map[string]int)
+	for i, entry := range entries {
+		if j, ok := latest[entry.Data.ID]; !ok || entries[j].ID < entry.ID {
+			latest[entry.Data.ID] = i
+		}
+	}
+	var changes []domain.QueueEntry
+	replaced := make(map[string][]int64)
+	for i, entry := range entries {
+		if latest[entry.Data.ID] == i {
+			changes = append(changes, entry)
+		} else {
+			replaced[entry.Data.ID] = append(replaced[entry.Data.ID], entry.ID)
+		}
+	}
+	return changes, replaced
+}
@@ -0,0 +1,56 @@
+package private
+
+import (
+	"context"
+	"gokeeper/pkg/domain"
+)
+
+// Trash returns the deleted records with their metadata decrypted, most
+// recently deleted first. Payloads are not included. The local replica learns
+// the revisions of the records, which Undelete is based on.
+func (ps *Service) Trash(ctx context.Context, inputUser domain.InUserRequest) ([]domain.Data, error) {
+	jwt, err := ps.authorizeUser(ctx, &inputUser)
+	if err != nil {
+		return nil, err
+	}
+
+	var pds []domain.Data
+	err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+		pds, err = ps.privateClient.GetTrash(ctx, jwt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
+	if err != nil {
+		return nil, err
+	}
+	ps.cacheRecords(inputUser.Login, vaultKey, pds...)
+	for i := range pds {
+		if err = ps.openMeta(&pds[i], inputUser.Login, vaultKey); err != nil {
+			return nil, err
+		}
+	}
+	return pds, nil
+}
+
+// Undelete takes a record out of the trash, based on the revision of the
+// record in the local replica, which Trash or a sync keeps up to date.
+func (ps *Service) Undelete(ctx context.Context, id string, inputUser domain.InUserRequest) error {
+	jwt, err := ps.authorizeUser(ctx, &inputUser)
+	if err != nil {
+		return err
+	}
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
+	if err != nil {
+		return err
+	}
+
+	revision := ps.replicaRevision(inputUser.Login, vaultKey, id)
+	return ps.withRefresh(ctx, jwt, func(jwt string) error {
+		_, err := ps.privateClient.Undelete(ctx, id, revision, jwt)
+		return err
+	})
+}
@@ -0,0 +1,122 @@
+package private
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gokeeper/pkg/domain"
+	"gokeeper/pkg/encrypter"
+)
+
+const vaultKeyLen = 32
+
+// loadVaultKey returns the user's vault key, which encrypts every record. The
+// key is random and stored on the server wrapped with the account password,
+// so that changing the password only requires re-wrapping it. A key is
+// created on first use.
+func (ps *Service) loadVaultKey(ctx context.Context, inputUser domain.InUserRequest) ([]byte, error) {
+	if ps.vaultKey != nil && ps.vaultKeyLogin == inputUser.Login {
+		return ps.vaultKey, nil
+	}
+
+	wrappedKey, err := ps.authService.GetVaultKey(ctx)
+	if errors.Is(err, domain.ErrVaultKeyNotFound) {
+		wrappedKey, err = ps.createVaultKey(ctx, inputUser)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ps.unlockVaultKey(wrappedKey, inputUser)
+}
+
+// loadOfflineVaultKey returns the user's vault key like loadVaultKey, but
+// only from the copy cached on disk, so the server is never contacted.
+func (ps *Service) loadOfflineVaultKey(inputUser domain.InUserRequest) ([]byte, error) {
+	if ps.vaultKey != nil && ps.vaultKeyLogin == inputUser.Login {
+		return ps.vaultKey, nil
+	}
+
+	wrappedKey, err := ps.authService.GetCachedVaultKey()
+	if err != nil {
+		return nil, err
+	}
+	return ps.unlockVaultKey(wrappedKey, inputUser)
+}
+
+func (ps *Service) unlockVaultKey(wrappedKey []byte, inputUser domain.InUserRequest) ([]byte, error) {
+	key, err := ps.encrypter.UnwrapKey(wrappedKey, inputUser.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock vault key, check your password: %w", err)
+	}
+	if len(key) != vaultKeyLen {
+		return nil, fmt.Errorf("failed to unlock vault key: unexpected key length %d", len(key))
+	}
+
+	ps.vaultKey = key
+	ps.vaultKeyLogin = inputUser.Login
+	return ps.vaultKey, nil
+}
+
+func (ps *Service) createVaultKey(ctx context.Context, inputUser domain.InUserRequest) ([]byte, error) {
+	key := make([]byte, vaultKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := ps.encrypter.WrapKey(key, inputUser.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.authService.PutVaultKey(ctx, wrappedKey)
+	if errors.Is(err, domain.ErrVaultKeyConflict) {
+		// Another device created the key in the meantime.
+		return ps.authService.GetVaultKey(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wrappedKey, nil
+}
+
+// decrypt opens a record encrypted with the vault key and bound to ad.
+// Records of the baseline format fail with domain.ErrLegacyEncryption and
+// are only opened by decryptBaseline.
+func (ps *Service) decrypt(data, ad, vaultKey []byte) ([]byte, error) {
+	plain, err := ps.encrypter.DecryptMessage(data, ad, vaultKey)
+	if errors.Is(err, encrypter.ErrLegacyFormat) {
+		return nil, fmt.Errorf("%w: %w", domain.ErrLegacyEncryption, err)
+	}
+	return plain, err
+}
+
+// decryptBaseline opens a record encrypted with the vault key or, failing
+// that, a record of the baseline format encrypted with the login and
+// password before vault keys were introduced. The server could swap baseline
+// records for one another, so they are only opened to migrate them when the
+// user asks for it.
+func (ps *Service) decryptBaseline(data, ad, vaultKey []byte, inputUser domain.InUserRequest) ([]byte, error) {
+	plain, err := ps.decrypt(data, ad, vaultKey)
+	if err == nil {
+		return plain, nil
+	}
+	// A baseline record starts with a random nonce and may begin with the
+	// envelope magic by chance.
+	if plain, baselineErr := ps.encrypter.DecryptBaselineMessage(data, inputUser.Login, inputUser.Password); baselineErr == nil {
+		return plain, nil
+	}
+	return nil, err
+}
+
+// recordAD is the associated data a record is encrypted with. It binds the
+// ciphertext to its owner, id and type, so that the server can not swap the
+// data of two records without decryption failing.
+func recordAD(login, id string, dataType domain.Type) []byte {
+	var ad []byte
+	for _, field := range []string{login, id} {
+		ad = binary.BigEndian.AppendUint32(ad, uint32(len(field)))
+		ad = append(ad, field...)
+	}
+	return binary.BigEndian.AppendUint32(ad, uint32(dataType))
+}
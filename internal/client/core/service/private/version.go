@@ -0,0 +1,85 @@
+package private
+
+import (
+	"context"
+	"gokeeper/pkg/domain"
+	"io"
+)
+
+// History returns the versions of a record with their metadata decrypted,
+// oldest first. Payloads are not included.
+func (ps *Service) History(ctx context.Context, id string, inputUser domain.InUserRequest) ([]domain.Data, error) {
+	jwt, err := ps.authorizeUser(ctx, &inputUser)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []domain.Data
+	err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+		versions, err = ps.privateClient.GetVersions(ctx, id, jwt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vaultKey, err := ps.loadVaultKey(ctx, inputUser)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		versions[i].ID = id
+		if err = ps.openMeta(&versions[i], inputUser.Login, vaultKey); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+// OpenVersion is like Open, but for an earlier version of the record.
+func (ps *Service) OpenVersion(
+	ctx context.Context,
+	id string,
+	version int64,
+	inputUser domain.InUserRequest,
+) (*domain.Data, io.Reader, error) {
+	jwt, err := ps.authorizeUser(ctx, &inputUser)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pd *domain.Data
+	err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+		pd, err = ps.privateClient.GetVersion(ctx, id, version, jwt)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ps.open(ctx, jwt, pd, id, inputUser)
+}
+
+// Restore makes a copy of an earlier version the current one and returns the
+// number of the new version. Like Delete, it is based on the revision of the
+// record in the local replica, which may be the one of the record in the
+// trash.
+func (ps *Service) Restore(ctx context.Context, id string, version int64, inputUser domain.InUserRequest) (int64, error) {
+	jwt, err := ps.authorizeUser(ctx, &inputUser)
+	if err != nil {
+		return 0, err
+	}
+
+	var revision int64
+	if vaultKey, err := ps.loadOfflineVaultKey(inputUser); err == nil {
+		revision = ps.replicaRevision(inputUser.Login, vaultKey, id)
+	}
+	var pd *domain.Data
+	err = ps.withRefresh(ctx, jwt, func(jwt string) error {
+		pd, err = ps.privateClient.RestoreVersion(ctx, id, version, revision, jwt)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pd.Version, nil
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"gokeeper/internal/client/core/service/auth"
 	"gokeeper/internal/client/core/service/private"
+	"gokeeper/pkg/domain"
 )
 
 type Services struct {
@@ -12,15 +13,36 @@ type Services struct {
 
 func NewServices(
 	jwtFileWorker auth.JwtFileWorker,
+	refreshFileWorker auth.RefreshTokenFileWorker,
+	vaultKeyFileWorker auth.VaultKeyFileWorker,
 	authClient auth.Client,
 	personalClient private.Client,
 	encrypter private.Encrypter,
 	privateFileWorker private.FileWorker,
 	privateSender private.BulkSender,
+	blobClient private.BlobClient,
+	blobFileWorker private.BlobFileWorker,
+	replicaFileWorker private.ReplicaFileWorker,
+	encryptMeta bool,
+	blobChunkSize int64,
+	syncPolicy domain.ConflictPolicy,
 ) *Services {
-	authService := auth.NewAuthService(jwtFileWorker, authClient)
+	authService := auth.NewAuthService(jwtFileWorker, refreshFileWorker, vaultKeyFileWorker, authClient)
+	privateService := private.NewPrivateService(
+		authService,
+		personalClient,
+		encrypter,
+		privateFileWorker,
+		privateSender,
+		blobClient,
+		blobFileWorker,
+		replicaFileWorker,
+		encryptMeta,
+		blobChunkSize,
+		syncPolicy,
+	)
 	return &Services{
 		AuthService:    authService,
-		PrivateService: private.NewPrivateService(authService, personalClient, encrypter, privateFileWorker, privateSender),
+		PrivateService: privateService,
 	}
 }
@@ -0,0 +1,107 @@
+package fileworkers
+
+import (
+	"encoding/json"
+	"errors"
+	"gokeeper/pkg/domain"
+	"os"
+	"path/filepath"
+)
+
+const (
+	spoolPattern   = "*.spool"
+	uploadSuffix   = ".json"
+	downloadSuffix = ".part"
+)
+
+// BlobFileWorker keeps blob transfers in a directory, so that they can be
+// resumed after an interruption. An upload consists of a spool file with the
+// encrypted payload and a state file next to it; a download is a partial file
+// named after the blob.
+type BlobFileWorker struct {
+	dir string
+}
+
+func NewBlobFileWorker(dir string) *BlobFileWorker {
+	return &BlobFileWorker{
+		dir: dir,
+	}
+}
+
+// CreateSpool creates an empty spool file for a new upload.
+func (bfw *BlobFileWorker) CreateSpool() (*os.File, error) {
+	if err := os.MkdirAll(bfw.dir, 0700); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(bfw.dir, spoolPattern)
+}
+
+func (bfw *BlobFileWorker) OpenSpool(path string) (*os.File, error) {
+	return os.Open(path)
+}
+
+// SaveUpload stores the state of an upload. The state is replaced atomically,
+// so an interruption leaves either the old or the new one.
+func (bfw *BlobFileWorker) SaveUpload(upload domain.BlobUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	tmp := upload.Spool + uploadSuffix + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, upload.Spool+uploadSuffix)
+}
+
+// GetUploads returns the uploads that were not finished.
+func (bfw *BlobFileWorker) GetUploads() ([]domain.BlobUpload, error) {
+	paths, err := filepath.Glob(filepath.Join(bfw.dir, spoolPattern+uploadSuffix))
+	if err != nil {
+		return nil, err
+	}
+	uploads := make([]domain.BlobUpload, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var upload domain.BlobUpload
+		if err = json.Unmarshal(data, &upload); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+// DeleteUpload removes the state and the spool file of an upload.
+func (bfw *BlobFileWorker) DeleteUpload(upload domain.BlobUpload) error {
+	if err := os.Remove(upload.Spool + uploadSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(upload.Spool); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// OpenDownload opens the partial download of a blob, creating it if needed.
+func (bfw *BlobFileWorker) OpenDownload(blobID string) (*os.File, error) {
+	if err := os.MkdirAll(bfw.dir, 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(bfw.downloadPath(blobID), os.O_RDWR|os.O_CREATE, 0600)
+}
+
+func (bfw *BlobFileWorker) DeleteDownload(blobID string) error {
+	if err := os.Remove(bfw.downloadPath(blobID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (bfw *BlobFileWorker) downloadPath(blobID string) string {
+	// Blob ids come from the server, so only the base name is used.
+	return filepath.Join(bfw.dir, filepath.Base(blobID)+downloadSuffix)
+}
@@ -3,13 +3,21 @@ package fileworkers
 import "gokeeper/internal/client/core/config"
 
 type FileWorkers struct {
-	JWTWorker         *JwtFileWorker
-	PrivateFileWorker *PrivateFileWorker
+	JWTWorker          *JwtFileWorker
+	RefreshTokenWorker *RefreshTokenFileWorker
+	VaultKeyWorker     *VaultKeyFileWorker
+	PrivateFileWorker  *PrivateFileWorker
+	BlobFileWorker     *BlobFileWorker
+	ReplicaFileWorker  *ReplicaFileWorker
 }
 
 func NewFileWorkers(cfg *config.Config) *FileWorkers {
 	return &FileWorkers{
-		JWTWorker:         NewJwtFileWorker(cfg.JWTPath),
-		PrivateFileWorker: NewPrivateFileWorker(cfg.PrivateDataPath),
+		JWTWorker:          NewJwtFileWorker(cfg.JWTPath),
+		RefreshTokenWorker: NewRefreshTokenFileWorker(cfg.RefreshTokenPath),
+		VaultKeyWorker:     NewVaultKeyFileWorker(cfg.VaultKeyPath),
+		PrivateFileWorker:  NewPrivateFileWorker(cfg.PrivateDataPath),
+		BlobFileWorker:     NewBlobFileWorker(cfg.BlobDir),
+		ReplicaFileWorker:  NewReplicaFileWorker(cfg.ReplicaPath),
 	}
 }
@@ -1,7 +1,7 @@
 package fileworkers
 
 import (
-	"bytes"
+	"errors"
 	"gokeeper/pkg/auth"
 	"gokeeper/pkg/domain"
 	"os"
@@ -24,30 +24,19 @@ func (jfw *JwtFileWorker) Set(jwt string) error {
 	if !jfw.validateDate(jwt) {
 		return domain.ErrJWTTokenError
 	}
-
-	file, err := os.OpenFile(jfw.filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	_, err = file.Write([]byte(jwt))
-	return err
+	return writePrivateFile(jfw.filePath, []byte(jwt))
 }
 
 func (jfw *JwtFileWorker) Get() (string, error) {
-	file, err := os.OpenFile(jfw.filePath, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return "", err
+	data, err := readPrivateFile(jfw.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", domain.ErrJWTTokenError
 	}
-	defer file.Close()
-
-	var buf bytes.Buffer
-	_, err = buf.ReadFrom(file)
 	if err != nil {
 		return "", err
 	}
 
-	jwt := buf.String()
+	jwt := string(data)
 	if jfw.validateDate(jwt) {
 		return jwt, nil
 	}
@@ -55,6 +44,13 @@ func (jfw *JwtFileWorker) Get() (string, error) {
 	return "", domain.ErrJWTTokenError
 }
 
+func (jfw *JwtFileWorker) Delete() error {
+	if err := os.Remove(jfw.filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
 func (jfw *JwtFileWorker) validateDate(tokenStr string) bool {
 	claims := &auth.Claims{}
 	_, _, err := jwt.NewParser().ParseUnverified(tokenStr, claims)
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package fileworkers
+
+import "os"
+
+// lockFile does nothing on systems without flock, where running several
+// clients at once is not safe.
+func lockFile(_ *os.File) error {
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package fileworkers
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on the file, waiting for other processes
+// to release theirs. Closing the file releases the lock.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
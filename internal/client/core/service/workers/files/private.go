@@ -3,10 +3,22 @@ package fileworkers
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"gokeeper/pkg/domain"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 )
 
+// compactMinLines is the journal length from which it is rewritten once most
+// of its lines are obsolete.
+const compactMinLines = 64
+
+// PrivateFileWorker keeps the offline queue in an append-only journal of JSON
+// lines, each of which adds or updates an entry, or removes one. Every write
+// is synced to disk under an exclusive lock on a file next to the journal, so
+// the queue survives crashes and is shared safely between running clients.
 type PrivateFileWorker struct {
 	filePath string
 }
@@ -17,53 +29,286 @@ func NewPrivateFileWorker(filePath string) *PrivateFileWorker {
 	}
 }
 
-func (pfw *PrivateFileWorker) SaveMany(pd []domain.Data) error {
-	savedPrivateData, err := pfw.GetAll()
+// Append adds entries to the queue, numbering them.
+func (pfw *PrivateFileWorker) Append(entries ...domain.QueueEntry) error {
+	j, err := pfw.open()
 	if err != nil {
 		return err
 	}
+	defer j.close()
+
+	lines := make([]journalLine, len(entries))
+	for i, entry := range entries {
+		entry.ID = j.lastID + int64(i) + 1
+		lines[i] = journalLine{Entry: &entry}
+	}
+	return j.append(lines...)
+}
 
-	savedPrivateData = append(savedPrivateData, pd...)
-	newPrivateData, err := json.Marshal(savedPrivateData)
+// GetAll returns the queued entries in the order they were added.
+func (pfw *PrivateFileWorker) GetAll() ([]domain.QueueEntry, error) {
+	j, err := pfw.open()
+	if err != nil {
+		return nil, err
+	}
+	defer j.close()
+
+	return j.list(), nil
+}
+
+// Update replaces the state of a queued entry. An entry removed in the
+// meantime stays removed.
+func (pfw *PrivateFileWorker) Update(entry domain.QueueEntry) error {
+	j, err := pfw.open()
 	if err != nil {
 		return err
 	}
+	defer j.close()
+
+	if _, ok := j.entries[entry.ID]; !ok {
+		return nil
+	}
+	return j.append(journalLine{Entry: &entry})
+}
 
-	file, err := os.OpenFile(pfw.filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+// Remove takes acknowledged entries off the queue. Unknown ids are ignored.
+func (pfw *PrivateFileWorker) Remove(ids ...int64) error {
+	j, err := pfw.open()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	_, err = file.Write(newPrivateData)
-	return err
+	defer j.close()
+
+	var lines []journalLine
+	for _, id := range ids {
+		if _, ok := j.entries[id]; ok {
+			lines = append(lines, journalLine{Remove: id})
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	if err = j.append(lines...); err != nil {
+		return err
+	}
+	return j.compact()
 }
 
-func (pfw *PrivateFileWorker) GetAll() ([]domain.Data, error) {
-	file, err := os.OpenFile(pfw.filePath, os.O_RDONLY|os.O_CREATE, 0666)
+// Drop takes an entry off the queue without sending it.
+func (pfw *PrivateFileWorker) Drop(id int64) error {
+	j, err := pfw.open()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer file.Close()
+	defer j.close()
 
-	var buf bytes.Buffer
-	_, err = buf.ReadFrom(file)
+	if _, ok := j.entries[id]; !ok {
+		return domain.ErrQueueEntryNotFound
+	}
+	if err = j.append(journalLine{Remove: id}); err != nil {
+		return err
+	}
+	return j.compact()
+}
+
+// journalLine is a line of the journal. It holds either the new state of an
+// entry or the id of a removed one.
+type journalLine struct {
+	Entry  *domain.QueueEntry `json:"entry,omitempty"`
+	Remove int64              `json:"remove,omitempty"`
+}
+
+// journal is the opened and locked queue with its entries replayed.
+type journal struct {
+	path    string
+	lock    *os.File
+	file    *os.File
+	entries map[int64]domain.QueueEntry
+	lastID  int64
+	lines   int
+}
+
+func (pfw *PrivateFileWorker) open() (*journal, error) {
+	if err := os.MkdirAll(filepath.Dir(pfw.filePath), 0700); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(pfw.filePath+".lock", os.O_RDWR|os.O_CREATE|noFollow, 0600)
 	if err != nil {
 		return nil, err
 	}
+	if err = lockFile(lock); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	file, err := os.OpenFile(pfw.filePath, os.O_RDWR|os.O_CREATE|noFollow, 0600)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
 
-	if buf.Len() == 0 {
-		return []domain.Data{}, nil
+	j := &journal{
+		path:    pfw.filePath,
+		lock:    lock,
+		file:    file,
+		entries: make(map[int64]domain.QueueEntry),
+	}
+	if err = j.load(); err != nil {
+		j.close()
+		return nil, err
 	}
+	return j, nil
+}
 
-	var savedPrivateData []domain.Data
-	err = json.Unmarshal(buf.Bytes(), &savedPrivateData)
+// close releases the lock.
+func (j *journal) close() {
+	j.file.Close()
+	j.lock.Close()
+}
+
+// load replays the journal. A last line without its newline is a write cut
+// short by a crash and is cut off. A queue stored by earlier versions as a
+// JSON array of records is converted.
+func (j *journal) load() error {
+	data, err := io.ReadAll(j.file)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return j.convert(trimmed)
+	}
+
+	valid := 0
+	for {
+		n := bytes.IndexByte(data[valid:], '\n')
+		if n < 0 {
+			break
+		}
+		var line journalLine
+		if err = json.Unmarshal(data[valid:valid+n], &line); err != nil {
+			return fmt.Errorf("corrupted queue journal %s at line %d: %w", j.path, j.lines+1, err)
+		}
+		j.apply(line)
+		valid += n + 1
+	}
+	if valid < len(data) {
+		if err = j.file.Truncate(int64(valid)); err != nil {
+			return err
+		}
+		return j.file.Sync()
+	}
+	return nil
+}
+
+func (j *journal) convert(data []byte) error {
+	var pds []domain.Data
+	if err := json.Unmarshal(data, &pds); err != nil {
+		return fmt.Errorf("corrupted queue %s: %w", j.path, err)
+	}
+	for i, pd := range pds {
+		j.apply(journalLine{Entry: &domain.QueueEntry{
+			ID:       int64(i) + 1,
+			Op:       domain.QueueSave,
+			Data:     pd,
+			QueuedAt: pd.SavedAt,
+		}})
+	}
+	return j.rewrite()
+}
+
+func (j *journal) apply(line journalLine) {
+	if line.Entry != nil {
+		j.entries[line.Entry.ID] = *line.Entry
+		j.lastID = max(j.lastID, line.Entry.ID)
+	}
+	if line.Remove != 0 {
+		delete(j.entries, line.Remove)
+	}
+	j.lines++
+}
+
+func (j *journal) list() []domain.QueueEntry {
+	entries := make([]domain.QueueEntry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, k int) bool {
+		return entries[i].ID < entries[k].ID
+	})
+	return entries
+}
+
+// append writes the lines in one go and syncs them to disk.
+func (j *journal) append(lines ...journalLine) error {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	if _, err := j.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err := j.file.Write(buf.Bytes()); err != nil {
+		return err
 	}
+	for _, line := range lines {
+		j.apply(line)
+	}
+	return j.file.Sync()
+}
 
-	return savedPrivateData, nil
+// compact empties the journal once the queue is empty, and rewrites it once
+// most of its lines are obsolete.
+func (j *journal) compact() error {
+	if len(j.entries) == 0 {
+		if err := j.file.Truncate(0); err != nil {
+			return err
+		}
+		return j.file.Sync()
+	}
+	if j.lines < compactMinLines || j.lines < 2*len(j.entries) {
+		return nil
+	}
+	return j.rewrite()
 }
 
-func (pfw *PrivateFileWorker) DeleteAll() error {
-	return os.Remove(pfw.filePath)
+// rewrite replaces the journal atomically with one line per entry.
+func (j *journal) rewrite() error {
+	tmp, err := os.OpenFile(j.path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC|noFollow, 0600)
+	if err != nil {
+		return err
+	}
+	for _, entry := range j.list() {
+		data, err := json.Marshal(journalLine{Entry: &entry})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err = tmp.Write(append(data, '\n')); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(j.path+".tmp", j.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_RDWR|noFollow, 0600)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	j.lines = len(j.entries)
+	return nil
 }
@@ -0,0 +1,54 @@
+package fileworkers
+
+import (
+	"gokeeper/pkg/domain"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writePrivateFile replaces the file at path with data, readable only by the
+// user. The data is written to a new temporary file, which can not be a link
+// planted by someone else, and renamed over the old file, which replaces a
+// link instead of following it. The directory is created with mode 0700 if
+// it does not exist.
+func writePrivateFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readPrivateFile reads the file at path without following a link and fails
+// with domain.ErrInsecureFile if the file is not owned by the user or is
+// accessible to others.
+func readPrivateFile(path string) ([]byte, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|noFollow, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || !ownedByUser(info) || info.Mode().Perm()&0077 != 0 {
+		return nil, domain.ErrInsecureFile
+	}
+	return io.ReadAll(file)
+}
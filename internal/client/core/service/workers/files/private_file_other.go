@@ -0,0 +1,14 @@
+//go:build !unix
+
+package fileworkers
+
+import "os"
+
+// noFollow is not supported on systems other than unix.
+const noFollow = 0
+
+// ownedByUser can not tell the owner of a file outside unix, where access is
+// left to the file's ACL.
+func ownedByUser(_ os.FileInfo) bool {
+	return true
+}
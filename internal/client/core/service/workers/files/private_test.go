@@ -0,0 +1,237 @@
+package fileworkers
+
+import (
+	"bytes"
+	"errors"
+	"gokeeper/pkg/domain"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T) (*PrivateFileWorker, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	return NewPrivateFileWorker(path), path
+}
+
+func saveEntry(id string) domain.QueueEntry {
+	return domain.QueueEntry{Op: domain.QueueSave, Data: domain.Data{ID: id}, QueuedAt: time.Now()}
+}
+
+func queuedIDs(t *testing.T, pfw *PrivateFileWorker) []string {
+	t.Helper()
+	entries, err := pfw.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.Data.ID)
+	}
+	return ids
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestQueueJournal(t *testing.T) {
+	pfw, path := newTestQueue(t)
+
+	if err := pfw.Append(saveEntry("a"), saveEntry("b")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := pfw.Append(saveEntry("c")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	entries, err := pfw.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	for i, entry := range entries {
+		if entry.ID != int64(i)+1 {
+			t.Fatalf("entry %s has id %d, want %d", entry.Data.ID, entry.ID, i+1)
+		}
+	}
+
+	b := entries[1]
+	b.Attempts = 2
+	b.LastError = "conflict"
+	if err = pfw.Update(b); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err = pfw.Remove(1, 42); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	// Another worker on the same file replays the journal.
+	entries, err = NewPrivateFileWorker(path).GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Data.ID != "b" || entries[0].Attempts != 2 || entries[1].Data.ID != "c" {
+		t.Fatalf("GetAll after reopening = %+v", entries)
+	}
+
+	// Ids are not reused after the last entry was removed.
+	if err = pfw.Remove(3); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err = pfw.Append(saveEntry("d")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if entries, _ = pfw.GetAll(); entries[len(entries)-1].ID != 4 {
+		t.Fatalf("appended entry has id %d, want 4", entries[len(entries)-1].ID)
+	}
+}
+
+func TestQueueUpdateAndDropRemoved(t *testing.T) {
+	pfw, _ := newTestQueue(t)
+	if err := pfw.Append(saveEntry("a")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	entries, _ := pfw.GetAll()
+	if err := pfw.Drop(entries[0].ID); err != nil {
+		t.Fatalf("Drop: %v", err)
+	}
+
+	// An entry removed while it was being sent stays removed.
+	entries[0].Attempts = 1
+	if err := pfw.Update(entries[0]); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if ids := queuedIDs(t, pfw); len(ids) != 0 {
+		t.Fatalf("Update brought back entries %v", ids)
+	}
+	if err := pfw.Drop(entries[0].ID); !errors.Is(err, domain.ErrQueueEntryNotFound) {
+		t.Fatalf("Drop of a removed entry = %v, want %v", err, domain.ErrQueueEntryNotFound)
+	}
+}
+
+func TestQueueTornWrite(t *testing.T) {
+	pfw, path := newTestQueue(t)
+	if err := pfw.Append(saveEntry("a")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	valid, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	// A crash in the middle of a write leaves a line without its newline.
+	if err = os.WriteFile(path, append(bytes.Clone(valid), `{"entry":{"id":2,"op":"sa`...), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if ids := queuedIDs(t, pfw); len(ids) != 1 || ids[0] != "a" {
+		t.Fatalf("GetAll after a torn write = %v", ids)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, valid) {
+		t.Fatalf("the torn line was not cut off: %q", data)
+	}
+	if err = pfw.Append(saveEntry("b")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if ids := queuedIDs(t, pfw); len(ids) != 2 {
+		t.Fatalf("GetAll after appending = %v", ids)
+	}
+}
+
+func TestQueueCorrupted(t *testing.T) {
+	pfw, path := newTestQueue(t)
+	if err := os.WriteFile(path, []byte("{\"entry\":{\"id\":1}}\nnot json\n{\"remove\":1}\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := pfw.GetAll(); err == nil {
+		t.Fatalf("GetAll of a corrupted journal succeeded")
+	}
+	if err := pfw.Append(saveEntry("a")); err == nil {
+		t.Fatalf("Append to a corrupted journal succeeded")
+	}
+}
+
+func TestQueueConvertsArray(t *testing.T) {
+	pfw, path := newTestQueue(t)
+	savedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	legacy := `[{"id":"a","saved_at":"2024-03-01T12:00:00Z"},{"id":"b","saved_at":"2024-03-01T12:00:00Z"}]`
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	entries, err := pfw.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(entries) != 2 || entries[1].ID != 2 || entries[1].Op != domain.QueueSave || !entries[1].QueuedAt.Equal(savedAt) {
+		t.Fatalf("converted entries = %+v", entries)
+	}
+	if countLines(t, path) != 2 {
+		t.Fatalf("the queue was not rewritten as a journal")
+	}
+}
+
+func TestQueueCompaction(t *testing.T) {
+	pfw, path := newTestQueue(t)
+	if err := pfw.Append(saveEntry("a"), saveEntry("b")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	entries, _ := pfw.GetAll()
+	for i := range compactMinLines {
+		entries[0].Attempts = i + 1
+		if err := pfw.Update(entries[0]); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+	if err := pfw.Remove(entries[1].ID); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if lines := countLines(t, path); lines != 1 {
+		t.Fatalf("journal has %d lines after compaction, want 1", lines)
+	}
+	if entries, _ = pfw.GetAll(); len(entries) != 1 || entries[0].Attempts != compactMinLines {
+		t.Fatalf("GetAll after compaction = %+v", entries)
+	}
+
+	if err := pfw.Remove(entries[0].ID); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Fatalf("journal of an empty queue = %v, %v", info, err)
+	}
+}
+
+func TestQueueConcurrentAppends(t *testing.T) {
+	_, path := newTestQueue(t)
+
+	// Workers of separate clients share the journal through its lock.
+	const clients, appends = 4, 10
+	var wg sync.WaitGroup
+	for range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pfw := NewPrivateFileWorker(path)
+			for range appends {
+				if err := pfw.Append(saveEntry("x")); err != nil {
+					t.Errorf("Append: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := NewPrivateFileWorker(path).GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(entries) != clients*appends || entries[len(entries)-1].ID != clients*appends {
+		t.Fatalf("GetAll after concurrent appends = %d entries, last id %d", len(entries), entries[len(entries)-1].ID)
+	}
+}
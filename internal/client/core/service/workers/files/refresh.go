@@ -0,0 +1,39 @@
+package fileworkers
+
+import (
+	"errors"
+	"gokeeper/pkg/domain"
+	"os"
+)
+
+type RefreshTokenFileWorker struct {
+	filePath string
+}
+
+func NewRefreshTokenFileWorker(filePath string) *RefreshTokenFileWorker {
+	return &RefreshTokenFileWorker{
+		filePath: filePath,
+	}
+}
+
+func (rfw *RefreshTokenFileWorker) Set(token string) error {
+	return writePrivateFile(rfw.filePath, []byte(token))
+}
+
+func (rfw *RefreshTokenFileWorker) Get() (string, error) {
+	data, err := readPrivateFile(rfw.filePath)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(data) == 0) {
+		return "", domain.ErrJWTTokenError
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (rfw *RefreshTokenFileWorker) Delete() error {
+	if err := os.Remove(rfw.filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package fileworkers
+
+import (
+	"errors"
+	"gokeeper/pkg/domain"
+	"os"
+)
+
+// ReplicaFileWorker keeps the local replica of the user's records. The
+// replica is stored encrypted as a whole, so this worker only deals with
+// ciphertext.
+type ReplicaFileWorker struct {
+	filePath string
+}
+
+func NewReplicaFileWorker(filePath string) *ReplicaFileWorker {
+	return &ReplicaFileWorker{
+		filePath: filePath,
+	}
+}
+
+// Set replaces the replica atomically, so an interruption leaves either the
+// old or the new one.
+func (rfw *ReplicaFileWorker) Set(replica []byte) error {
+	return writePrivateFile(rfw.filePath, replica)
+}
+
+func (rfw *ReplicaFileWorker) Get() ([]byte, error) {
+	replica, err := readPrivateFile(rfw.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, domain.ErrReplicaNotFound
+		}
+		return nil, err
+	}
+	return replica, nil
+}
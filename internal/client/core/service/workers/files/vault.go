@@ -0,0 +1,35 @@
+package fileworkers
+
+import (
+	"errors"
+	"gokeeper/pkg/domain"
+	"os"
+)
+
+// VaultKeyFileWorker caches the wrapped vault key, so that records can be
+// encrypted while the server is unreachable. The key is stored exactly as the
+// server holds it, wrapped with the account password.
+type VaultKeyFileWorker struct {
+	filePath string
+}
+
+func NewVaultKeyFileWorker(filePath string) *VaultKeyFileWorker {
+	return &VaultKeyFileWorker{
+		filePath: filePath,
+	}
+}
+
+func (vfw *VaultKeyFileWorker) Set(wrappedKey []byte) error {
+	return writePrivateFile(vfw.filePath, wrappedKey)
+}
+
+func (vfw *VaultKeyFileWorker) Get() ([]byte, error) {
+	wrappedKey, err := readPrivateFile(vfw.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, domain.ErrVaultKeyNotFound
+		}
+		return nil, err
+	}
+	return wrappedKey, nil
+}
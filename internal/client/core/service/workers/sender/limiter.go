@@ -0,0 +1,140 @@
+package sender
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket spaces out requests to rate per second on average, letting
+// through up to burst at once. A rate of zero does not limit. The server may
+// also ask to pause all requests for a while.
+type tokenBucket struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	resumeAt time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(max(burst, 1)),
+		tokens: float64(max(burst, 1)),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a request may be sent.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		delay := b.reserve()
+		if delay <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token and returns zero, or returns how long to wait for
+// one.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(b.resumeAt) {
+		return b.resumeAt.Sub(now)
+	}
+	if b.rate <= 0 {
+		return 0
+	}
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// pause holds back all requests for d.
+func (b *tokenBucket) pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if resumeAt := time.Now().Add(d); resumeAt.After(b.resumeAt) {
+		b.resumeAt = resumeAt
+	}
+}
+
+// gate limits the number of requests in flight. The limit is halved when a
+// request fails and grows by one after as many successful requests as the
+// limit, up to max.
+type gate struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	max       int
+	active    int
+	successes int
+}
+
+func newGate(limit int) *gate {
+	g := &gate{
+		limit: max(limit, 1),
+		max:   max(limit, 1),
+	}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// acquire waits for a free slot. It gives up once ctx is done and wake is
+// called.
+func (g *gate) acquire(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.active >= g.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		g.cond.Wait()
+	}
+	g.active++
+	return nil
+}
+
+// release frees the slot of a request that succeeded or failed.
+func (g *gate) release(succeeded bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.active--
+	if succeeded {
+		g.successes++
+		if g.successes >= g.limit && g.limit < g.max {
+			g.limit++
+			g.successes = 0
+		}
+	} else {
+		g.limit = max(g.limit/2, 1)
+		g.successes = 0
+	}
+	g.cond.Broadcast()
+}
+
+func (g *gate) wake() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.cond.Broadcast()
+}
@@ -0,0 +1,121 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 3)
+	for i := range 3 {
+		if delay := b.reserve(); delay != 0 {
+			t.Fatalf("request %d of the burst waits %v", i+1, delay)
+		}
+	}
+	// The next token comes after a tenth of a second.
+	if delay := b.reserve(); delay <= 0 || delay > 100*time.Millisecond {
+		t.Fatalf("request after the burst waits %v, want up to 100ms", delay)
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	b := newTokenBucket(0, 0)
+	for range 1000 {
+		if delay := b.reserve(); delay != 0 {
+			t.Fatalf("a bucket without rate waits %v", delay)
+		}
+	}
+}
+
+func TestTokenBucketPause(t *testing.T) {
+	b := newTokenBucket(0, 0)
+	b.pause(time.Hour)
+	b.pause(time.Millisecond)
+	if delay := b.reserve(); delay < 59*time.Minute {
+		t.Fatalf("a shorter pause cut the longer one to %v", delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait during a pause = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	b = newTokenBucket(0, 0)
+	b.pause(20 * time.Millisecond)
+	start := time.Now()
+	if err := b.wait(context.Background()); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("wait returned after %v, before the pause ended", elapsed)
+	}
+}
+
+func TestGateLimit(t *testing.T) {
+	g := newGate(8)
+	ctx := context.Background()
+
+	// Failures halve the limit down to one.
+	for _, want := range []int{4, 2, 1, 1} {
+		g.acquire(ctx)
+		g.release(false)
+		if g.limit != want {
+			t.Fatalf("limit after a failure = %d, want %d", g.limit, want)
+		}
+	}
+	// It grows by one after as many successes as the limit, up to the max.
+	for _, want := range []int{2, 2, 3, 3, 3, 4} {
+		g.acquire(ctx)
+		g.release(true)
+		if g.limit != want {
+			t.Fatalf("limit after a success = %d, want %d", g.limit, want)
+		}
+	}
+	for range 100 {
+		g.acquire(ctx)
+		g.release(true)
+	}
+	if g.limit != 8 {
+		t.Fatalf("limit after many successes = %d, want 8", g.limit)
+	}
+}
+
+func TestGateAcquire(t *testing.T) {
+	g := newGate(1)
+	if err := g.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- g.acquire(context.Background())
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("acquire of a full gate returned")
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.release(true)
+	if err := <-acquired; err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+
+	// A waiting acquire gives up once its context is done and it is woken.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		acquired <- g.acquire(ctx)
+	}()
+	cancel()
+	g.wake()
+	select {
+	case err := <-acquired:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("acquire after cancel = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("acquire did not give up")
+	}
+}
@@ -10,54 +10,255 @@ import (
 )
 
 type PrivateClient interface {
-	Save(ctx context.Context, pd domain.Data, jwt string) error
+	Save(ctx context.Context, pd domain.Data, jwt string) (int64, error)
+	Delete(ctx context.Context, pd domain.DeleteRequest, jwt string) error
+	Capabilities(ctx context.Context) (domain.Capabilities, error)
+	Batch(ctx context.Context, ops []domain.BatchOp, bestEffort bool, jwt string) ([]error, error)
 }
 
+// Sender sends queued changes with a number of workers. Requests are spaced
+// out by a token bucket, and the number of them in flight is cut down while
+// they fail and raised again as they succeed. A change is retried with
+// exponentially growing pauses as long as the failure may be temporary, and
+// a server that throttles the client holds back all workers for as long as
+// it asks. If the server supports batches, changes are sent in batches of as
+// many as it accepts.
 type Sender struct {
-	workersNum     int64
+	workersNum    int64
+	rate          float64
+	burst         int
+	retries       int
+	backoff       time.Duration
 	privateClient PrivateClient
 }
 
-func NewSender(workersNum int64, privateClient PrivateClient) *Sender {
+func NewSender(
+	workersNum int64,
+	rate float64,
+	burst int,
+	retries int,
+	backoff time.Duration,
+	privateClient PrivateClient,
+) *Sender {
 	return &Sender{
-		workersNum:     workersNum,
+		workersNum:    workersNum,
+		rate:          rate,
+		burst:         burst,
+		retries:       retries,
+		backoff:       backoff,
 		privateClient: privateClient,
 	}
 }
 
-func (ps *Sender) doWork(ctx context.Context, pdChannel <-chan domain.Data, jwt string) error {
-	for pd := range pdChannel {
+// batch is the state shared by the workers of one Send.
+type batch struct {
+	jwt     string
+	limiter *tokenBucket
+	gate    *gate
+	done    func(entry domain.QueueEntry, err error)
+}
+
+func (ps *Sender) doWork(ctx context.Context, chunks <-chan []domain.QueueEntry, b *batch) error {
+	for chunk := range chunks {
 		select {
 		case <-ctx.Done():
 			return errors.New("graceful shutdown")
 		default:
-			err := ps.privateClient.Save(ctx, pd, jwt)
+			var err error
+			if len(chunk) == 1 {
+				err = ps.sendOne(ctx, chunk[0], b)
+			} else {
+				err = ps.sendBatch(ctx, chunk, b)
+			}
 			if err != nil {
 				return err
 			}
 		}
-		time.Sleep(time.Second)
 	}
 	return nil
 }
 
-func (ps *Sender) Send(ctx context.Context, pds []domain.Data, jwt string) error {
-	pdChannel := make(chan domain.Data, len(pds))
-	for _, pd := range pds {
-		pdChannel <- pd
+// Send sends the changes and reports the outcome of each to done, which is
+// called concurrently. Failed attempts are counted in the entry's Attempts.
+// A change the server rejects does not stop the others, but sending stops if
+// the server stays unreachable or rejects the token; the error is returned
+// then, and the changes not tried yet are not reported.
+func (ps *Sender) Send(
+	ctx context.Context,
+	entries []domain.QueueEntry,
+	jwt string,
+	done func(entry domain.QueueEntry, err error),
+) error {
+	chunkSize := 1
+	if capabilities, err := ps.privateClient.Capabilities(ctx); err == nil && capabilities.MaxBatch > 1 {
+		chunkSize = capabilities.MaxBatch
+	}
+	chunkChannel := make(chan []domain.QueueEntry, len(entries)/chunkSize+1)
+	for start := 0; start < len(entries); start += chunkSize {
+		chunkChannel <- entries[start:min(start+chunkSize, len(entries))]
 	}
-	close(pdChannel)
+	close(chunkChannel)
 
-	wg := new(errgroup.Group)
+	wg, ctx := errgroup.WithContext(ctx)
+	b := &batch{
+		jwt:     jwt,
+		limiter: newTokenBucket(ps.rate, ps.burst),
+		gate:    newGate(int(ps.workersNum)),
+		done:    done,
+	}
+	// Workers waiting for a slot give up once sending is stopped.
+	stop := context.AfterFunc(ctx, b.gate.wake)
+	defer stop()
 
 	for w := 0; w < int(ps.workersNum); w++ {
 		wg.Go(func() error {
-			return ps.doWork(ctx, pdChannel, jwt)
+			return ps.doWork(ctx, chunkChannel, b)
 		})
 	}
 
-	if err := wg.Wait(); err != nil {
+	return wg.Wait()
+}
+
+// sendOne sends a change on its own.
+func (ps *Sender) sendOne(ctx context.Context, entry domain.QueueEntry, b *batch) error {
+	err := ps.sendWithRetries(ctx, &entry, b)
+	if errors.Is(err, domain.ErrUserAuthentication) || err != nil && ctx.Err() != nil {
+		// The change was not refused, sending was stopped.
+		return err
+	}
+	b.done(entry, err)
+	if err != nil && !isRejected(err) {
 		return err
 	}
 	return nil
 }
+
+// sendBatch sends the changes in one request in best effort mode, and
+// retries those which failed for a reason that may be temporary. A batch the
+// server refuses as a whole is sent change by change, so that only the
+// offending change is rejected.
+func (ps *Sender) sendBatch(ctx context.Context, entries []domain.QueueEntry, b *batch) error {
+	pending := append([]domain.QueueEntry(nil), entries...)
+	pause := ps.backoff
+	for retry := 0; ; retry++ {
+		if err := b.limiter.wait(ctx); err != nil {
+			return err
+		}
+		if err := b.gate.acquire(ctx); err != nil {
+			return err
+		}
+		ops := make([]domain.BatchOp, len(pending))
+		for i, entry := range pending {
+			if entry.Op == domain.QueueDelete {
+				deleteRequest := entry.DeleteRequest()
+				ops[i].Delete = &deleteRequest
+			} else {
+				ops[i].Save = &entry.Data
+			}
+		}
+		errs, err := ps.privateClient.Batch(ctx, ops, true, b.jwt)
+		if errors.Is(err, domain.ErrPrivateDataBadFormat) {
+			b.gate.release(true)
+			for _, entry := range pending {
+				if err = ps.sendOne(ctx, entry, b); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if errors.Is(err, domain.ErrUserAuthentication) || err != nil && ctx.Err() != nil {
+			b.gate.release(false)
+			return err
+		}
+
+		var failed []domain.QueueEntry
+		var failedErrs []error
+		for i := range pending {
+			itemErr := err
+			if err == nil {
+				itemErr = errs[i]
+			}
+			if itemErr == nil {
+				b.done(pending[i], nil)
+				continue
+			}
+			pending[i].Attempts++
+			if isRejected(itemErr) {
+				b.done(pending[i], itemErr)
+				continue
+			}
+			failed = append(failed, pending[i])
+			failedErrs = append(failedErrs, itemErr)
+		}
+		b.gate.release(len(failed) == 0)
+		if len(failed) == 0 {
+			return nil
+		}
+		if retry == ps.retries {
+			for i, entry := range failed {
+				b.done(entry, failedErrs[i])
+			}
+			return failedErrs[0]
+		}
+		pending = failed
+
+		var throttled *domain.TooManyRequestsError
+		if errors.As(err, &throttled) && throttled.RetryAfter > 0 {
+			b.limiter.pause(throttled.RetryAfter)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+		pause *= 2
+	}
+}
+
+func (ps *Sender) sendWithRetries(ctx context.Context, entry *domain.QueueEntry, b *batch) error {
+	pause := ps.backoff
+	for retry := 0; ; retry++ {
+		if err := b.limiter.wait(ctx); err != nil {
+			return err
+		}
+		if err := b.gate.acquire(ctx); err != nil {
+			return err
+		}
+		err := ps.send(ctx, *entry, b.jwt)
+		b.gate.release(err == nil || isRejected(err))
+		if err == nil {
+			return nil
+		}
+		entry.Attempts++
+		if retry == ps.retries || isRejected(err) || errors.Is(err, domain.ErrUserAuthentication) {
+			return err
+		}
+
+		var throttled *domain.TooManyRequestsError
+		if errors.As(err, &throttled) && throttled.RetryAfter > 0 {
+			b.limiter.pause(throttled.RetryAfter)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+		pause *= 2
+	}
+}
+
+func (ps *Sender) send(ctx context.Context, entry domain.QueueEntry, jwt string) error {
+	if entry.Op == domain.QueueDelete {
+		return ps.privateClient.Delete(ctx, entry.DeleteRequest(), jwt)
+	}
+	_, err := ps.privateClient.Save(ctx, entry.Data, jwt)
+	return err
+}
+
+// isRejected reports whether the server refused the change itself, in which
+// case retrying is pointless.
+func isRejected(err error) bool {
+	return errors.Is(err, domain.ErrPrivateDataConflict) || errors.Is(err, domain.ErrPrivateDataBadFormat)
+}
@@ -0,0 +1,226 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"gokeeper/pkg/domain"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClient answers each request with the next error queued for the record,
+// or succeeds once there is none left.
+type fakeClient struct {
+	mu       sync.Mutex
+	maxBatch int
+	errs     map[string][]error
+	// batchErr, if set, fails every batch as a whole.
+	batchErr error
+	saves    []string
+	batches  [][]string
+}
+
+func (c *fakeClient) next(id string) error {
+	errs := c.errs[id]
+	if len(errs) == 0 {
+		return nil
+	}
+	c.errs[id] = errs[1:]
+	return errs[0]
+}
+
+func (c *fakeClient) Save(_ context.Context, pd domain.Data, _ string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.saves = append(c.saves, pd.ID)
+	return 1, c.next(pd.ID)
+}
+
+func (c *fakeClient) Delete(_ context.Context, req domain.DeleteRequest, _ string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.saves = append(c.saves, req.ID)
+	return c.next(req.ID)
+}
+
+func (c *fakeClient) Capabilities(context.Context) (domain.Capabilities, error) {
+	return domain.Capabilities{MaxBatch: c.maxBatch}, nil
+}
+
+func (c *fakeClient) Batch(_ context.Context, ops []domain.BatchOp, _ bool, _ string) ([]error, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]string, len(ops))
+	for i, op := range ops {
+		ids[i] = op.ID()
+	}
+	c.batches = append(c.batches, ids)
+	if c.batchErr != nil {
+		return nil, c.batchErr
+	}
+	errs := make([]error, len(ops))
+	for i, id := range ids {
+		errs[i] = c.next(id)
+	}
+	return errs, nil
+}
+
+// outcomes collects what Send reports per record.
+type outcomes struct {
+	mu      sync.Mutex
+	entries map[string]domain.QueueEntry
+	errs    map[string]error
+}
+
+func (o *outcomes) done(entry domain.QueueEntry, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.entries[entry.Data.ID] = entry
+	o.errs[entry.Data.ID] = err
+}
+
+func send(t *testing.T, c *fakeClient, retries int, ids ...string) (*outcomes, error) {
+	t.Helper()
+	entries := make([]domain.QueueEntry, len(ids))
+	for i, id := range ids {
+		entries[i] = domain.QueueEntry{ID: int64(i) + 1, Op: domain.QueueSave, Data: domain.Data{ID: id}}
+	}
+	o := &outcomes{entries: make(map[string]domain.QueueEntry), errs: make(map[string]error)}
+	s := NewSender(2, 0, 1, retries, time.Millisecond, c)
+	return o, s.Send(context.Background(), entries, "jwt", o.done)
+}
+
+func TestSendRetries(t *testing.T) {
+	c := &fakeClient{errs: map[string][]error{
+		"a": {domain.ErrServerUnavailable, domain.ErrInternalServerError},
+	}}
+	o, err := send(t, c, 3, "a", "b")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if o.errs["a"] != nil || o.entries["a"].Attempts != 2 {
+		t.Fatalf("a was reported with %v after %d failed attempts", o.errs["a"], o.entries["a"].Attempts)
+	}
+	if _, ok := o.errs["b"]; !ok || o.errs["b"] != nil {
+		t.Fatalf("b was not reported as sent")
+	}
+}
+
+func TestSendGivesUp(t *testing.T) {
+	c := &fakeClient{errs: map[string][]error{
+		"a": {domain.ErrServerUnavailable, domain.ErrServerUnavailable, domain.ErrServerUnavailable},
+	}}
+	o, err := send(t, c, 2, "a")
+	if !errors.Is(err, domain.ErrServerUnavailable) {
+		t.Fatalf("Send = %v, want %v", err, domain.ErrServerUnavailable)
+	}
+	if !errors.Is(o.errs["a"], domain.ErrServerUnavailable) || o.entries["a"].Attempts != 3 {
+		t.Fatalf("a was reported with %v after %d attempts", o.errs["a"], o.entries["a"].Attempts)
+	}
+}
+
+func TestSendRejected(t *testing.T) {
+	c := &fakeClient{errs: map[string][]error{
+		"a": {domain.ErrPrivateDataConflict},
+	}}
+	o, err := send(t, c, 3, "a", "b", "c")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	// A rejected change is not retried and does not stop the others.
+	if !errors.Is(o.errs["a"], domain.ErrPrivateDataConflict) || o.entries["a"].Attempts != 1 {
+		t.Fatalf("a was reported with %v after %d attempts", o.errs["a"], o.entries["a"].Attempts)
+	}
+	if len(o.errs) != 3 || o.errs["b"] != nil || o.errs["c"] != nil {
+		t.Fatalf("reported %v", o.errs)
+	}
+}
+
+func TestSendStopsOnAuthentication(t *testing.T) {
+	c := &fakeClient{errs: map[string][]error{
+		"a": {domain.ErrUserAuthentication},
+	}}
+	o, err := send(t, c, 3, "a")
+	if !errors.Is(err, domain.ErrUserAuthentication) {
+		t.Fatalf("Send = %v, want %v", err, domain.ErrUserAuthentication)
+	}
+	if _, ok := o.errs["a"]; ok {
+		t.Fatalf("a change not sent for want of a token was reported")
+	}
+}
+
+func TestSendThrottled(t *testing.T) {
+	c := &fakeClient{errs: map[string][]error{
+		"a": {&domain.TooManyRequestsError{RetryAfter: 30 * time.Millisecond}},
+	}}
+	start := time.Now()
+	o, err := send(t, c, 1, "a")
+	if err != nil || o.errs["a"] != nil {
+		t.Fatalf("Send = %v, a reported with %v", err, o.errs["a"])
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("Send returned after %v, before the pause the server asked for", elapsed)
+	}
+}
+
+func TestSendBatches(t *testing.T) {
+	c := &fakeClient{
+		maxBatch: 2,
+		errs: map[string][]error{
+			"a": {domain.ErrPrivateDataConflict},
+			"b": {domain.ErrServerUnavailable},
+		},
+	}
+	o, err := send(t, c, 3, "a", "b", "c", "d")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(c.saves) != 0 {
+		t.Fatalf("changes were sent one by one: %v", c.saves)
+	}
+	for _, ids := range c.batches {
+		if len(ids) > 2 {
+			t.Fatalf("batch %v is larger than the server accepts", ids)
+		}
+	}
+	// Only the change that failed for a temporary reason is sent again.
+	if len(c.batches) != 3 || !slices.ContainsFunc(c.batches, func(ids []string) bool {
+		return slices.Equal(ids, []string{"b"})
+	}) {
+		t.Fatalf("batches = %v", c.batches)
+	}
+	if !errors.Is(o.errs["a"], domain.ErrPrivateDataConflict) || o.errs["b"] != nil || o.errs["c"] != nil || o.errs["d"] != nil {
+		t.Fatalf("reported %v", o.errs)
+	}
+	if o.entries["b"].Attempts != 1 {
+		t.Fatalf("b was reported after %d failed attempts, want 1", o.entries["b"].Attempts)
+	}
+}
+
+func TestSendBatchRefused(t *testing.T) {
+	c := &fakeClient{
+		maxBatch: 3,
+		batchErr: domain.ErrPrivateDataBadFormat,
+		errs: map[string][]error{
+			"b": {domain.ErrPrivateDataBadFormat},
+		},
+	}
+	o, err := send(t, c, 3, "a", "b", "c")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	// The changes of a batch refused as a whole are sent one by one, so that
+	// only the offending one is rejected.
+	if len(c.batches) != 1 || len(c.saves) != 3 {
+		t.Fatalf("sent %d batches and %d single changes", len(c.batches), len(c.saves))
+	}
+	if o.errs["a"] != nil || !errors.Is(o.errs["b"], domain.ErrPrivateDataBadFormat) || o.errs["c"] != nil {
+		t.Fatalf("reported %v", o.errs)
+	}
+}
@@ -14,6 +14,13 @@ type Workers struct {
 func NewWorkers(cfg *config.Config, privateClient sender.PrivateClient) *Workers {
 	return &Workers{
 		FileWorker:     fileworkers.NewFileWorkers(cfg),
-		Sender: sender.NewSender(int64(cfg.SenderWorkersNum), privateClient),
+		Sender: sender.NewSender(
+			int64(cfg.SenderWorkersNum),
+			cfg.SenderRate,
+			cfg.SenderBurst,
+			cfg.QueueRetries,
+			cfg.QueueBackoff,
+			privateClient,
+		),
 	}
 }
@@ -2,12 +2,15 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"gokeeper/pkg/auth"
 	"gokeeper/pkg/domain"
 	"gokeeper/pkg/logger"
 	"io"
 	"net/http"
 
 	"github.com/go-http-utils/headers"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
@@ -25,13 +28,12 @@ func (h *Handler) Register(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	tokenStr, err := h.services.Register(req.Context(), inUser)
+	tokens, err := h.services.Register(req.Context(), inUser)
 	if err != nil {
 		handleException(w, err)
 		return
 	}
-	w.Header().Set(headers.Authorization, string(tokenStr))
-	w.WriteHeader(http.StatusOK)
+	writeTokens(w, tokens)
 }
 
 func (h *Handler) Login(w http.ResponseWriter, req *http.Request) {
@@ -48,12 +50,211 @@ func (h *Handler) Login(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	tokenStr, err := h.services.Login(req.Context(), inUser)
+	tokens, err := h.services.Login(req.Context(), inUser)
+	if errors.Is(err, domain.ErrMFARequired) {
+		w.Header().Set(auth.MFATokenHeader, string(tokens.MFAToken))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	writeTokens(w, tokens)
+}
+
+func (h *Handler) LoginMFA(w http.ResponseWriter, req *http.Request) {
+	reqBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		logger.Log.Debug("can not read body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var mfaRequest domain.MFALoginRequest
+	if err = json.Unmarshal(reqBody, &mfaRequest); err != nil {
+		logger.Log.Debug("can not unmarshall json", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	tokens, err := h.services.LoginMFA(req.Context(), mfaRequest)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	writeTokens(w, tokens)
+}
+
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	enrollment, err := h.services.EnrollTOTP(req.Context(), userID)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	writeJSON(w, enrollment)
+}
+
+func (h *Handler) VerifyTOTP(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	reqBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		logger.Log.Debug("can not read body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var verifyRequest domain.TOTPVerifyRequest
+	if err = json.Unmarshal(reqBody, &verifyRequest); err != nil {
+		logger.Log.Debug("can not unmarshall json", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	codes, err := h.services.VerifyTOTP(req.Context(), userID, verifyRequest.Code)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	writeJSON(w, domain.RecoveryCodesResponse{Codes: codes})
+}
+
+func (h *Handler) Refresh(w http.ResponseWriter, req *http.Request) {
+	reqBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		logger.Log.Debug("can not read body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var refreshRequest domain.RefreshRequest
+	if err = json.Unmarshal(reqBody, &refreshRequest); err != nil || refreshRequest.RefreshToken == "" {
+		logger.Log.Debug("can not unmarshall json", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	tokens, err := h.services.Refresh(req.Context(), refreshRequest.RefreshToken)
 	if err != nil {
 		handleException(w, err)
 		return
 	}
-	w.Header().Set(headers.Authorization, string(tokenStr))
+	writeTokens(w, tokens)
+}
+
+func (h *Handler) Logout(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sessionID, err := uuid.Parse(req.Header.Get("X-Session-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-Session-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = h.services.Logout(req.Context(), userID, req.Header.Get("X-Token-ID"), sessionID); err != nil {
+		handleException(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) LogoutAll(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = h.services.LogoutAll(req.Context(), userID); err != nil {
+		handleException(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ChangePassword(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	reqBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		logger.Log.Debug("can not read body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var changeRequest domain.ChangePasswordRequest
+	if err = json.Unmarshal(reqBody, &changeRequest); err != nil {
+		logger.Log.Debug("can not unmarshall json", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err = h.services.ChangePassword(req.Context(), userID, changeRequest); err != nil {
+		handleException(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetVaultKey(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	wrappedKey, err := h.services.GetVaultKey(req.Context(), userID)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	w.Header().Set(headers.ContentType, "application/octet-stream")
 	w.WriteHeader(http.StatusOK)
+	w.Write(wrappedKey)
+}
+
+func (h *Handler) InitVaultKey(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	wrappedKey, err := io.ReadAll(req.Body)
+	if err != nil {
+		logger.Log.Debug("can not read body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if err = h.services.InitVaultKey(req.Context(), userID, wrappedKey); err != nil {
+		handleException(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
 
+func writeTokens(w http.ResponseWriter, tokens auth.TokenPair) {
+	w.Header().Set(headers.Authorization, string(tokens.AccessToken))
+	w.Header().Set(auth.RefreshTokenHeader, tokens.RefreshToken)
+	w.WriteHeader(http.StatusOK)
 }
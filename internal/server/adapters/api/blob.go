@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"gokeeper/internal/server/core/service"
+	"gokeeper/pkg/domain"
+	"gokeeper/pkg/logger"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func (h *Handler) InitBlob(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	reqBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		logger.Log.Debug("can not read body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var initRequest domain.BlobInitRequest
+	if err = json.Unmarshal(reqBody, &initRequest); err != nil {
+		logger.Log.Debug("can not unmarshall json", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	status, err := h.services.InitBlob(req.Context(), userID, initRequest)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, status)
+}
+
+func (h *Handler) PutBlobChunk(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	blobID, err := uuid.Parse(chi.URLParam(req, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	index, err := strconv.Atoi(chi.URLParam(req, "index"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	body := http.MaxBytesReader(w, req.Body, service.MaxBlobChunkSize+1)
+	if err = h.services.PutBlobChunk(req.Context(), userID, blobID, index, body); err != nil {
+		handleException(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetBlobStatus(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	blobID, err := uuid.Parse(chi.URLParam(req, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	status, err := h.services.BlobStatus(req.Context(), userID, blobID)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	writeJSON(w, status)
+}
+
+func (h *Handler) CompleteBlob(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	blobID, err := uuid.Parse(chi.URLParam(req, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	reqBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		logger.Log.Debug("can not read body", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var completeRequest domain.BlobCompleteRequest
+	if err = json.Unmarshal(reqBody, &completeRequest); err != nil {
+		logger.Log.Debug("can not unmarshall json", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err = h.services.CompleteBlob(req.Context(), userID, blobID, completeRequest); err != nil {
+		handleException(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DownloadBlob serves a completed blob. Range requests are supported, so
+// interrupted downloads can be resumed.
+func (h *Handler) DownloadBlob(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	blobID, err := uuid.Parse(chi.URLParam(req, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	blob, content, err := h.services.OpenBlob(req.Context(), userID, blobID)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	w.Header().Set(headers.ContentType, "application/octet-stream")
+	w.Header().Set(headers.ETag, `"`+hex.EncodeToString(blob.Checksum)+`"`)
+	http.ServeContent(w, req, "", time.Time{}, content)
+}
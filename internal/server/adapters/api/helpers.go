@@ -1,28 +1,133 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"gokeeper/pkg/domain"
 	"gokeeper/pkg/logger"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/go-http-utils/headers"
 	"go.uber.org/zap"
 )
 
 func handleException(w http.ResponseWriter, err error) {
+	var throttled *domain.TooManyRequestsError
 	switch {
 	case errors.Is(err, domain.ErrUserConflict):
 		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
 	case errors.Is(err, domain.ErrUserAuthentication):
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	case errors.Is(err, domain.ErrWrongPassword):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, domain.ErrMFAAlreadyEnabled):
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+	case errors.Is(err, domain.ErrMFANotEnrolled):
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+	case errors.As(err, &throttled):
+		w.Header().Set(headers.RetryAfter, strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, domain.ErrVaultKeyConflict):
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+	case errors.Is(err, domain.ErrVaultKeyNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 	case errors.Is(err, domain.ErrPrivateDataConflict):
 		w.WriteHeader(http.StatusConflict)
 	case errors.Is(err, domain.ErrPrivateDataBadFormat):
 		w.WriteHeader(http.StatusBadRequest)
 	case errors.Is(err, domain.ErrPrivateDataNotFound):
 		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, domain.ErrVersionNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, domain.ErrRevisionMismatch):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, domain.ErrRevisionRequired):
+		http.Error(w, err.Error(), http.StatusPreconditionRequired)
+	case errors.Is(err, domain.ErrPrivateDataDeleted):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, domain.ErrBadCursor):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, domain.ErrBlobNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, domain.ErrBlobIncomplete), errors.Is(err, domain.ErrBlobCompleted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, domain.ErrBlobChunkInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, domain.ErrBlobChecksumMismatch):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, domain.ErrBlobTooLarge):
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, domain.ErrBlobQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
 	default:
 		logger.Log.Error("Internal server error", zap.Error(err))
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
+
+// batchStatus maps the error of a batch operation to the status the
+// operation would get on its own.
+func batchStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrBatchAborted):
+		return http.StatusFailedDependency
+	case errors.Is(err, domain.ErrPrivateDataConflict), errors.Is(err, domain.ErrBlobIncomplete):
+		return http.StatusConflict
+	case errors.Is(err, domain.ErrPrivateDataBadFormat):
+		return http.StatusBadRequest
+	case errors.Is(err, domain.ErrRevisionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, domain.ErrRevisionRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, domain.ErrPrivateDataDeleted):
+		return http.StatusGone
+	case errors.Is(err, domain.ErrPrivateDataNotFound), errors.Is(err, domain.ErrBlobNotFound):
+		return http.StatusNotFound
+	default:
+		logger.Log.Error("Internal server error", zap.Error(err))
+		return http.StatusInternalServerError
+	}
+}
+
+// revisionETag returns the entity tag of a record at revision.
+func revisionETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// parseIfMatch returns the revision the request is based on, given as the
+// entity tag in If-Match, or 0 if it names none.
+func parseIfMatch(req *http.Request) (int64, error) {
+	ifMatch := req.Header.Get(headers.IfMatch)
+	if ifMatch == "" {
+		return 0, nil
+	}
+	if len(ifMatch) < 2 || !strings.HasPrefix(ifMatch, `"`) || !strings.HasSuffix(ifMatch, `"`) {
+		return 0, fmt.Errorf("invalid entity tag %s", ifMatch)
+	}
+	revision, err := strconv.ParseInt(ifMatch[1:len(ifMatch)-1], 10, 64)
+	if err != nil || revision <= 0 {
+		return 0, fmt.Errorf("invalid entity tag %s", ifMatch)
+	}
+	return revision, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		logger.Log.Error("failed to parse json", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(headers.ContentType, "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
@@ -14,6 +14,16 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+	defaultGetAllLimit  = 10
+	maxGetAllLimit      = 1000
+)
+
+// Save stores a record. Changing an existing record requires its current
+// revision in If-Match, as returned in the ETag by Get; the ETag of the
+// response is the new revision.
 func (h *Handler) Save(w http.ResponseWriter, req *http.Request) {
 	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
 	if err != nil {
@@ -33,13 +43,21 @@ func (h *Handler) Save(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if privateData.Revision, err = parseIfMatch(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	if err = h.services.Save(req.Context(), &privateData, userID); err != nil {
 		handleException(w, err)
+		return
 	}
+	w.Header().Set(headers.ETag, revisionETag(privateData.Revision))
 	w.WriteHeader(http.StatusOK)
 }
 
+// Delete moves a record to the trash. Like Save, it requires the current
+// revision of the record in If-Match.
 func (h *Handler) Delete(w http.ResponseWriter, req *http.Request) {
 	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
 	if err != nil {
@@ -59,13 +77,73 @@ func (h *Handler) Delete(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if privateDeleteRequest.Revision, err = parseIfMatch(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	if err = h.services.Delete(req.Context(), &privateDeleteRequest, userID); err != nil {
 		handleException(w, err)
+		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Batch saves and deletes several records and responds with the status of
+// each operation. Unless best_effort is set, the operations are applied in
+// one transaction and the others fail with 424 once one of them fails.
+func (h *Handler) Batch(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var batchRequest domain.BatchRequest
+	if err = json.NewDecoder(req.Body).Decode(&batchRequest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(batchRequest.Ops) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(batchRequest.Ops) > domain.MaxBatchOps {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	for _, op := range batchRequest.Ops {
+		if (op.Save == nil) == (op.Delete == nil) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	errs, err := h.services.Batch(req.Context(), batchRequest.Ops, batchRequest.BestEffort, userID)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+
+	results := make([]domain.BatchResult, len(batchRequest.Ops))
+	for i, op := range batchRequest.Ops {
+		results[i] = domain.BatchResult{ID: op.ID(), Status: http.StatusOK}
+		if errs[i] != nil {
+			results[i].Status = batchStatus(errs[i])
+			if results[i].Status != http.StatusInternalServerError {
+				results[i].Error = errs[i].Error()
+			}
+		}
+	}
+	writeJSON(w, domain.BatchResponse{Results: results})
+}
+
+// Capabilities tells clients which optional features the server supports.
+func (h *Handler) Capabilities(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, domain.Capabilities{MaxBatch: domain.MaxBatchOps})
+}
+
 func (h *Handler) Get(w http.ResponseWriter, req *http.Request) {
 	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
 	if err != nil {
@@ -93,10 +171,13 @@ func (h *Handler) Get(w http.ResponseWriter, req *http.Request) {
 	}
 
 	w.Header().Set(headers.ContentType, "application/json")
+	w.Header().Set(headers.ETag, revisionETag(privateData.Revision))
 	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
 
+// GetAll lists a page of the user's records. The page after it is asked
+// for with the next_cursor of the response and the same order.
 func (h *Handler) GetAll(w http.ResponseWriter, req *http.Request) {
 	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
 	if err != nil {
@@ -105,42 +186,120 @@ func (h *Handler) GetAll(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	limit := req.URL.Query().Get("limit")
-	if limit == "" {
-		limit = "10"
+	query := req.URL.Query()
+	getAllRequest := domain.GetAllRequest{
+		Limit:     defaultGetAllLimit,
+		Cursor:    query.Get("cursor"),
+		IDPrefix:  query.Get("id_prefix"),
+		MetaToken: query.Get("meta_token"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if getAllRequest.Limit, err = strconv.ParseUint(limit, 10, 64); err != nil || getAllRequest.Limit == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 	}
-	offset := req.URL.Query().Get("offset")
-	if offset == "" {
-		offset = "0"
+	getAllRequest.Limit = min(getAllRequest.Limit, maxGetAllLimit)
+	if getAllRequest.Order, err = domain.ParseOrder(query.Get("order")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if typeName := query.Get("type"); typeName != "" {
+		dataType, err := domain.ParseType(typeName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		getAllRequest.Type = &dataType
 	}
 
-	var GetAllRequest domain.GetAllRequest
+	page, err := h.services.GetAll(req.Context(), &getAllRequest, userID)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	if page.Records == nil {
+		page.Records = []domain.Data{}
+	}
+	writeJSON(w, page)
+}
 
-	if GetAllRequest.Limit, err = strconv.ParseUint(limit, 0, 64); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+// GetChanges responds with the changes of the records after the revision
+// given by the since parameter, at most limit of them.
+func (h *Handler) GetChanges(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	if GetAllRequest.Offset, err = strconv.ParseUint(offset, 0, 64); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	changesRequest := domain.ChangesRequest{Limit: defaultChangesLimit}
+	if since := req.URL.Query().Get("since"); since != "" {
+		if changesRequest.Since, err = strconv.ParseInt(since, 10, 64); err != nil || changesRequest.Since < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if limit := req.URL.Query().Get("limit"); limit != "" {
+		if changesRequest.Limit, err = strconv.ParseUint(limit, 10, 64); err != nil || changesRequest.Limit == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	changesRequest.Limit = min(changesRequest.Limit, maxChangesLimit)
+
+	changes, err := h.services.GetChanges(req.Context(), &changesRequest, userID)
+	if err != nil {
+		handleException(w, err)
 		return
 	}
+	if changes.Changes == nil {
+		changes.Changes = []domain.Data{}
+	}
+	writeJSON(w, changes)
+}
 
-	privateData, err := h.services.GetAll(req.Context(), &GetAllRequest, userID)
+// GetTrash lists the deleted records without their payloads.
+func (h *Handler) GetTrash(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
 	if err != nil {
-		logger.Log.Error("GetAll: internal error", zap.Error(err))
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	resp, err := json.Marshal(privateData)
+	privateData, err := h.services.GetTrash(req.Context(), userID)
 	if err != nil {
-		logger.Log.Error("GetAll: internal error", zap.Error(err))
+		handleException(w, err)
+		return
+	}
+	if privateData == nil {
+		privateData = []domain.Data{}
+	}
+	writeJSON(w, privateData)
+}
+
+// Undelete takes the record out of the trash and responds with it without
+// its payload. Like Save, it requires the current revision of the record in
+// If-Match, as listed in the trash.
+func (h *Handler) Undelete(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	revision, err := parseIfMatch(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-	w.Header().Set(headers.ContentType, "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(resp)
+	privateData, err := h.services.Undelete(req.Context(), chi.URLParam(req, "id"), userID, revision)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	writeJSON(w, privateData)
 }
@@ -8,6 +8,7 @@ import (
 	domain2 "gokeeper/pkg/domain"
 	"gokeeper/pkg/logger"
 	"gokeeper/pkg/middlewares"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -21,27 +22,58 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 )
 
-const serverTimeout = 3
+const (
+	serverTimeout = 3
+	// blobTimeout applies to blob transfers, which may take much longer
+	// than other requests.
+	blobTimeout = 10 * time.Minute
+)
 
 type API struct {
 	srv *http.Server
 }
 
 type AuthService interface {
-	Register(ctx context.Context, inUser domain2.InUserRequest) (auth.Token, error)
-	Login(ctx context.Context, inUser domain2.InUserRequest) (auth.Token, error)
+	Register(ctx context.Context, inUser domain2.InUserRequest) (auth.TokenPair, error)
+	Login(ctx context.Context, inUser domain2.InUserRequest) (auth.TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (auth.TokenPair, error)
+	Logout(ctx context.Context, userID uuid.UUID, tokenID string, sessionID uuid.UUID) error
+	LogoutAll(ctx context.Context, userID uuid.UUID) error
+	IsTokenRevoked(ctx context.Context, claims *auth.Claims) (bool, error)
+	LoginMFA(ctx context.Context, req domain2.MFALoginRequest) (auth.TokenPair, error)
+	EnrollTOTP(ctx context.Context, userID uuid.UUID) (domain2.TOTPEnrollResponse, error)
+	VerifyTOTP(ctx context.Context, userID uuid.UUID, code string) ([]string, error)
+	GetVaultKey(ctx context.Context, userID uuid.UUID) ([]byte, error)
+	InitVaultKey(ctx context.Context, userID uuid.UUID, wrappedKey []byte) error
+	ChangePassword(ctx context.Context, userID uuid.UUID, req domain2.ChangePasswordRequest) error
 }
 
 type PrivateService interface {
 	Save(ctx context.Context, pd *domain2.Data, userID uuid.UUID) error
 	GetByID(ctx context.Context, id string, userID uuid.UUID) (*domain2.Data, error)
 	Delete(ctx context.Context, pd *domain2.DeleteRequest, userID uuid.UUID) error
-	GetAll(ctx context.Context, req *domain2.GetAllRequest, userID uuid.UUID) ([]domain2.Data, error)
+	GetAll(ctx context.Context, req *domain2.GetAllRequest, userID uuid.UUID) (*domain2.Page, error)
+	GetVersions(ctx context.Context, id string, userID uuid.UUID) ([]domain2.Data, error)
+	GetVersion(ctx context.Context, id string, userID uuid.UUID, version int64) (*domain2.Data, error)
+	RestoreVersion(ctx context.Context, id string, userID uuid.UUID, version int64, revision int64) (*domain2.Data, error)
+	GetTrash(ctx context.Context, userID uuid.UUID) ([]domain2.Data, error)
+	GetChanges(ctx context.Context, req *domain2.ChangesRequest, userID uuid.UUID) (*domain2.Changes, error)
+	Undelete(ctx context.Context, id string, userID uuid.UUID, revision int64) (*domain2.Data, error)
+	Batch(ctx context.Context, ops []domain2.BatchOp, bestEffort bool, userID uuid.UUID) ([]error, error)
+}
+
+type BlobService interface {
+	InitBlob(ctx context.Context, userID uuid.UUID, req domain2.BlobInitRequest) (domain2.BlobStatus, error)
+	PutBlobChunk(ctx context.Context, userID, blobID uuid.UUID, index int, r io.Reader) error
+	BlobStatus(ctx context.Context, userID, blobID uuid.UUID) (domain2.BlobStatus, error)
+	CompleteBlob(ctx context.Context, userID, blobID uuid.UUID, req domain2.BlobCompleteRequest) error
+	OpenBlob(ctx context.Context, userID, blobID uuid.UUID) (domain2.Blob, io.ReadSeeker, error)
 }
 
 type Services interface {
 	AuthService
 	PrivateService
+	BlobService
 }
 
 type Handler struct {
@@ -52,35 +84,86 @@ func NewAPI(services Services, cfg *config.Config, auth *auth.Authenticator) *AP
 	h := &Handler{services}
 	r := chi.NewRouter()
 
-	r.Use(middleware.Timeout(serverTimeout * time.Second))
 	r.Use(middlewares.LoggingRequestMiddleware)
+	r.Route("/api/blobs", func(r chi.Router) {
+		r.Use(middleware.Timeout(blobTimeout))
+		r.Use(middlewares.AuthenticateMiddleware(auth, services))
+		r.Post("/", h.InitBlob)
+		r.Get("/{id}", h.DownloadBlob)
+		r.Get("/{id}/status", h.GetBlobStatus)
+		r.Put("/{id}/chunks/{index}", h.PutBlobChunk)
+		r.Post("/{id}/complete", h.CompleteBlob)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(serverTimeout * time.Second))
+		registerRoutes(r, h, services, auth)
+	})
+	return &API{
+		srv: &http.Server{
+			Addr:    cfg.Address,
+			Handler: r,
+		},
+	}
+}
+
+func registerRoutes(r chi.Router, h *Handler, services Services, auth *auth.Authenticator) {
 	r.Route("/api/user", func(r chi.Router) {
 		r.Route("/register", func(r chi.Router) {
 			r.Post("/", h.Register)
 		})
 		r.Route("/login", func(r chi.Router) {
 			r.Post("/", h.Login)
+			r.Post("/mfa", h.LoginMFA)
 		})
+		r.Route("/refresh", func(r chi.Router) {
+			r.Post("/", h.Refresh)
+		})
+		r.Route("/logout", func(r chi.Router) {
+			r.Use(middlewares.AuthenticateMiddleware(auth, services))
+			r.Post("/", h.Logout)
+			r.Post("/all", h.LogoutAll)
+		})
+		r.Route("/2fa", func(r chi.Router) {
+			r.Use(middlewares.AuthenticateMiddleware(auth, services))
+			r.Post("/enroll", h.EnrollTOTP)
+			r.Post("/verify", h.VerifyTOTP)
+		})
+		r.Route("/password", func(r chi.Router) {
+			r.Use(middlewares.AuthenticateMiddleware(auth, services))
+			r.Put("/", h.ChangePassword)
+		})
+		r.Route("/vault-key", func(r chi.Router) {
+			r.Use(middlewares.AuthenticateMiddleware(auth, services))
+			r.Get("/", h.GetVaultKey)
+			r.Put("/", h.InitVaultKey)
+		})
+	})
+	r.Get("/api/capabilities", h.Capabilities)
+	// The trash and the changes are kept apart from /api/private/{id}, where
+	// they would shadow records with the same ID.
+	r.Group(func(r chi.Router) {
+		r.Use(middlewares.AuthenticateMiddleware(auth, services))
+		r.Get("/api/trash", h.GetTrash)
+		r.Get("/api/changes", h.GetChanges)
 	})
 	r.Route("/api/private", func(r chi.Router) {
-		r.Use(middlewares.AuthenticateMiddleware(auth))
+		r.Use(middlewares.AuthenticateMiddleware(auth, services))
 		r.Group(func(r chi.Router) {
 			r.Post("/", h.Save)
 			r.Delete("/", h.Delete)
+			r.Post("/batch", h.Batch)
 		})
 		r.Group(func(r chi.Router) {
+			r.Post("/{id:^[a-zA-Z0-9-_]+}/undelete", h.Undelete)
 			r.Get("/{id:^[a-zA-Z0-9-_]+}", h.Get)
+			r.Get("/{id:^[a-zA-Z0-9-_]+}/versions", h.GetVersions)
+			r.Get("/{id:^[a-zA-Z0-9-_]+}/versions/{version:^[0-9]+}", h.GetVersion)
+			r.Post("/{id:^[a-zA-Z0-9-_]+}/versions/{version:^[0-9]+}/restore", h.RestoreVersion)
 			r.Group(func(r chi.Router) {
 				r.Get("/", h.GetAll)
 			})
 		})
 	})
-	return &API{
-		srv: &http.Server{
-			Addr:    cfg.Address,
-			Handler: r,
-		},
-	}
 }
 
 // Run starts the HTTP server.
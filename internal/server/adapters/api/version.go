@@ -0,0 +1,78 @@
+package api
+
+import (
+	"gokeeper/pkg/logger"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// GetVersions lists the versions of a record without their payloads.
+func (h *Handler) GetVersions(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	versions, err := h.services.GetVersions(req.Context(), chi.URLParam(req, "id"), userID)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	writeJSON(w, versions)
+}
+
+func (h *Handler) GetVersion(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	version, err := strconv.ParseInt(chi.URLParam(req, "version"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	privateData, err := h.services.GetVersion(req.Context(), chi.URLParam(req, "id"), userID, version)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	writeJSON(w, privateData)
+}
+
+// RestoreVersion makes a copy of the version the current one and responds
+// with the record without its payload. Like Save, it requires the current
+// revision of the record in If-Match.
+func (h *Handler) RestoreVersion(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.Header.Get("X-User-ID"))
+	if err != nil {
+		logger.Log.Error("failed to parse X-User-ID", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	version, err := strconv.ParseInt(chi.URLParam(req, "version"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	revision, err := parseIfMatch(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	privateData, err := h.services.RestoreVersion(req.Context(), chi.URLParam(req, "id"), userID, version, revision)
+	if err != nil {
+		handleException(w, err)
+		return
+	}
+	writeJSON(w, privateData)
+}
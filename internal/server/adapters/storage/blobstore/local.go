@@ -0,0 +1,110 @@
+// Package blobstore stores record payloads outside of the database. Payloads
+// are content addressed: the key of a payload is the hex encoded SHA-256 of
+// its content.
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gokeeper/pkg/domain"
+	"os"
+	"path/filepath"
+)
+
+var errInvalidKey = errors.New("invalid payload key")
+
+// Key returns the key data is stored under.
+func Key(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// LocalStore keeps payloads in files under dir, spread over subdirectories
+// named after the first bytes of the key.
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create payload directory: %w", err)
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+// Put stores data unless a payload with the same content exists already.
+func (ls *LocalStore) Put(_ context.Context, data []byte) (string, error) {
+	key := Key(data)
+	path := ls.path(key)
+	if _, err := os.Stat(path); err == nil {
+		return key, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create payload directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "put-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create payload file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write payload: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to store payload: %w", err)
+	}
+	return key, nil
+}
+
+// Get returns the payload stored under key. The content is checked against
+// the key, so a corrupted file is never returned.
+func (ls *LocalStore) Get(_ context.Context, key string) ([]byte, error) {
+	if !validKey(key) {
+		return nil, errInvalidKey
+	}
+	data, err := os.ReadFile(ls.path(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, domain.ErrPayloadNotFound
+		}
+		return nil, fmt.Errorf("failed to read payload: %w", err)
+	}
+	if Key(data) != key {
+		return nil, fmt.Errorf("payload %s is corrupted", key)
+	}
+	return data, nil
+}
+
+func (ls *LocalStore) Delete(_ context.Context, key string) error {
+	if !validKey(key) {
+		return errInvalidKey
+	}
+	if err := os.Remove(ls.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete payload: %w", err)
+	}
+	return nil
+}
+
+func (ls *LocalStore) path(key string) string {
+	return filepath.Join(ls.dir, key[:2], key[2:4], key)
+}
+
+func validKey(key string) bool {
+	if len(key) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
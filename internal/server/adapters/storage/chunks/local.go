@@ -0,0 +1,102 @@
+// Package chunks stores the content of blob chunks.
+package chunks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// LocalStore keeps every chunk in its own file under dir/<blob id>/.
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create chunk directory: %w", err)
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+// StageChunk writes the chunk read from r to a temporary file next to where
+// it belongs. The chunk is only replaced once the returned chunk is
+// committed, so a failed upload never leaves a partial chunk behind.
+func (ls *LocalStore) StageChunk(_ context.Context, blobID uuid.UUID, index int, r io.Reader) (*StagedChunk, error) {
+	blobDir := ls.blobDir(blobID)
+	if err := os.MkdirAll(blobDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(blobDir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunk file: %w", err)
+	}
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &StagedChunk{tmp: tmp.Name(), path: ls.chunkPath(blobID, index)}, nil
+}
+
+// OpenChunk opens the chunk for reading from offset.
+func (ls *LocalStore) OpenChunk(_ context.Context, blobID uuid.UUID, index int, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(ls.chunkPath(blobID, index))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chunk: %w", err)
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seek chunk: %w", err)
+	}
+	return f, nil
+}
+
+// DeleteChunks removes all chunks of the blob.
+func (ls *LocalStore) DeleteChunks(_ context.Context, blobID uuid.UUID) error {
+	err := os.RemoveAll(ls.blobDir(blobID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete chunks: %w", err)
+	}
+	return nil
+}
+
+func (ls *LocalStore) blobDir(blobID uuid.UUID) string {
+	return filepath.Join(ls.dir, blobID.String())
+}
+
+func (ls *LocalStore) chunkPath(blobID uuid.UUID, index int) string {
+	return filepath.Join(ls.blobDir(blobID), strconv.Itoa(index))
+}
+
+// StagedChunk is a chunk written aside by StageChunk.
+type StagedChunk struct {
+	tmp  string
+	path string
+}
+
+// Commit replaces the chunk with the staged one.
+func (sc *StagedChunk) Commit() error {
+	if err := os.Rename(sc.tmp, sc.path); err != nil {
+		return fmt.Errorf("failed to store chunk: %w", err)
+	}
+	return nil
+}
+
+// Discard removes the staged chunk unless it was committed.
+func (sc *StagedChunk) Discard() {
+	os.Remove(sc.tmp)
+}
@@ -0,0 +1,128 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gokeeper/internal/server/adapters/storage/database"
+	"gokeeper/internal/server/adapters/storage/database/postgresql/queries"
+	"gokeeper/pkg/domain"
+	"gokeeper/pkg/logger"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func (s Storage) InsertBlob(ctx context.Context, blob domain.Blob, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.InsertBlob, blob.ID, blob.UserID, blob.Size, blob.ChunkSize); err != nil {
+		return fmt.Errorf("failed to insert blob: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) GetBlob(ctx context.Context, id uuid.UUID, userID uuid.UUID) (domain.Blob, error) {
+	return scanBlob(s.db.QueryRowContext(ctx, queries.GetBlob, id, userID))
+}
+
+// LockBlob reads the blob of any user and locks it until tx ends.
+func (s Storage) LockBlob(ctx context.Context, id uuid.UUID, tx *database.Trx) (domain.Blob, error) {
+	return scanBlob(tx.QueryRowContext(ctx, queries.LockBlob, id))
+}
+
+func scanBlob(row *sql.Row) (domain.Blob, error) {
+	var blob domain.Blob
+	err := row.Scan(
+		&blob.ID, &blob.UserID, &blob.Size, &blob.ChunkSize, &blob.Checksum, &blob.Completed, &blob.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Blob{}, domain.ErrBlobNotFound
+		}
+		return domain.Blob{}, fmt.Errorf("failed to scan blob: %w", err)
+	}
+	return blob, nil
+}
+
+// GetBlobsSize returns the total declared size of the blobs of a user.
+func (s Storage) GetBlobsSize(ctx context.Context, userID uuid.UUID, tx *database.Trx) (int64, error) {
+	var size int64
+	if err := tx.QueryRowContext(ctx, queries.GetBlobsSize, userID).Scan(&size); err != nil {
+		return 0, fmt.Errorf("failed to sum blob sizes: %w", err)
+	}
+	return size, nil
+}
+
+// GetStaleBlobs returns the blobs created before the given time that were
+// either never completed or are not referenced by any record or version.
+func (s Storage) GetStaleBlobs(ctx context.Context, createdBefore time.Time) ([]uuid.UUID, error) {
+	rows, err := s.db.QueryContext(ctx, queries.GetStaleBlobs, createdBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stale blobs: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logger.Log.Error("error occurred during closing rows", zap.Error(err))
+		}
+	}()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err = rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan stale blob: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate stale blobs: %w", err)
+	}
+	return ids, nil
+}
+
+func (s Storage) InsertBlobChunk(ctx context.Context, blobID uuid.UUID, index int, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.InsertBlobChunk, blobID, index); err != nil {
+		return fmt.Errorf("failed to insert blob chunk: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) GetBlobChunks(ctx context.Context, blobID uuid.UUID) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, queries.GetBlobChunks, blobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blob chunks: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logger.Log.Error("error occurred during closing rows", zap.Error(err))
+		}
+	}()
+
+	var chunks []int
+	for rows.Next() {
+		var index int
+		if err = rows.Scan(&index); err != nil {
+			return nil, fmt.Errorf("failed to scan blob chunk: %w", err)
+		}
+		chunks = append(chunks, index)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate blob chunks: %w", err)
+	}
+	return chunks, nil
+}
+
+func (s Storage) CompleteBlob(ctx context.Context, blobID uuid.UUID, checksum []byte, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.CompleteBlob, blobID, checksum); err != nil {
+		return fmt.Errorf("failed to complete blob: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) DeleteBlob(ctx context.Context, blobID uuid.UUID, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.DeleteBlob, blobID); err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS vault_key BYTEA;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN vault_key;
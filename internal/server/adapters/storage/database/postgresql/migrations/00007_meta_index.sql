@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE private ADD COLUMN IF NOT EXISTS meta_index TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE private DROP COLUMN meta_index;
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS blobs (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    size BIGINT NOT NULL,
+    chunk_size BIGINT NOT NULL,
+    checksum BYTEA,
+    completed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS blob_chunks (
+    blob_id UUID NOT NULL REFERENCES blobs(id) ON DELETE CASCADE,
+    idx INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blob_id, idx)
+);
+
+ALTER TABLE private ADD COLUMN IF NOT EXISTS blob_id UUID REFERENCES blobs(id);
+
+-- +goose Down
+ALTER TABLE private DROP COLUMN blob_id;
+DROP TABLE blob_chunks;
+DROP TABLE blobs;
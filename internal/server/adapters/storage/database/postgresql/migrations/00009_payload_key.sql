@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE private ADD COLUMN IF NOT EXISTS payload_key TEXT;
+
+CREATE INDEX IF NOT EXISTS private_payload_key_idx ON private(payload_key) WHERE payload_key IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS private_payload_key_idx;
+ALTER TABLE private DROP COLUMN payload_key;
@@ -0,0 +1,28 @@
+-- +goose Up
+ALTER TABLE private ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS private_versions (
+    user_id UUID NOT NULL,
+    id VARCHAR(255) NOT NULL,
+    version BIGINT NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    data BYTEA NOT NULL,
+    meta TEXT NOT NULL,
+    meta_index TEXT NOT NULL DEFAULT '',
+    blob_id UUID REFERENCES blobs(id),
+    payload_key TEXT,
+    saved_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, id, version)
+);
+
+CREATE INDEX IF NOT EXISTS private_versions_blob_id_idx ON private_versions(blob_id) WHERE blob_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS private_versions_payload_key_idx ON private_versions(payload_key) WHERE payload_key IS NOT NULL;
+
+UPDATE private SET version = 1;
+INSERT INTO private_versions (user_id, id, version, type, data, meta, meta_index, blob_id, payload_key, saved_at)
+SELECT user_id, id, version, type, data, meta, meta_index, blob_id, payload_key, saved_at FROM private;
+
+-- +goose Down
+DROP TABLE private_versions;
+ALTER TABLE private DROP COLUMN version;
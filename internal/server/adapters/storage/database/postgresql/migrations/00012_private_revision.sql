@@ -0,0 +1,21 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS purged_revision BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE private ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 0;
+
+UPDATE private
+SET revision = r.revision
+FROM (
+    SELECT user_id, id, row_number() OVER (PARTITION BY user_id ORDER BY updated_at, id) AS revision
+    FROM private
+) AS r
+WHERE private.user_id = r.user_id AND private.id = r.id;
+UPDATE users SET revision = COALESCE((SELECT MAX(revision) FROM private WHERE private.user_id = users.id), 0);
+
+CREATE INDEX IF NOT EXISTS private_user_id_revision_idx ON private(user_id, revision);
+
+-- +goose Down
+DROP INDEX IF EXISTS private_user_id_revision_idx;
+ALTER TABLE private DROP COLUMN revision;
+ALTER TABLE users DROP COLUMN purged_revision;
+ALTER TABLE users DROP COLUMN revision;
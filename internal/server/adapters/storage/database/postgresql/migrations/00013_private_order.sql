@@ -0,0 +1,7 @@
+-- +goose Up
+CREATE INDEX IF NOT EXISTS private_user_id_saved_at_idx ON private(user_id, saved_at, id);
+CREATE INDEX IF NOT EXISTS private_user_id_type_idx ON private(user_id, type, id);
+
+-- +goose Down
+DROP INDEX IF EXISTS private_user_id_type_idx;
+DROP INDEX IF EXISTS private_user_id_saved_at_idx;
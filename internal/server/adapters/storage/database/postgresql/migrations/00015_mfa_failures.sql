@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_locked_until TIMESTAMPTZ;
+DROP TABLE IF EXISTS mfa_attempts;
+
+-- +goose Down
+CREATE TABLE IF NOT EXISTS mfa_attempts (
+    jti VARCHAR(255) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS mfa_attempts_expires_at_idx ON mfa_attempts(expires_at);
+ALTER TABLE users DROP COLUMN mfa_locked_until;
+ALTER TABLE users DROP COLUMN mfa_failures;
@@ -2,5 +2,118 @@ package queries
 
 const (
 	InsertUser = `INSERT INTO users (id, login, password_hash) VALUES ($1, $2, $3);`
-	GetUser    = `SELECT id, login, password_hash FROM users WHERE login = $1;`
+	GetUser    = `
+		SELECT id, login, password_hash, totp_secret, totp_enabled, totp_last_step,
+			mfa_failures, mfa_locked_until
+		FROM users
+		WHERE login = $1;
+	`
+	GetUserByID = `
+		SELECT id, login, password_hash, totp_secret, totp_enabled, totp_last_step,
+			mfa_failures, mfa_locked_until
+		FROM users
+		WHERE id = $1;
+	`
+	LockUser = `
+		SELECT id, login, password_hash, totp_secret, totp_enabled, totp_last_step,
+			mfa_failures, mfa_locked_until
+		FROM users
+		WHERE id = $1
+		FOR UPDATE;
+	`
+
+	UpdatePasswordHash = `
+		UPDATE users
+		SET password_hash = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1;
+	`
+
+	InsertRefreshToken = `
+		INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5);
+	`
+	GetRefreshToken = `
+		SELECT
+			id,
+			user_id,
+			family_id,
+			token_hash,
+			expires_at,
+			used_at,
+			revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE;
+	`
+	MarkRefreshTokenUsed = `
+		UPDATE refresh_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE id = $1;
+	`
+	RevokeRefreshTokenFamily = `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id = $1 AND revoked_at IS NULL;
+	`
+	RevokeUserRefreshTokens = `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL;
+	`
+
+	InsertRevokedToken = `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING;
+	`
+	DeleteExpiredRevokedTokens = `DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP;`
+	RevokeUserTokens           = `UPDATE users SET tokens_revoked_at = CURRENT_TIMESTAMP WHERE id = $1;`
+	IsTokenRevoked             = `
+		SELECT
+			EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR EXISTS (
+				SELECT 1 FROM users
+				WHERE id = $2 AND tokens_revoked_at IS NOT NULL AND tokens_revoked_at >= $3
+			);
+	`
+
+	SetTOTPSecret = `
+		UPDATE users
+		SET totp_secret = $2, totp_enabled = FALSE, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1;
+	`
+	EnableTOTP = `
+		UPDATE users
+		SET totp_enabled = TRUE, totp_last_step = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1;
+	`
+	SetMFAFailures = `
+		UPDATE users
+		SET mfa_failures = $2, mfa_locked_until = $3
+		WHERE id = $1;
+	`
+	UpdateTOTPLastStep = `
+		UPDATE users
+		SET totp_last_step = $2
+		WHERE id = $1 AND totp_last_step < $2;
+	`
+	DeleteRecoveryCodes = `DELETE FROM recovery_codes WHERE user_id = $1;`
+	InsertRecoveryCode  = `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2);`
+	UseRecoveryCode     = `
+		UPDATE recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+	`
+
+	GetVaultKey = `SELECT vault_key FROM users WHERE id = $1;`
+	SetVaultKey = `
+		UPDATE users
+		SET vault_key = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND vault_key IS NULL;
+	`
+	UpdateVaultKey = `
+		UPDATE users
+		SET vault_key = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1;
+	`
 )
@@ -0,0 +1,58 @@
+package queries
+
+const (
+	InsertBlob = `
+		INSERT INTO blobs (id, user_id, size, chunk_size)
+		VALUES ($1, $2, $3, $4);
+	`
+	GetBlob = `
+		SELECT
+			id,
+			user_id,
+			size,
+			chunk_size,
+			checksum,
+			completed_at IS NOT NULL,
+			created_at
+		FROM blobs
+		WHERE id = $1 AND user_id = $2;
+	`
+	LockBlob = `
+		SELECT
+			id,
+			user_id,
+			size,
+			chunk_size,
+			checksum,
+			completed_at IS NOT NULL,
+			created_at
+		FROM blobs
+		WHERE id = $1
+		FOR UPDATE;
+	`
+	GetBlobsSize  = `SELECT COALESCE(SUM(size), 0) FROM blobs WHERE user_id = $1;`
+	GetStaleBlobs = `
+		SELECT id
+		FROM blobs b
+		WHERE created_at < $1
+			AND (
+				completed_at IS NULL
+				OR NOT (
+					EXISTS (SELECT 1 FROM private WHERE blob_id = b.id)
+					OR EXISTS (SELECT 1 FROM private_versions WHERE blob_id = b.id)
+				)
+			);
+	`
+	InsertBlobChunk = `
+		INSERT INTO blob_chunks (blob_id, idx)
+		VALUES ($1, $2)
+		ON CONFLICT (blob_id, idx) DO NOTHING;
+	`
+	GetBlobChunks = `SELECT idx FROM blob_chunks WHERE blob_id = $1 ORDER BY idx;`
+	CompleteBlob  = `
+		UPDATE blobs
+		SET checksum = $2, completed_at = CURRENT_TIMESTAMP
+		WHERE id = $1;
+	`
+	DeleteBlob = `DELETE FROM blobs WHERE id = $1;`
+)
@@ -1,37 +1,162 @@
 package queries
 
 const (
-	GetAllDataByUserID = `
+	// The GetAllData queries list a page of records in order. If the sixth
+	// parameter is set, the page starts after the record whose sort value
+	// and id are the last parameters.
+	GetAllDataByID = getAllData + `
+			AND (NOT $6 OR id > $7)
+		ORDER BY id
+		LIMIT $5;
+	`
+	GetAllDataBySavedAt = getAllData + `
+			AND (NOT $6 OR saved_at > $7 OR (saved_at = $7 AND id > $8))
+		ORDER BY saved_at, id
+		LIMIT $5;
+	`
+	GetAllDataByType = getAllData + `
+			AND (NOT $6 OR type > $7 OR (type = $7 AND id > $8))
+		ORDER BY type, id
+		LIMIT $5;
+	`
+	CountData  = `SELECT count(*)` + getAllDataFilter + `;`
+	getAllData = `
 		SELECT
 			id,
 			type,
 			data,
 			meta,
-			saved_at
+			meta_index,
+			blob_id,
+			payload_key,
+			saved_at,
+			version,
+			revision` + getAllDataFilter
+	getAllDataFilter = `
 		FROM private
 		WHERE user_id = $1
-		LIMIT $2 OFFSET $3;
-	`
+			AND deleted_at IS NULL
+			AND ($2 = '' OR position(' ' || $2 || ' ' IN ' ' || meta_index || ' ') > 0)
+			AND ($3 = '' OR type = $3)
+			AND ($4 = '' OR left(id, char_length($4)) = $4)`
 	InsertData = `
-		INSERT INTO private (id, type, data, meta, saved_at, user_id)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO private (id, type, data, meta, meta_index, saved_at, user_id, blob_id, payload_key, version, revision)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		ON CONFLICT (user_id, id)
 		DO UPDATE SET
 			type = $2,
 			data = $3,
 			meta = $4,
-			saved_at = $5,
+			meta_index = $5,
+			saved_at = $6,
+			blob_id = $8,
+			payload_key = $9,
+			version = $10,
+			revision = $11,
+			deleted_at = NULL,
 			updated_at = CURRENT_TIMESTAMP
 		;
 	`
-	DeleteData  = `DELETE FROM private WHERE user_id = $1 AND id = $2;`
-	GetDataByID = `
+	DeleteData      = `DELETE FROM private WHERE user_id = $1 AND id = $2;`
+	MarkDataDeleted = `
+		UPDATE private
+		SET deleted_at = $3, revision = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND id = $2;
+	`
+	GetDeletedData = `
+		SELECT
+			id,
+			type,
+			meta,
+			meta_index,
+			blob_id,
+			saved_at,
+			version,
+			deleted_at,
+			revision
+		FROM private
+		WHERE user_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC;
+	`
+	GetTombstones = `SELECT user_id, id FROM private WHERE deleted_at < $1;`
+	GetDataByID   = `
 		SELECT
 			type,
 			data,
 			meta,
-			saved_at
+			meta_index,
+			blob_id,
+			payload_key,
+			saved_at,
+			version,
+			deleted_at,
+			revision
 		FROM private
 		WHERE user_id = $1 AND id = $2;
 	`
+	LockPayload         = `SELECT pg_advisory_xact_lock(hashtext($1));`
+	IsPayloadReferenced = `
+		SELECT
+			EXISTS (SELECT 1 FROM private WHERE payload_key = $1)
+			OR EXISTS (SELECT 1 FROM private_versions WHERE payload_key = $1);
+	`
+	IsBlobReferenced = `
+		SELECT
+			EXISTS (SELECT 1 FROM private WHERE blob_id = $1)
+			OR EXISTS (SELECT 1 FROM private_versions WHERE blob_id = $1);
+	`
+
+	InsertVersion = `
+		INSERT INTO private_versions (id, type, data, meta, meta_index, saved_at, user_id, blob_id, payload_key, version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+	`
+	GetVersions = `
+		SELECT
+			type,
+			meta,
+			meta_index,
+			blob_id,
+			payload_key,
+			saved_at,
+			version
+		FROM private_versions
+		WHERE user_id = $1 AND id = $2
+		ORDER BY version;
+	`
+	GetVersion = `
+		SELECT
+			type,
+			data,
+			meta,
+			meta_index,
+			blob_id,
+			payload_key,
+			saved_at,
+			version
+		FROM private_versions
+		WHERE user_id = $1 AND id = $2 AND version = $3;
+	`
+	DeleteVersion = `DELETE FROM private_versions WHERE user_id = $1 AND id = $2 AND version = $3;`
+
+	NextRevision      = `UPDATE users SET revision = revision + 1 WHERE id = $1 RETURNING revision;`
+	GetRevisions      = `SELECT revision, purged_revision FROM users WHERE id = $1;`
+	SetPurgedRevision = `UPDATE users SET purged_revision = GREATEST(purged_revision, $2) WHERE id = $1;`
+	GetChanges        = `
+		SELECT
+			id,
+			type,
+			data,
+			meta,
+			meta_index,
+			blob_id,
+			payload_key,
+			saved_at,
+			version,
+			deleted_at,
+			revision
+		FROM private
+		WHERE user_id = $1 AND revision > $2
+		ORDER BY revision
+		LIMIT $3;
+	`
 )
@@ -0,0 +1,30 @@
+package sqlite
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"gokeeper/pkg/logger"
+
+	"github.com/pressly/goose/v3"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+func Migrate(db *sql.DB) error {
+	goose.SetBaseFS(migrations)
+
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		return fmt.Errorf("sqlite migrate set dialect sqlite3: %w", err)
+	}
+
+	if err := goose.Up(db, "migrations"); err != nil {
+		if !errors.Is(err, goose.ErrNoNextVersion) {
+			return fmt.Errorf("sqlite migrate up: %w", err)
+		}
+	}
+	logger.Log.Info("successful migrations")
+	return nil
+}
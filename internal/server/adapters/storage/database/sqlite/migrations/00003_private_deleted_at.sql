@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE private ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS private_deleted_at_idx ON private(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS private_deleted_at_idx;
+ALTER TABLE private DROP COLUMN deleted_at;
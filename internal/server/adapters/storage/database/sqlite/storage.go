@@ -0,0 +1,655 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gokeeper/internal/server/adapters/storage/database"
+	"gokeeper/internal/server/adapters/storage/database/sqlite/queries"
+	"gokeeper/pkg/domain"
+	"gokeeper/pkg/logger"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// timestampLayout is how timestamps are written to the database. It is fixed
+// width and always UTC, so comparing the text compares the times.
+const timestampLayout = "2006-01-02 15:04:05.000000000-07:00"
+
+// Storage keeps everything in a single SQLite file, which suits a server
+// with one or a few users. Write transactions take the database lock when
+// they begin, so they never fail halfway on a lock upgrade.
+type Storage struct {
+	db  *sql.DB
+	dsn string
+}
+
+// NewStorage opens the database file named by dsn, e.g.
+// "sqlite:///var/lib/gokeeper/gokeeper.db", creating it if needed.
+func NewStorage(dsn string) (*Storage, error) {
+	path := strings.TrimPrefix(dsn, "sqlite://")
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open("sqlite", "file:"+path+separator+
+		"_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping database %w", err)
+	}
+	if err = Migrate(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate database %w", err)
+	}
+	return &Storage{
+		dsn: dsn,
+		db:  db,
+	}, nil
+}
+
+func (s Storage) BeginTx(ctx context.Context) (*database.Trx, error) {
+	return database.BeginTx(ctx, s.db)
+}
+
+func (s Storage) GetUser(ctx context.Context, login string) (domain.User, error) {
+	return s.scanUser(s.db.QueryRowContext(ctx, queries.GetUser, login))
+}
+
+func (s Storage) GetUserByID(ctx context.Context, userID uuid.UUID) (domain.User, error) {
+	return s.scanUser(s.db.QueryRowContext(ctx, queries.GetUserByID, userID))
+}
+
+// LockUser reads the user within tx. Write transactions take the database
+// lock when they begin, so the user can not change until tx ends.
+func (s Storage) LockUser(ctx context.Context, userID uuid.UUID, tx *database.Trx) (domain.User, error) {
+	return s.scanUser(tx.QueryRowContext(ctx, queries.GetUserByID, userID))
+}
+
+func (s Storage) scanUser(row *sql.Row) (domain.User, error) {
+	var userInDB domain.User
+	err := row.Scan(
+		&userInDB.ID,
+		&userInDB.Login,
+		&userInDB.PasswordHash,
+		&userInDB.TOTPSecret,
+		&userInDB.TOTPEnabled,
+		&userInDB.TOTPLastStep,
+		&userInDB.MFAFailures,
+		&userInDB.MFALockedUntil,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.User{}, domain.ErrUserNotFound
+		}
+		return domain.User{}, fmt.Errorf("failed to scan user from db: %w", err)
+	}
+	return userInDB, nil
+}
+
+func (s Storage) InsertUser(ctx context.Context, newUser domain.User, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.InsertUser, newUser.ID, newUser.Login, newUser.PasswordHash); err != nil {
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) UpdatePasswordHash(ctx context.Context, userID uuid.UUID, passwordHash []byte, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.UpdatePasswordHash, userID, passwordHash); err != nil {
+		return fmt.Errorf("failed to update password hash: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) InsertRefreshToken(ctx context.Context, rt domain.RefreshToken, tx *database.Trx) error {
+	_, err := tx.ExecContext(ctx, queries.InsertRefreshToken, rt.ID, rt.UserID, rt.FamilyID, rt.TokenHash, timestamp(rt.ExpiresAt))
+	if err != nil {
+		return fmt.Errorf("failed to insert refresh token: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) GetRefreshToken(ctx context.Context, tokenHash []byte, tx *database.Trx) (domain.RefreshToken, error) {
+	var rt domain.RefreshToken
+	row := tx.QueryRowContext(ctx, queries.GetRefreshToken, tokenHash)
+	err := row.Scan(&rt.ID, &rt.UserID, &rt.FamilyID, &rt.TokenHash, &rt.ExpiresAt, &rt.UsedAt, &rt.RevokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.RefreshToken{}, domain.ErrRefreshTokenNotFound
+		}
+		return domain.RefreshToken{}, fmt.Errorf("failed to scan refresh token from db: %w", err)
+	}
+	return rt, nil
+}
+
+func (s Storage) MarkRefreshTokenUsed(ctx context.Context, id uuid.UUID, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.MarkRefreshTokenUsed, id, timestamp(time.Now())); err != nil {
+		return fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.RevokeRefreshTokenFamily, familyID, timestamp(time.Now())); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// RevokeToken adds an access token to the revocation list until it expires.
+// Entries of already expired tokens are dropped on the way.
+func (s Storage) RevokeToken(ctx context.Context, jti string, userID uuid.UUID, expiresAt time.Time, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.DeleteExpiredRevokedTokens, timestamp(time.Now())); err != nil {
+		return fmt.Errorf("failed to delete expired revoked tokens: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, queries.InsertRevokedToken, jti, userID, timestamp(expiresAt)); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// RevokeAllTokens invalidates every access and refresh token of the user
+// issued up to now.
+func (s Storage) RevokeAllTokens(ctx context.Context, userID uuid.UUID, tx *database.Trx) error {
+	now := timestamp(time.Now())
+	if _, err := tx.ExecContext(ctx, queries.RevokeUserTokens, userID, now); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, queries.RevokeUserRefreshTokens, userID, now); err != nil {
+		return fmt.Errorf("failed to revoke user refresh tokens: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) IsTokenRevoked(ctx context.Context, jti string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	if err := s.db.QueryRowContext(ctx, queries.IsTokenRevoked, jti, userID, timestamp(issuedAt)).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+func (s Storage) SetTOTPSecret(ctx context.Context, userID uuid.UUID, secret []byte, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.SetTOTPSecret, userID, secret); err != nil {
+		return fmt.Errorf("failed to set totp secret: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) EnableTOTP(ctx context.Context, userID uuid.UUID, step int64, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.EnableTOTP, userID, step); err != nil {
+		return fmt.Errorf("failed to enable totp: %w", err)
+	}
+	return nil
+}
+
+// SetMFAFailures records the number of failed second factor checks of the
+// user and until when further checks are refused.
+func (s Storage) SetMFAFailures(ctx context.Context, userID uuid.UUID, failures int, lockedUntil *time.Time, tx *database.Trx) error {
+	var until *string
+	if lockedUntil != nil {
+		t := timestamp(*lockedUntil)
+		until = &t
+	}
+	if _, err := tx.ExecContext(ctx, queries.SetMFAFailures, userID, failures, until); err != nil {
+		return fmt.Errorf("failed to set mfa failures: %w", err)
+	}
+	return nil
+}
+
+// UpdateTOTPLastStep records step as the last one used and reports whether it
+// is newer than the one recorded before.
+func (s Storage) UpdateTOTPLastStep(ctx context.Context, userID uuid.UUID, step int64, tx *database.Trx) (bool, error) {
+	res, err := tx.ExecContext(ctx, queries.UpdateTOTPLastStep, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp step: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp step: %w", err)
+	}
+	return affected == 1, nil
+}
+
+func (s Storage) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes [][]byte, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.DeleteRecoveryCodes, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, codeHash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, queries.InsertRecoveryCode, userID, codeHash); err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether
+// one was found.
+func (s Storage) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash []byte, tx *database.Trx) (bool, error) {
+	res, err := tx.ExecContext(ctx, queries.UseRecoveryCode, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return affected == 1, nil
+}
+
+func (s Storage) GetVaultKey(ctx context.Context, userID uuid.UUID) ([]byte, error) {
+	var wrappedKey []byte
+	err := s.db.QueryRowContext(ctx, queries.GetVaultKey, userID).Scan(&wrappedKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to scan vault key from db: %w", err)
+	}
+	if wrappedKey == nil {
+		return nil, domain.ErrVaultKeyNotFound
+	}
+	return wrappedKey, nil
+}
+
+// SetVaultKey stores the user's wrapped vault key. An existing key is never
+// overwritten.
+func (s Storage) SetVaultKey(ctx context.Context, userID uuid.UUID, wrappedKey []byte, tx *database.Trx) error {
+	res, err := tx.ExecContext(ctx, queries.SetVaultKey, userID, wrappedKey)
+	if err != nil {
+		return fmt.Errorf("failed to set vault key: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to set vault key: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrVaultKeyConflict
+	}
+	return nil
+}
+
+func (s Storage) UpdateVaultKey(ctx context.Context, userID uuid.UUID, wrappedKey []byte, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.UpdateVaultKey, userID, wrappedKey); err != nil {
+		return fmt.Errorf("failed to update vault key: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) GetByID(ctx context.Context, id string, userID uuid.UUID, tx *database.Trx) (*domain.Data, error) {
+	var privateDataInDB domain.Data
+	row := tx.QueryRowContext(ctx, queries.GetDataByID, userID, id)
+
+	var metaIndex string
+	var blobID, payloadKey sql.NullString
+	privateDataInDB.ID = id
+	err := row.Scan(
+		&privateDataInDB.DataType, &privateDataInDB.Data, &privateDataInDB.MetaData, &metaIndex, &blobID, &payloadKey,
+		&privateDataInDB.SavedAt, &privateDataInDB.Version, &privateDataInDB.DeletedAt, &privateDataInDB.Revision,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrPrivateDataNotFound
+		}
+		return nil, fmt.Errorf("failed to scan private data from db: %w", err)
+	}
+	privateDataInDB.MetaIndex = strings.Fields(metaIndex)
+	privateDataInDB.BlobID = blobID.String
+	privateDataInDB.PayloadKey = payloadKey.String
+	return &privateDataInDB, nil
+}
+
+func (s Storage) InsertOrUpdate(ctx context.Context, pd *domain.Data, userID uuid.UUID, tx *database.Trx) error {
+	// The payload of records stored elsewhere is empty, but the column does
+	// not accept NULL.
+	data := pd.Data
+	if data == nil {
+		data = []byte{}
+	}
+	if _, err := tx.ExecContext(ctx, queries.InsertData,
+		pd.ID, pd.DataType, data, pd.MetaData, strings.Join(pd.MetaIndex, " "), timestamp(pd.SavedAt), userID,
+		sql.NullString{String: pd.BlobID, Valid: pd.BlobID != ""},
+		sql.NullString{String: pd.PayloadKey, Valid: pd.PayloadKey != ""},
+		pd.Version, pd.Revision,
+	); err != nil {
+		return fmt.Errorf("failed to insert or update data: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) Delete(ctx context.Context, id string, userID uuid.UUID, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.DeleteData, userID, id); err != nil {
+		return fmt.Errorf("failed to delete data: %w", err)
+	}
+	return nil
+}
+
+// MarkDeleted moves the record to the trash. It is kept as a tombstone until
+// it is purged.
+func (s Storage) MarkDeleted(ctx context.Context, id string, userID uuid.UUID, deletedAt time.Time, revision int64, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.MarkDataDeleted, userID, id, timestamp(deletedAt), revision); err != nil {
+		return fmt.Errorf("failed to mark data deleted: %w", err)
+	}
+	return nil
+}
+
+// GetDeleted returns the records in the user's trash without their payloads,
+// most recently deleted first.
+func (s Storage) GetDeleted(ctx context.Context, userID uuid.UUID) ([]domain.Data, error) {
+	rows, err := s.db.QueryContext(ctx, queries.GetDeletedData, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logger.Log.Error("error occurred during closing rows", zap.Error(err))
+		}
+	}()
+
+	var privateData []domain.Data
+	for rows.Next() {
+		var privateRow domain.Data
+		var metaIndex string
+		var blobID sql.NullString
+
+		err = rows.Scan(
+			&privateRow.ID, &privateRow.DataType, &privateRow.MetaData, &metaIndex, &blobID,
+			&privateRow.SavedAt, &privateRow.Version, &privateRow.DeletedAt, &privateRow.Revision,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data from db: %w", err)
+		}
+		privateRow.MetaIndex = strings.Fields(metaIndex)
+		privateRow.BlobID = blobID.String
+		privateData = append(privateData, privateRow)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate data from db: %w", err)
+	}
+	return privateData, nil
+}
+
+// GetTombstones returns the records of all users deleted before the given
+// time.
+func (s Storage) GetTombstones(ctx context.Context, deletedBefore time.Time) ([]domain.RecordKey, error) {
+	rows, err := s.db.QueryContext(ctx, queries.GetTombstones, timestamp(deletedBefore))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tombstones: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logger.Log.Error("error occurred during closing rows", zap.Error(err))
+		}
+	}()
+
+	var keys []domain.RecordKey
+	for rows.Next() {
+		var key domain.RecordKey
+		if err = rows.Scan(&key.UserID, &key.ID); err != nil {
+			return nil, fmt.Errorf("failed to scan tombstone: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate tombstones: %w", err)
+	}
+	return keys, nil
+}
+
+// GetAll returns a page of the user's records matching the filters of req,
+// in the requested order.
+func (s Storage) GetAll(ctx context.Context, req *domain.GetAllRequest, userID uuid.UUID) ([]domain.Data, error) {
+	query, args := getAllQuery(req, userID)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database: %w", err)
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			logger.Log.Error("error occurred during closing rows", zap.Error(err))
+		}
+	}()
+
+	var privateData []domain.Data
+	for rows.Next() {
+		var privateRow domain.Data
+		var metaIndex string
+		var blobID, payloadKey sql.NullString
+
+		err = rows.Scan(
+			&privateRow.ID, &privateRow.DataType, &privateRow.Data, &privateRow.MetaData, &metaIndex, &blobID, &payloadKey,
+			&privateRow.SavedAt, &privateRow.Version, &privateRow.Revision,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data from db: %w", err)
+		}
+		privateRow.MetaIndex = strings.Fields(metaIndex)
+		privateRow.BlobID = blobID.String
+		privateRow.PayloadKey = payloadKey.String
+		privateData = append(privateData, privateRow)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate data from db: %w", err)
+	}
+	return privateData, nil
+}
+
+// CountAll returns the number of the user's records matching the filters of
+// req.
+func (s Storage) CountAll(ctx context.Context, req *domain.GetAllRequest, userID uuid.UUID) (int64, error) {
+	var count int64
+	if err := s.db.QueryRowContext(ctx, queries.CountData, getAllFilterArgs(req, userID)...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count data: %w", err)
+	}
+	return count, nil
+}
+
+func getAllFilterArgs(req *domain.GetAllRequest, userID uuid.UUID) []any {
+	var typeName string
+	if req.Type != nil {
+		typeName = req.Type.String()
+	}
+	return []any{userID, req.MetaToken, typeName, req.IDPrefix}
+}
+
+// getAllQuery returns the query listing the page req asks for, with its
+// arguments.
+func getAllQuery(req *domain.GetAllRequest, userID uuid.UUID) (string, []any) {
+	var after domain.PageKey
+	if req.After != nil {
+		after = *req.After
+	}
+	args := append(getAllFilterArgs(req, userID), req.Limit, req.After != nil)
+	switch req.Order {
+	case domain.OrderBySavedAt:
+		return queries.GetAllDataBySavedAt, append(args, timestamp(after.SavedAt), after.ID)
+	case domain.OrderByType:
+		return queries.GetAllDataByType, append(args, after.Type, after.ID)
+	default:
+		return queries.GetAllDataByID, append(args, after.ID)
+	}
+}
+
+// LockPayload does nothing, since write transactions take the database lock
+// when they begin.
+func (s Storage) LockPayload(_ context.Context, _ string, _ *database.Trx) error {
+	return nil
+}
+
+// IsPayloadReferenced reports whether any record or version refers to the
+// payload with the given key. Payloads are content addressed, so several
+// records may share one.
+func (s Storage) IsPayloadReferenced(ctx context.Context, key string, tx *database.Trx) (bool, error) {
+	var referenced bool
+	if err := tx.QueryRowContext(ctx, queries.IsPayloadReferenced, key).Scan(&referenced); err != nil {
+		return false, fmt.Errorf("failed to check payload references: %w", err)
+	}
+	return referenced, nil
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
+}
+
+// IsBlobReferenced reports whether any record or version refers to the blob.
+func (s Storage) IsBlobReferenced(ctx context.Context, blobID string, tx *database.Trx) (bool, error) {
+	var referenced bool
+	if err := tx.QueryRowContext(ctx, queries.IsBlobReferenced, blobID).Scan(&referenced); err != nil {
+		return false, fmt.Errorf("failed to check blob references: %w", err)
+	}
+	return referenced, nil
+}
+
+func (s Storage) InsertVersion(ctx context.Context, pd *domain.Data, userID uuid.UUID, tx *database.Trx) error {
+	data := pd.Data
+	if data == nil {
+		data = []byte{}
+	}
+	if _, err := tx.ExecContext(ctx, queries.InsertVersion,
+		pd.ID, pd.DataType, data, pd.MetaData, strings.Join(pd.MetaIndex, " "), timestamp(pd.SavedAt), userID,
+		sql.NullString{String: pd.BlobID, Valid: pd.BlobID != ""},
+		sql.NullString{String: pd.PayloadKey, Valid: pd.PayloadKey != ""},
+		pd.Version,
+	); err != nil {
+		return fmt.Errorf("failed to insert version: %w", err)
+	}
+	return nil
+}
+
+// GetVersions returns the versions of a record without their payloads,
+// oldest first.
+func (s Storage) GetVersions(ctx context.Context, id string, userID uuid.UUID, tx *database.Trx) ([]domain.Data, error) {
+	rows, err := tx.QueryContext(ctx, queries.GetVersions, userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query versions: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logger.Log.Error("error occurred during closing rows", zap.Error(err))
+		}
+	}()
+
+	var versions []domain.Data
+	for rows.Next() {
+		version := domain.Data{ID: id}
+		var metaIndex string
+		var blobID, payloadKey sql.NullString
+
+		err = rows.Scan(
+			&version.DataType, &version.MetaData, &metaIndex, &blobID, &payloadKey, &version.SavedAt, &version.Version,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan version from db: %w", err)
+		}
+		version.MetaIndex = strings.Fields(metaIndex)
+		version.BlobID = blobID.String
+		version.PayloadKey = payloadKey.String
+		versions = append(versions, version)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate versions from db: %w", err)
+	}
+	return versions, nil
+}
+
+func (s Storage) GetVersion(ctx context.Context, id string, userID uuid.UUID, version int64, tx *database.Trx) (*domain.Data, error) {
+	pd := domain.Data{ID: id}
+	var metaIndex string
+	var blobID, payloadKey sql.NullString
+	err := tx.QueryRowContext(ctx, queries.GetVersion, userID, id, version).Scan(
+		&pd.DataType, &pd.Data, &pd.MetaData, &metaIndex, &blobID, &payloadKey, &pd.SavedAt, &pd.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrVersionNotFound
+		}
+		return nil, fmt.Errorf("failed to scan version from db: %w", err)
+	}
+	pd.MetaIndex = strings.Fields(metaIndex)
+	pd.BlobID = blobID.String
+	pd.PayloadKey = payloadKey.String
+	return &pd, nil
+}
+
+func (s Storage) DeleteVersion(ctx context.Context, id string, userID uuid.UUID, version int64, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.DeleteVersion, userID, id, version); err != nil {
+		return fmt.Errorf("failed to delete version: %w", err)
+	}
+	return nil
+}
+
+// NextRevision increments the revision of the user and returns it. The user
+// stays locked until tx ends, so revisions are committed in order.
+func (s Storage) NextRevision(ctx context.Context, userID uuid.UUID, tx *database.Trx) (int64, error) {
+	var revision int64
+	if err := tx.QueryRowContext(ctx, queries.NextRevision, userID).Scan(&revision); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, domain.ErrUserNotFound
+		}
+		return 0, fmt.Errorf("failed to increment revision: %w", err)
+	}
+	return revision, nil
+}
+
+// GetRevisions returns the current revision of the user and the latest
+// revision of a purged record.
+func (s Storage) GetRevisions(ctx context.Context, userID uuid.UUID, tx *database.Trx) (int64, int64, error) {
+	var revision, purgedRevision int64
+	if err := tx.QueryRowContext(ctx, queries.GetRevisions, userID).Scan(&revision, &purgedRevision); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, domain.ErrUserNotFound
+		}
+		return 0, 0, fmt.Errorf("failed to get revisions: %w", err)
+	}
+	return revision, purgedRevision, nil
+}
+
+func (s Storage) SetPurgedRevision(ctx context.Context, userID uuid.UUID, revision int64, tx *database.Trx) error {
+	if _, err := tx.ExecContext(ctx, queries.SetPurgedRevision, userID, revision); err != nil {
+		return fmt.Errorf("failed to set purged revision: %w", err)
+	}
+	return nil
+}
+
+// GetChanges returns the user's records changed after the requested revision,
+// tombstones included, in the order they were changed.
+func (s Storage) GetChanges(ctx context.Context, userID uuid.UUID, req *domain.ChangesRequest, tx *database.Trx) ([]domain.Data, error) {
+	rows, err := tx.QueryContext(ctx, queries.GetChanges, userID, req.Since, req.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query changes: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logger.Log.Error("error occurred during closing rows", zap.Error(err))
+		}
+	}()
+
+	var privateData []domain.Data
+	for rows.Next() {
+		var privateRow domain.Data
+		var metaIndex string
+		var blobID, payloadKey sql.NullString
+
+		err = rows.Scan(
+			&privateRow.ID, &privateRow.DataType, &privateRow.Data, &privateRow.MetaData, &metaIndex, &blobID, &payloadKey,
+			&privateRow.SavedAt, &privateRow.Version, &privateRow.DeletedAt, &privateRow.Revision,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan change from db: %w", err)
+		}
+		privateRow.MetaIndex = strings.Fields(metaIndex)
+		privateRow.BlobID = blobID.String
+		privateRow.PayloadKey = payloadKey.String
+		privateData = append(privateData, privateRow)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate changes from db: %w", err)
+	}
+	return privateData, nil
+}
@@ -0,0 +1,366 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"gokeeper/internal/server/adapters/storage/database"
+	"gokeeper/pkg/domain"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+func newTestStorage(t *testing.T, path string) *Storage {
+	t.Helper()
+	s, err := NewStorage("sqlite://" + path)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+// inTx runs f in a transaction and commits it.
+func inTx(t *testing.T, s *Storage, f func(tx *database.Trx)) {
+	t.Helper()
+	tx, err := s.BeginTx(context.Background())
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	defer tx.Rollback()
+	f(tx)
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+}
+
+func insertUser(t *testing.T, s *Storage, login string) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	inTx(t, s, func(tx *database.Trx) {
+		if err := s.InsertUser(context.Background(), domain.User{ID: id, Login: login, PasswordHash: []byte("hash")}, tx); err != nil {
+			t.Fatalf("InsertUser: %v", err)
+		}
+	})
+	return id
+}
+
+func saveRecord(t *testing.T, s *Storage, userID uuid.UUID, pd domain.Data) {
+	t.Helper()
+	inTx(t, s, func(tx *database.Trx) {
+		revision, err := s.NextRevision(context.Background(), userID, tx)
+		if err != nil {
+			t.Fatalf("NextRevision: %v", err)
+		}
+		pd.Revision = revision
+		if pd.MetaData == nil {
+			pd.MetaData = []byte("meta")
+		}
+		if err = s.InsertOrUpdate(context.Background(), &pd, userID, tx); err != nil {
+			t.Fatalf("InsertOrUpdate: %v", err)
+		}
+	})
+}
+
+func TestReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "gokeeper.db")
+	s := newTestStorage(t, path)
+	alice := insertUser(t, s, "alice")
+	s.db.Close()
+
+	// Opening an existing database migrates it again without losing data.
+	s = newTestStorage(t, path)
+	u, err := s.GetUser(ctx, "alice")
+	if err != nil || u.ID != alice || string(u.PasswordHash) != "hash" {
+		t.Fatalf("GetUser after reopening = %+v, %v", u, err)
+	}
+	if _, err = s.GetUser(ctx, "bob"); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("GetUser of an unknown user = %v, want %v", err, domain.ErrUserNotFound)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "gokeeper.db"))
+	alice := insertUser(t, s, "alice")
+
+	tx, err := s.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	if _, err = s.NextRevision(ctx, alice, tx); err != nil {
+		t.Fatalf("NextRevision: %v", err)
+	}
+	if err = s.InsertOrUpdate(ctx, &domain.Data{ID: "note", MetaData: []byte("meta"), Data: []byte("secret")}, alice, tx); err != nil {
+		t.Fatalf("InsertOrUpdate: %v", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	inTx(t, s, func(tx *database.Trx) {
+		if _, err = s.GetByID(ctx, "note", alice, tx); !errors.Is(err, domain.ErrPrivateDataNotFound) {
+			t.Errorf("GetByID of a rolled back record = %v, want %v", err, domain.ErrPrivateDataNotFound)
+		}
+		if revision, _, _ := s.GetRevisions(ctx, alice, tx); revision != 0 {
+			t.Errorf("revision after rollback = %d, want 0", revision)
+		}
+	})
+}
+
+func TestConcurrentTransactions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "gokeeper.db"))
+	alice := insertUser(t, s, "alice")
+
+	// Write transactions take the database lock when they begin, so they
+	// wait for each other instead of failing on a lock upgrade.
+	const workers = 8
+	revisions := make([]int64, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx, err := s.BeginTx(ctx)
+			if err != nil {
+				t.Errorf("BeginTx: %v", err)
+				return
+			}
+			defer tx.Rollback()
+			if _, _, err = s.GetRevisions(ctx, alice, tx); err != nil {
+				t.Errorf("GetRevisions: %v", err)
+				return
+			}
+			if revisions[i], err = s.NextRevision(ctx, alice, tx); err != nil {
+				t.Errorf("NextRevision: %v", err)
+				return
+			}
+			if err = tx.Commit(); err != nil {
+				t.Errorf("Commit: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i] < revisions[j] })
+	for i, revision := range revisions {
+		if revision != int64(i+1) {
+			t.Fatalf("revisions = %v, want 1 to %d", revisions, workers)
+		}
+	}
+}
+
+func TestTimestampsAcrossZones(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "gokeeper.db"))
+	alice := insertUser(t, s, "alice")
+
+	// Timestamps are stored as text in UTC, so they sort by the instant
+	// whatever zone they were given in.
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	east := time.FixedZone("east", 9*60*60)
+	west := time.FixedZone("west", -5*60*60)
+	saveRecord(t, s, alice, domain.Data{ID: "a", SavedAt: base.Add(2 * time.Hour).In(west)})
+	saveRecord(t, s, alice, domain.Data{ID: "b", SavedAt: base.In(east)})
+	saveRecord(t, s, alice, domain.Data{ID: "c", SavedAt: base.Add(time.Hour)})
+
+	req := &domain.GetAllRequest{Limit: 2, Order: domain.OrderBySavedAt}
+	page, err := s.GetAll(ctx, req, alice)
+	if err != nil || len(page) != 2 || page[0].ID != "b" || page[1].ID != "c" {
+		t.Fatalf("first page by saved_at = %+v, %v", page, err)
+	}
+	if !page[0].SavedAt.Equal(base) {
+		t.Fatalf("SavedAt = %v, want %v", page[0].SavedAt, base)
+	}
+	after := domain.KeyOf(page[1], req.Order)
+	req.After = &after
+	if page, err = s.GetAll(ctx, req, alice); err != nil || len(page) != 1 || page[0].ID != "a" {
+		t.Fatalf("second page by saved_at = %+v, %v", page, err)
+	}
+
+	deletedAt := base.In(east)
+	inTx(t, s, func(tx *database.Trx) {
+		if err = s.MarkDeleted(ctx, "b", alice, deletedAt, 4, tx); err != nil {
+			t.Fatalf("MarkDeleted: %v", err)
+		}
+	})
+	keys, err := s.GetTombstones(ctx, deletedAt.Add(time.Second).In(west))
+	if err != nil || len(keys) != 1 || keys[0] != (domain.RecordKey{UserID: alice, ID: "b"}) {
+		t.Fatalf("GetTombstones = %v, %v", keys, err)
+	}
+	if keys, _ = s.GetTombstones(ctx, deletedAt.In(west)); len(keys) != 0 {
+		t.Fatalf("GetTombstones before the deletion = %v", keys)
+	}
+
+	issuedAt := time.Now().In(east)
+	inTx(t, s, func(tx *database.Trx) {
+		if err = s.RevokeAllTokens(ctx, alice, tx); err != nil {
+			t.Fatalf("RevokeAllTokens: %v", err)
+		}
+	})
+	if revoked, err := s.IsTokenRevoked(ctx, "jti", alice, issuedAt); err != nil || !revoked {
+		t.Fatalf("IsTokenRevoked of a token issued before RevokeAllTokens = %v, %v", revoked, err)
+	}
+	if revoked, _ := s.IsTokenRevoked(ctx, "jti", alice, time.Now().Add(time.Minute).In(west)); revoked {
+		t.Fatalf("IsTokenRevoked of a token issued after RevokeAllTokens = true")
+	}
+}
+
+func TestGetAllFilters(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "gokeeper.db"))
+	alice := insertUser(t, s, "alice")
+	bob := insertUser(t, s, "bob")
+
+	saveRecord(t, s, alice, domain.Data{ID: "bank/card", DataType: domain.CARD, MetaIndex: []string{"t1", "t2"}})
+	saveRecord(t, s, alice, domain.Data{ID: "bank/login", DataType: domain.LOGIN_PASSWORD, MetaIndex: []string{"t2"}})
+	saveRecord(t, s, alice, domain.Data{ID: "notes", DataType: domain.TEXT})
+	saveRecord(t, s, bob, domain.Data{ID: "bank/card", DataType: domain.CARD, MetaIndex: []string{"t1"}})
+
+	card := domain.CARD
+	tests := []struct {
+		name string
+		req  domain.GetAllRequest
+		want []string
+	}{
+		{"all", domain.GetAllRequest{}, []string{"bank/card", "bank/login", "notes"}},
+		{"type", domain.GetAllRequest{Type: &card}, []string{"bank/card"}},
+		{"id prefix", domain.GetAllRequest{IDPrefix: "bank/"}, []string{"bank/card", "bank/login"}},
+		{"meta token", domain.GetAllRequest{MetaToken: "t2"}, []string{"bank/card", "bank/login"}},
+		{"meta token prefix", domain.GetAllRequest{MetaToken: "t"}, nil},
+		{"id prefix wildcard", domain.GetAllRequest{IDPrefix: "bank%"}, nil},
+	}
+	for _, tt := range tests {
+		tt.req.Limit = 10
+		page, err := s.GetAll(ctx, &tt.req, alice)
+		if err != nil {
+			t.Fatalf("%s: GetAll: %v", tt.name, err)
+		}
+		var ids []string
+		for _, pd := range page {
+			ids = append(ids, pd.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("%s: GetAll = %v, want %v", tt.name, ids, tt.want)
+		}
+		if count, err := s.CountAll(ctx, &tt.req, alice); err != nil || count != int64(len(tt.want)) {
+			t.Errorf("%s: CountAll = %d, %v, want %d", tt.name, count, err, len(tt.want))
+		}
+	}
+}
+
+func TestTrashAndChanges(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "gokeeper.db"))
+	alice := insertUser(t, s, "alice")
+
+	for _, id := range []string{"a", "b", "c"} {
+		saveRecord(t, s, alice, domain.Data{ID: id, Data: []byte("secret " + id), MetaIndex: []string{"t"}})
+	}
+	inTx(t, s, func(tx *database.Trx) {
+		revision, err := s.NextRevision(ctx, alice, tx)
+		if err != nil {
+			t.Fatalf("NextRevision: %v", err)
+		}
+		if err = s.MarkDeleted(ctx, "a", alice, time.Now(), revision, tx); err != nil {
+			t.Fatalf("MarkDeleted: %v", err)
+		}
+	})
+
+	deleted, err := s.GetDeleted(ctx, alice)
+	if err != nil || len(deleted) != 1 || deleted[0].ID != "a" || deleted[0].Data != nil || deleted[0].DeletedAt == nil {
+		t.Fatalf("GetDeleted = %+v, %v", deleted, err)
+	}
+	if count, _ := s.CountAll(ctx, &domain.GetAllRequest{}, alice); count != 2 {
+		t.Fatalf("CountAll with a record in the trash = %d, want 2", count)
+	}
+
+	inTx(t, s, func(tx *database.Trx) {
+		changes, err := s.GetChanges(ctx, alice, &domain.ChangesRequest{Since: 1, Limit: 10}, tx)
+		if err != nil {
+			t.Fatalf("GetChanges: %v", err)
+		}
+		var ids string
+		for _, pd := range changes {
+			ids += pd.ID
+		}
+		if ids != "bca" || changes[2].DeletedAt == nil || changes[2].Revision != 4 {
+			t.Fatalf("GetChanges since 1 = %s, want bca ending with the tombstone", ids)
+		}
+		if changes[0].MetaIndex[0] != "t" || string(changes[0].Data) != "secret b" {
+			t.Fatalf("GetChanges returned %+v", changes[0])
+		}
+		if changes, _ = s.GetChanges(ctx, alice, &domain.ChangesRequest{Since: 0, Limit: 1}, tx); len(changes) != 1 {
+			t.Fatalf("GetChanges with limit 1 = %d records", len(changes))
+		}
+	})
+
+	// Saving the record again takes it out of the trash.
+	saveRecord(t, s, alice, domain.Data{ID: "a"})
+	if deleted, _ = s.GetDeleted(ctx, alice); len(deleted) != 0 {
+		t.Fatalf("GetDeleted after saving the record = %+v", deleted)
+	}
+}
+
+func TestStaleBlobs(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "gokeeper.db"))
+	alice := insertUser(t, s, "alice")
+	pending, unreferenced, referenced := uuid.New(), uuid.New(), uuid.New()
+
+	inTx(t, s, func(tx *database.Trx) {
+		for _, id := range []uuid.UUID{pending, unreferenced, referenced} {
+			if err := s.InsertBlob(ctx, domain.Blob{ID: id, UserID: alice, Size: 10, ChunkSize: 10}, tx); err != nil {
+				t.Fatalf("InsertBlob: %v", err)
+			}
+		}
+		for _, id := range []uuid.UUID{unreferenced, referenced} {
+			if err := s.CompleteBlob(ctx, id, []byte("sum"), tx); err != nil {
+				t.Fatalf("CompleteBlob: %v", err)
+			}
+		}
+		if err := s.InsertBlobChunk(ctx, pending, 0, tx); err != nil {
+			t.Fatalf("InsertBlobChunk: %v", err)
+		}
+	})
+	saveRecord(t, s, alice, domain.Data{ID: "file", BlobID: referenced.String()})
+
+	inTx(t, s, func(tx *database.Trx) {
+		if size, err := s.GetBlobsSize(ctx, alice, tx); err != nil || size != 30 {
+			t.Errorf("GetBlobsSize = %d, %v, want 30", size, err)
+		}
+	})
+	stale, err := s.GetStaleBlobs(ctx, time.Now().Add(time.Second))
+	if err != nil || len(stale) != 2 {
+		t.Fatalf("GetStaleBlobs = %v, %v, want 2 blobs", stale, err)
+	}
+	for _, id := range stale {
+		if id == referenced {
+			t.Errorf("GetStaleBlobs returned a referenced blob")
+		}
+	}
+	if stale, _ = s.GetStaleBlobs(ctx, time.Now().Add(-time.Minute)); len(stale) != 0 {
+		t.Errorf("GetStaleBlobs returned new blobs: %v", stale)
+	}
+
+	// Deleting a blob drops its chunks.
+	inTx(t, s, func(tx *database.Trx) {
+		if err = s.DeleteBlob(ctx, pending, tx); err != nil {
+			t.Fatalf("DeleteBlob: %v", err)
+		}
+	})
+	if chunks, _ := s.GetBlobChunks(ctx, pending); len(chunks) != 0 {
+		t.Errorf("GetBlobChunks of a deleted blob = %v", chunks)
+	}
+	if _, err = s.GetBlob(ctx, pending, alice); !errors.Is(err, domain.ErrBlobNotFound) {
+		t.Errorf("GetBlob of a deleted blob = %v, want %v", err, domain.ErrBlobNotFound)
+	}
+}
@@ -6,14 +6,33 @@ import (
 	"errors"
 )
 
+// Trx is a transaction of a storage. Storages backed by database/sql wrap a
+// *sql.Tx; others, which change their state right away, register how to undo
+// each change with OnRollback.
 type Trx struct {
 	*sql.Tx
+	undo    []func()
+	release func()
+}
+
+// NewTrx starts a transaction for a storage that is not backed by
+// database/sql. release, if not nil, is called once the transaction ends.
+func NewTrx(release func()) *Trx {
+	return &Trx{release: release}
+}
+
+// OnRollback registers a function undoing a change made within the
+// transaction. The functions run in reverse order on rollback.
+func (t *Trx) OnRollback(undo func()) {
+	t.undo = append(t.undo, undo)
 }
 
 func (t *Trx) Commit() error {
 	if t.Tx != nil {
 		return t.Tx.Commit()
 	}
+	t.undo = nil
+	t.end()
 	return nil
 }
 
@@ -21,9 +40,22 @@ func (t *Trx) Rollback() error {
 	if t.Tx != nil {
 		return t.Tx.Rollback()
 	}
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.undo = nil
+	t.end()
 	return nil
 }
 
+// end releases the transaction the first time it ends.
+func (t *Trx) end() {
+	if t.release != nil {
+		t.release()
+		t.release = nil
+	}
+}
+
 func BeginTx(ctx context.Context, DB *sql.DB) (*Trx, error) {
 	if DB != nil {
 		sqlTx, err := DB.BeginTx(ctx, nil)
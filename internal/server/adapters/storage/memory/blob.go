@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"gokeeper/internal/server/adapters/storage/database"
+	"gokeeper/pkg/domain"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type chunkKey struct {
+	blobID uuid.UUID
+	index  int
+}
+
+func (s *Storage) InsertBlob(_ context.Context, blob domain.Blob, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob.CreatedAt = time.Now()
+	put(s, tx, s.blobs, blob.ID, cloneBlob(blob))
+	return nil
+}
+
+func (s *Storage) GetBlob(_ context.Context, id uuid.UUID, userID uuid.UUID) (domain.Blob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob, ok := s.blobs[id]
+	if !ok || blob.UserID != userID {
+		return domain.Blob{}, domain.ErrBlobNotFound
+	}
+	return cloneBlob(blob), nil
+}
+
+// LockBlob reads the blob of any user. Transactions run one at a time, so the
+// blob can not change until tx ends.
+func (s *Storage) LockBlob(_ context.Context, id uuid.UUID, _ *database.Trx) (domain.Blob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob, ok := s.blobs[id]
+	if !ok {
+		return domain.Blob{}, domain.ErrBlobNotFound
+	}
+	return cloneBlob(blob), nil
+}
+
+// GetBlobsSize returns the total declared size of the blobs of a user.
+func (s *Storage) GetBlobsSize(_ context.Context, userID uuid.UUID, _ *database.Trx) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var size int64
+	for _, blob := range s.blobs {
+		if blob.UserID == userID {
+			size += blob.Size
+		}
+	}
+	return size, nil
+}
+
+// GetStaleBlobs returns the blobs created before the given time that were
+// either never completed or are not referenced by any record or version.
+func (s *Storage) GetStaleBlobs(_ context.Context, createdBefore time.Time) ([]uuid.UUID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []uuid.UUID
+	for id, blob := range s.blobs {
+		if !blob.CreatedAt.Before(createdBefore) {
+			continue
+		}
+		blobID := id.String()
+		if !blob.Completed || !s.isReferenced(func(pd domain.Data) bool { return pd.BlobID == blobID }) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *Storage) InsertBlobChunk(_ context.Context, blobID uuid.UUID, index int, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := chunkKey{blobID: blobID, index: index}
+	if _, ok := s.blobChunks[key]; !ok {
+		put(s, tx, s.blobChunks, key, struct{}{})
+	}
+	return nil
+}
+
+func (s *Storage) GetBlobChunks(_ context.Context, blobID uuid.UUID) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var chunks []int
+	for key := range s.blobChunks {
+		if key.blobID == blobID {
+			chunks = append(chunks, key.index)
+		}
+	}
+	sort.Ints(chunks)
+	return chunks, nil
+}
+
+func (s *Storage) CompleteBlob(_ context.Context, blobID uuid.UUID, checksum []byte, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, ok := s.blobs[blobID]
+	if !ok {
+		return nil
+	}
+	blob.Checksum = bytes.Clone(checksum)
+	blob.Completed = true
+	put(s, tx, s.blobs, blobID, blob)
+	return nil
+}
+
+// DeleteBlob deletes the blob together with its chunks.
+func (s *Storage) DeleteBlob(_ context.Context, blobID uuid.UUID, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.blobChunks {
+		if key.blobID == blobID {
+			remove(s, tx, s.blobChunks, key)
+		}
+	}
+	remove(s, tx, s.blobs, blobID)
+	return nil
+}
+
+func cloneBlob(blob domain.Blob) domain.Blob {
+	blob.Checksum = bytes.Clone(blob.Checksum)
+	return blob
+}
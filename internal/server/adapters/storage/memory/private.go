@@ -0,0 +1,295 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"gokeeper/internal/server/adapters/storage/database"
+	"gokeeper/pkg/domain"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type recordKey struct {
+	userID uuid.UUID
+	id     string
+}
+
+type versionKey struct {
+	recordKey
+	version int64
+}
+
+func (s *Storage) GetByID(_ context.Context, id string, userID uuid.UUID, _ *database.Trx) (*domain.Data, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pd, ok := s.private[recordKey{userID: userID, id: id}]
+	if !ok {
+		return nil, domain.ErrPrivateDataNotFound
+	}
+	pd = cloneData(pd)
+	return &pd, nil
+}
+
+func (s *Storage) InsertOrUpdate(_ context.Context, pd *domain.Data, userID uuid.UUID, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := cloneData(*pd)
+	stored.DeletedAt = nil
+	put(s, tx, s.private, recordKey{userID: userID, id: pd.ID}, stored)
+	return nil
+}
+
+func (s *Storage) Delete(_ context.Context, id string, userID uuid.UUID, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remove(s, tx, s.private, recordKey{userID: userID, id: id})
+	return nil
+}
+
+// MarkDeleted moves the record to the trash. It is kept as a tombstone until
+// it is purged.
+func (s *Storage) MarkDeleted(_ context.Context, id string, userID uuid.UUID, deletedAt time.Time, revision int64, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := recordKey{userID: userID, id: id}
+	pd, ok := s.private[key]
+	if !ok {
+		return nil
+	}
+	pd.DeletedAt = &deletedAt
+	pd.Revision = revision
+	put(s, tx, s.private, key, pd)
+	return nil
+}
+
+// GetDeleted returns the records in the user's trash without their payloads,
+// most recently deleted first.
+func (s *Storage) GetDeleted(_ context.Context, userID uuid.UUID) ([]domain.Data, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var privateData []domain.Data
+	for key, pd := range s.private {
+		if key.userID == userID && pd.DeletedAt != nil {
+			pd = cloneData(pd)
+			pd.Data = nil
+			pd.PayloadKey = ""
+			privateData = append(privateData, pd)
+		}
+	}
+	sort.Slice(privateData, func(i, j int) bool {
+		return privateData[i].DeletedAt.After(*privateData[j].DeletedAt)
+	})
+	return privateData, nil
+}
+
+// GetTombstones returns the records of all users deleted before the given
+// time.
+func (s *Storage) GetTombstones(_ context.Context, deletedBefore time.Time) ([]domain.RecordKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []domain.RecordKey
+	for key, pd := range s.private {
+		if pd.DeletedAt != nil && pd.DeletedAt.Before(deletedBefore) {
+			keys = append(keys, domain.RecordKey{UserID: key.userID, ID: key.id})
+		}
+	}
+	return keys, nil
+}
+
+// GetAll returns a page of the user's records matching the filters of req,
+// in the requested order.
+func (s *Storage) GetAll(_ context.Context, req *domain.GetAllRequest, userID uuid.UUID) ([]domain.Data, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var privateData []domain.Data
+	for key, pd := range s.private {
+		if key.userID != userID || pd.DeletedAt != nil || !req.Matches(pd) {
+			continue
+		}
+		if req.After != nil && !req.After.Less(domain.KeyOf(pd, req.Order)) {
+			continue
+		}
+		privateData = append(privateData, cloneData(pd))
+	}
+	sort.Slice(privateData, func(i, j int) bool {
+		return domain.KeyOf(privateData[i], req.Order).Less(domain.KeyOf(privateData[j], req.Order))
+	})
+
+	end := min(req.Limit, uint64(len(privateData)))
+	return privateData[:end], nil
+}
+
+// CountAll returns the number of the user's records matching the filters of
+// req.
+func (s *Storage) CountAll(_ context.Context, req *domain.GetAllRequest, userID uuid.UUID) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for key, pd := range s.private {
+		if key.userID == userID && pd.DeletedAt == nil && req.Matches(pd) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// LockPayload does nothing, since transactions are serialized.
+func (s *Storage) LockPayload(_ context.Context, _ string, _ *database.Trx) error {
+	return nil
+}
+
+// IsPayloadReferenced reports whether any record or version refers to the
+// payload with the given key.
+func (s *Storage) IsPayloadReferenced(_ context.Context, key string, _ *database.Trx) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.isReferenced(func(pd domain.Data) bool {
+		return pd.PayloadKey == key
+	}), nil
+}
+
+// IsBlobReferenced reports whether any record or version refers to the blob.
+func (s *Storage) IsBlobReferenced(_ context.Context, blobID string, _ *database.Trx) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.isReferenced(func(pd domain.Data) bool {
+		return pd.BlobID == blobID
+	}), nil
+}
+
+func (s *Storage) InsertVersion(_ context.Context, pd *domain.Data, userID uuid.UUID, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := versionKey{recordKey: recordKey{userID: userID, id: pd.ID}, version: pd.Version}
+	put(s, tx, s.versions, key, cloneData(*pd))
+	return nil
+}
+
+// GetVersions returns the versions of a record without their payloads,
+// oldest first.
+func (s *Storage) GetVersions(_ context.Context, id string, userID uuid.UUID, _ *database.Trx) ([]domain.Data, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var versions []domain.Data
+	for key, pd := range s.versions {
+		if key.recordKey == (recordKey{userID: userID, id: id}) {
+			pd = cloneData(pd)
+			pd.Data = nil
+			versions = append(versions, pd)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+func (s *Storage) GetVersion(_ context.Context, id string, userID uuid.UUID, version int64, _ *database.Trx) (*domain.Data, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pd, ok := s.versions[versionKey{recordKey: recordKey{userID: userID, id: id}, version: version}]
+	if !ok {
+		return nil, domain.ErrVersionNotFound
+	}
+	pd = cloneData(pd)
+	return &pd, nil
+}
+
+func (s *Storage) DeleteVersion(_ context.Context, id string, userID uuid.UUID, version int64, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remove(s, tx, s.versions, versionKey{recordKey: recordKey{userID: userID, id: id}, version: version})
+	return nil
+}
+
+// isReferenced reports whether match holds for any record or version. It
+// must be called with s.mu held.
+func (s *Storage) isReferenced(match func(pd domain.Data) bool) bool {
+	for _, pd := range s.private {
+		if match(pd) {
+			return true
+		}
+	}
+	for _, pd := range s.versions {
+		if match(pd) {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneData(pd domain.Data) domain.Data {
+	pd.MetaData = bytes.Clone(pd.MetaData)
+	pd.MetaIndex = slices.Clone(pd.MetaIndex)
+	pd.Data = bytes.Clone(pd.Data)
+	return pd
+}
+
+// NextRevision increments the revision of the user and returns it.
+func (s *Storage) NextRevision(_ context.Context, userID uuid.UUID, tx *database.Trx) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return 0, domain.ErrUserNotFound
+	}
+	u.revision++
+	put(s, tx, s.users, userID, u)
+	return u.revision, nil
+}
+
+// GetRevisions returns the current revision of the user and the latest
+// revision of a purged record.
+func (s *Storage) GetRevisions(_ context.Context, userID uuid.UUID, _ *database.Trx) (int64, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return 0, 0, domain.ErrUserNotFound
+	}
+	return u.revision, u.purgedRevision, nil
+}
+
+func (s *Storage) SetPurgedRevision(_ context.Context, userID uuid.UUID, revision int64, tx *database.Trx) error {
+	s.updateUser(userID, tx, func(u *user) {
+		u.purgedRevision = max(u.purgedRevision, revision)
+	})
+	return nil
+}
+
+// GetChanges returns the user's records changed after the requested revision,
+// tombstones included, in the order they were changed.
+func (s *Storage) GetChanges(_ context.Context, userID uuid.UUID, req *domain.ChangesRequest, _ *database.Trx) ([]domain.Data, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var privateData []domain.Data
+	for key, pd := range s.private {
+		if key.userID == userID && pd.Revision > req.Since {
+			privateData = append(privateData, cloneData(pd))
+		}
+	}
+	sort.Slice(privateData, func(i, j int) bool {
+		return privateData[i].Revision < privateData[j].Revision
+	})
+	return privateData[:min(req.Limit, uint64(len(privateData)))], nil
+}
@@ -0,0 +1,387 @@
+// Package memory implements the storage in memory, for tests and ephemeral
+// servers. All data is lost when the process exits.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"gokeeper/internal/server/adapters/storage/database"
+	"gokeeper/pkg/domain"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type user struct {
+	domain.User
+	vaultKey        []byte
+	tokensRevokedAt *time.Time
+	revision        int64
+	purgedRevision  int64
+}
+
+type recoveryCodeKey struct {
+	userID   uuid.UUID
+	codeHash string
+}
+
+// Storage keeps everything in maps guarded by a single lock, so it is safe
+// for concurrent use. Changes made within a transaction are visible right
+// away and undone on rollback. Transactions are serialized: BeginTx waits
+// until the previous transaction ended, so what one transaction reads stays
+// as it is until it ends, and a rollback never undoes changes of another one.
+// Reads outside of a transaction see uncommitted changes.
+type Storage struct {
+	mu sync.RWMutex
+	// tx holds a token while a transaction is running.
+	tx chan struct{}
+
+	users           map[uuid.UUID]user
+	logins          map[string]uuid.UUID
+	refreshTokens   map[uuid.UUID]domain.RefreshToken
+	refreshTokenIDs map[string]uuid.UUID
+	revokedTokens   map[string]time.Time
+	recoveryCodes   map[recoveryCodeKey]bool
+
+	private    map[recordKey]domain.Data
+	versions   map[versionKey]domain.Data
+	blobs      map[uuid.UUID]domain.Blob
+	blobChunks map[chunkKey]struct{}
+}
+
+func NewStorage() *Storage {
+	return &Storage{
+		tx:              make(chan struct{}, 1),
+		users:           make(map[uuid.UUID]user),
+		logins:          make(map[string]uuid.UUID),
+		refreshTokens:   make(map[uuid.UUID]domain.RefreshToken),
+		refreshTokenIDs: make(map[string]uuid.UUID),
+		revokedTokens:   make(map[string]time.Time),
+		recoveryCodes:   make(map[recoveryCodeKey]bool),
+		private:         make(map[recordKey]domain.Data),
+		versions:        make(map[versionKey]domain.Data),
+		blobs:           make(map[uuid.UUID]domain.Blob),
+		blobChunks:      make(map[chunkKey]struct{}),
+	}
+}
+
+// BeginTx waits until no other transaction is running or ctx is done.
+func (s *Storage) BeginTx(ctx context.Context) (*database.Trx, error) {
+	select {
+	case s.tx <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return database.NewTrx(func() { <-s.tx }), nil
+}
+
+func (s *Storage) GetUser(_ context.Context, login string) (domain.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.logins[login]
+	if !ok {
+		return domain.User{}, domain.ErrUserNotFound
+	}
+	return cloneUser(s.users[id].User), nil
+}
+
+func (s *Storage) GetUserByID(_ context.Context, userID uuid.UUID) (domain.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return domain.User{}, domain.ErrUserNotFound
+	}
+	return cloneUser(u.User), nil
+}
+
+// LockUser reads the user like GetUserByID. Transactions are serialized, so
+// the user can not change until tx ends.
+func (s *Storage) LockUser(ctx context.Context, userID uuid.UUID, _ *database.Trx) (domain.User, error) {
+	return s.GetUserByID(ctx, userID)
+}
+
+func (s *Storage) InsertUser(_ context.Context, newUser domain.User, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.logins[newUser.Login]; ok {
+		return domain.ErrUserConflict
+	}
+	if _, ok := s.users[newUser.ID]; ok {
+		return domain.ErrUserConflict
+	}
+	put(s, tx, s.users, newUser.ID, user{User: cloneUser(newUser)})
+	put(s, tx, s.logins, newUser.Login, newUser.ID)
+	return nil
+}
+
+func (s *Storage) UpdatePasswordHash(_ context.Context, userID uuid.UUID, passwordHash []byte, tx *database.Trx) error {
+	s.updateUser(userID, tx, func(u *user) {
+		u.PasswordHash = bytes.Clone(passwordHash)
+	})
+	return nil
+}
+
+func (s *Storage) InsertRefreshToken(_ context.Context, rt domain.RefreshToken, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	put(s, tx, s.refreshTokens, rt.ID, cloneRefreshToken(rt))
+	put(s, tx, s.refreshTokenIDs, string(rt.TokenHash), rt.ID)
+	return nil
+}
+
+// GetRefreshToken looks the token up by its hash. Transactions are
+// serialized, so no other transaction can use the token until tx ends.
+func (s *Storage) GetRefreshToken(_ context.Context, tokenHash []byte, _ *database.Trx) (domain.RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.refreshTokenIDs[string(tokenHash)]
+	if !ok {
+		return domain.RefreshToken{}, domain.ErrRefreshTokenNotFound
+	}
+	return cloneRefreshToken(s.refreshTokens[id]), nil
+}
+
+func (s *Storage) MarkRefreshTokenUsed(_ context.Context, id uuid.UUID, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.refreshTokens[id]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	rt.UsedAt = &now
+	put(s, tx, s.refreshTokens, id, rt)
+	return nil
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(_ context.Context, familyID uuid.UUID, tx *database.Trx) error {
+	s.revokeRefreshTokens(tx, func(rt domain.RefreshToken) bool {
+		return rt.FamilyID == familyID
+	})
+	return nil
+}
+
+// RevokeToken adds an access token to the revocation list until it expires.
+// Entries of already expired tokens are dropped on the way.
+func (s *Storage) RevokeToken(_ context.Context, jti string, _ uuid.UUID, expiresAt time.Time, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for revokedJTI, revokedUntil := range s.revokedTokens {
+		if revokedUntil.Before(now) {
+			remove(s, tx, s.revokedTokens, revokedJTI)
+		}
+	}
+	if _, ok := s.revokedTokens[jti]; !ok {
+		put(s, tx, s.revokedTokens, jti, expiresAt)
+	}
+	return nil
+}
+
+// RevokeAllTokens invalidates every access and refresh token of the user
+// issued up to now.
+func (s *Storage) RevokeAllTokens(_ context.Context, userID uuid.UUID, tx *database.Trx) error {
+	s.updateUser(userID, tx, func(u *user) {
+		now := time.Now()
+		u.tokensRevokedAt = &now
+	})
+	s.revokeRefreshTokens(tx, func(rt domain.RefreshToken) bool {
+		return rt.UserID == userID
+	})
+	return nil
+}
+
+func (s *Storage) IsTokenRevoked(_ context.Context, jti string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.revokedTokens[jti]; ok {
+		return true, nil
+	}
+	u, ok := s.users[userID]
+	return ok && u.tokensRevokedAt != nil && !u.tokensRevokedAt.Before(issuedAt), nil
+}
+
+func (s *Storage) SetTOTPSecret(_ context.Context, userID uuid.UUID, secret []byte, tx *database.Trx) error {
+	s.updateUser(userID, tx, func(u *user) {
+		u.TOTPSecret = bytes.Clone(secret)
+		u.TOTPEnabled = false
+	})
+	return nil
+}
+
+func (s *Storage) EnableTOTP(_ context.Context, userID uuid.UUID, step int64, tx *database.Trx) error {
+	s.updateUser(userID, tx, func(u *user) {
+		u.TOTPEnabled = true
+		u.TOTPLastStep = step
+	})
+	return nil
+}
+
+// SetMFAFailures records the number of failed second factor checks of the
+// user and until when further checks are refused.
+func (s *Storage) SetMFAFailures(_ context.Context, userID uuid.UUID, failures int, lockedUntil *time.Time, tx *database.Trx) error {
+	s.updateUser(userID, tx, func(u *user) {
+		u.MFAFailures = failures
+		u.MFALockedUntil = lockedUntil
+	})
+	return nil
+}
+
+// UpdateTOTPLastStep records step as the last one used and reports whether it
+// is newer than the one recorded before.
+func (s *Storage) UpdateTOTPLastStep(_ context.Context, userID uuid.UUID, step int64, tx *database.Trx) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok || u.TOTPLastStep >= step {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	put(s, tx, s.users, userID, u)
+	return true, nil
+}
+
+func (s *Storage) ReplaceRecoveryCodes(_ context.Context, userID uuid.UUID, codeHashes [][]byte, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.recoveryCodes {
+		if key.userID == userID {
+			remove(s, tx, s.recoveryCodes, key)
+		}
+	}
+	for _, codeHash := range codeHashes {
+		put(s, tx, s.recoveryCodes, recoveryCodeKey{userID: userID, codeHash: string(codeHash)}, false)
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether
+// one was found.
+func (s *Storage) UseRecoveryCode(_ context.Context, userID uuid.UUID, codeHash []byte, tx *database.Trx) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := recoveryCodeKey{userID: userID, codeHash: string(codeHash)}
+	used, ok := s.recoveryCodes[key]
+	if !ok || used {
+		return false, nil
+	}
+	put(s, tx, s.recoveryCodes, key, true)
+	return true, nil
+}
+
+func (s *Storage) GetVaultKey(_ context.Context, userID uuid.UUID) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, domain.ErrUserNotFound
+	}
+	if u.vaultKey == nil {
+		return nil, domain.ErrVaultKeyNotFound
+	}
+	return bytes.Clone(u.vaultKey), nil
+}
+
+// SetVaultKey stores the user's wrapped vault key. An existing key is never
+// overwritten.
+func (s *Storage) SetVaultKey(_ context.Context, userID uuid.UUID, wrappedKey []byte, tx *database.Trx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok || u.vaultKey != nil {
+		return domain.ErrVaultKeyConflict
+	}
+	u.vaultKey = bytes.Clone(wrappedKey)
+	put(s, tx, s.users, userID, u)
+	return nil
+}
+
+func (s *Storage) UpdateVaultKey(_ context.Context, userID uuid.UUID, wrappedKey []byte, tx *database.Trx) error {
+	s.updateUser(userID, tx, func(u *user) {
+		u.vaultKey = bytes.Clone(wrappedKey)
+	})
+	return nil
+}
+
+// updateUser applies update to the user, if it exists.
+func (s *Storage) updateUser(userID uuid.UUID, tx *database.Trx, update func(u *user)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return
+	}
+	update(&u)
+	put(s, tx, s.users, userID, u)
+}
+
+func (s *Storage) revokeRefreshTokens(tx *database.Trx, match func(rt domain.RefreshToken) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, rt := range s.refreshTokens {
+		if rt.RevokedAt == nil && match(rt) {
+			rt.RevokedAt = &now
+			put(s, tx, s.refreshTokens, id, rt)
+		}
+	}
+}
+
+// put stores value under key and registers undoing it with tx. It must be
+// called with s.mu held.
+func put[K comparable, V any](s *Storage, tx *database.Trx, m map[K]V, key K, value V) {
+	old, existed := m[key]
+	m[key] = value
+	tx.OnRollback(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if existed {
+			m[key] = old
+		} else {
+			delete(m, key)
+		}
+	})
+}
+
+// remove deletes key and registers undoing it with tx. It must be called with
+// s.mu held.
+func remove[K comparable, V any](s *Storage, tx *database.Trx, m map[K]V, key K) {
+	old, existed := m[key]
+	if !existed {
+		return
+	}
+	delete(m, key)
+	tx.OnRollback(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		m[key] = old
+	})
+}
+
+func cloneUser(u domain.User) domain.User {
+	u.PasswordHash = bytes.Clone(u.PasswordHash)
+	u.TOTPSecret = bytes.Clone(u.TOTPSecret)
+	return u
+}
+
+func cloneRefreshToken(rt domain.RefreshToken) domain.RefreshToken {
+	rt.TokenHash = bytes.Clone(rt.TokenHash)
+	return rt
+}
@@ -0,0 +1,308 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"gokeeper/internal/server/adapters/storage/database"
+	"gokeeper/pkg/domain"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func beginTx(t *testing.T, s *Storage) *database.Trx {
+	t.Helper()
+	tx, err := s.BeginTx(context.Background())
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	return tx
+}
+
+func insertUser(t *testing.T, s *Storage, login string) uuid.UUID {
+	t.Helper()
+	u := domain.User{ID: uuid.New(), Login: login, PasswordHash: []byte("hash")}
+	tx := beginTx(t, s)
+	if err := s.InsertUser(context.Background(), u, tx); err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	return u.ID
+}
+
+func TestRollbackUndoesChanges(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	alice := insertUser(t, s, "alice")
+
+	tx := beginTx(t, s)
+	if err := s.InsertUser(ctx, domain.User{ID: uuid.New(), Login: "bob"}, tx); err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	if err := s.UpdatePasswordHash(ctx, alice, []byte("other"), tx); err != nil {
+		t.Fatalf("UpdatePasswordHash: %v", err)
+	}
+	revision, err := s.NextRevision(ctx, alice, tx)
+	if err != nil {
+		t.Fatalf("NextRevision: %v", err)
+	}
+	pd := &domain.Data{ID: "note", Data: []byte("secret"), Version: 1, Revision: revision}
+	if err = s.InsertOrUpdate(ctx, pd, alice, tx); err != nil {
+		t.Fatalf("InsertOrUpdate: %v", err)
+	}
+	if err = s.InsertVersion(ctx, pd, alice, tx); err != nil {
+		t.Fatalf("InsertVersion: %v", err)
+	}
+	rt := domain.RefreshToken{ID: uuid.New(), UserID: alice, TokenHash: []byte("token")}
+	if err = s.InsertRefreshToken(ctx, rt, tx); err != nil {
+		t.Fatalf("InsertRefreshToken: %v", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	if _, err = s.GetUser(ctx, "bob"); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("GetUser of a rolled back user = %v, want %v", err, domain.ErrUserNotFound)
+	}
+	if u, _ := s.GetUserByID(ctx, alice); string(u.PasswordHash) != "hash" {
+		t.Errorf("password hash after rollback = %q", u.PasswordHash)
+	}
+	if current, _, _ := s.GetRevisions(ctx, alice, nil); current != 0 {
+		t.Errorf("revision after rollback = %d, want 0", current)
+	}
+	if _, err = s.GetByID(ctx, "note", alice, nil); !errors.Is(err, domain.ErrPrivateDataNotFound) {
+		t.Errorf("GetByID of a rolled back record = %v, want %v", err, domain.ErrPrivateDataNotFound)
+	}
+	if _, err = s.GetVersion(ctx, "note", alice, 1, nil); !errors.Is(err, domain.ErrVersionNotFound) {
+		t.Errorf("GetVersion of a rolled back version = %v, want %v", err, domain.ErrVersionNotFound)
+	}
+	if _, err = s.GetRefreshToken(ctx, []byte("token"), nil); !errors.Is(err, domain.ErrRefreshTokenNotFound) {
+		t.Errorf("GetRefreshToken of a rolled back token = %v, want %v", err, domain.ErrRefreshTokenNotFound)
+	}
+}
+
+func TestTransactionsAreSerialized(t *testing.T) {
+	s := NewStorage()
+	tx := beginTx(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.BeginTx(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("BeginTx during another transaction = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	// Ending a transaction twice releases it only once.
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback after Commit: %v", err)
+	}
+	next := beginTx(t, s)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.BeginTx(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("BeginTx during the next transaction = %v, want %v", err, context.DeadlineExceeded)
+	}
+	next.Rollback()
+}
+
+func TestInsertUserConflict(t *testing.T) {
+	s := NewStorage()
+	insertUser(t, s, "alice")
+
+	tx := beginTx(t, s)
+	defer tx.Rollback()
+	err := s.InsertUser(context.Background(), domain.User{ID: uuid.New(), Login: "alice"}, tx)
+	if !errors.Is(err, domain.ErrUserConflict) {
+		t.Fatalf("InsertUser with a taken login = %v, want %v", err, domain.ErrUserConflict)
+	}
+}
+
+func TestTrashAndChanges(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	alice := insertUser(t, s, "alice")
+	bob := insertUser(t, s, "bob")
+
+	tx := beginTx(t, s)
+	for _, id := range []string{"a", "b", "c"} {
+		revision, err := s.NextRevision(ctx, alice, tx)
+		if err != nil {
+			t.Fatalf("NextRevision: %v", err)
+		}
+		pd := &domain.Data{ID: id, Data: []byte("secret " + id), Revision: revision}
+		if err = s.InsertOrUpdate(ctx, pd, alice, tx); err != nil {
+			t.Fatalf("InsertOrUpdate: %v", err)
+		}
+	}
+	if err := s.InsertOrUpdate(ctx, &domain.Data{ID: "a", Revision: 1}, bob, tx); err != nil {
+		t.Fatalf("InsertOrUpdate: %v", err)
+	}
+	deletedAt := time.Now()
+	if err := s.MarkDeleted(ctx, "a", alice, deletedAt, 4, tx); err != nil {
+		t.Fatalf("MarkDeleted: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	all, err := s.GetAll(ctx, &domain.GetAllRequest{Limit: 10}, alice)
+	if err != nil || len(all) != 2 {
+		t.Fatalf("GetAll = %d records, %v, want 2", len(all), err)
+	}
+	deleted, err := s.GetDeleted(ctx, alice)
+	if err != nil || len(deleted) != 1 || deleted[0].ID != "a" || deleted[0].Data != nil {
+		t.Fatalf("GetDeleted = %+v, %v", deleted, err)
+	}
+
+	changes, err := s.GetChanges(ctx, alice, &domain.ChangesRequest{Since: 1, Limit: 10}, nil)
+	if err != nil {
+		t.Fatalf("GetChanges: %v", err)
+	}
+	var ids string
+	for _, pd := range changes {
+		ids += pd.ID
+	}
+	if ids != "bca" || changes[2].DeletedAt == nil {
+		t.Fatalf("GetChanges since 1 = %s, want bca ending with the tombstone", ids)
+	}
+	if changes, _ = s.GetChanges(ctx, alice, &domain.ChangesRequest{Since: 0, Limit: 1}, nil); len(changes) != 1 {
+		t.Fatalf("GetChanges with limit 1 = %d records", len(changes))
+	}
+
+	tombstones, err := s.GetTombstones(ctx, deletedAt.Add(time.Second))
+	if err != nil || len(tombstones) != 1 || tombstones[0] != (domain.RecordKey{UserID: alice, ID: "a"}) {
+		t.Fatalf("GetTombstones = %v, %v", tombstones, err)
+	}
+	if tombstones, _ = s.GetTombstones(ctx, deletedAt); len(tombstones) != 0 {
+		t.Fatalf("GetTombstones before the deletion = %v", tombstones)
+	}
+
+	// Saving the record again takes it out of the trash.
+	tx = beginTx(t, s)
+	if err = s.InsertOrUpdate(ctx, &domain.Data{ID: "a", Revision: 5}, alice, tx); err != nil {
+		t.Fatalf("InsertOrUpdate: %v", err)
+	}
+	tx.Commit()
+	if deleted, _ = s.GetDeleted(ctx, alice); len(deleted) != 0 {
+		t.Fatalf("GetDeleted after saving the record = %+v", deleted)
+	}
+}
+
+func TestRevokeTokens(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	alice := insertUser(t, s, "alice")
+	family := uuid.New()
+
+	tx := beginTx(t, s)
+	for _, hash := range []string{"first", "second"} {
+		rt := domain.RefreshToken{ID: uuid.New(), UserID: alice, FamilyID: family, TokenHash: []byte(hash)}
+		if err := s.InsertRefreshToken(ctx, rt, tx); err != nil {
+			t.Fatalf("InsertRefreshToken: %v", err)
+		}
+	}
+	other := domain.RefreshToken{ID: uuid.New(), UserID: alice, FamilyID: uuid.New(), TokenHash: []byte("other")}
+	if err := s.InsertRefreshToken(ctx, other, tx); err != nil {
+		t.Fatalf("InsertRefreshToken: %v", err)
+	}
+	if err := s.RevokeRefreshTokenFamily(ctx, family, tx); err != nil {
+		t.Fatalf("RevokeRefreshTokenFamily: %v", err)
+	}
+	if err := s.RevokeToken(ctx, "jti", alice, time.Now().Add(time.Hour), tx); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	tx.Commit()
+
+	for hash, wantRevoked := range map[string]bool{"first": true, "second": true, "other": false} {
+		rt, err := s.GetRefreshToken(ctx, []byte(hash), nil)
+		if err != nil {
+			t.Fatalf("GetRefreshToken: %v", err)
+		}
+		if (rt.RevokedAt != nil) != wantRevoked {
+			t.Errorf("token %s revoked = %v, want %v", hash, rt.RevokedAt != nil, wantRevoked)
+		}
+	}
+	if revoked, _ := s.IsTokenRevoked(ctx, "jti", alice, time.Now()); !revoked {
+		t.Errorf("IsTokenRevoked of a revoked token = false")
+	}
+
+	issuedAt := time.Now().Add(-time.Minute)
+	tx = beginTx(t, s)
+	if err := s.RevokeAllTokens(ctx, alice, tx); err != nil {
+		t.Fatalf("RevokeAllTokens: %v", err)
+	}
+	tx.Commit()
+	if revoked, _ := s.IsTokenRevoked(ctx, "earlier", alice, issuedAt); !revoked {
+		t.Errorf("IsTokenRevoked of a token issued before RevokeAllTokens = false")
+	}
+	if revoked, _ := s.IsTokenRevoked(ctx, "later", alice, time.Now().Add(time.Minute)); revoked {
+		t.Errorf("IsTokenRevoked of a token issued after RevokeAllTokens = true")
+	}
+	if rt, _ := s.GetRefreshToken(ctx, []byte("other"), nil); rt.RevokedAt == nil {
+		t.Errorf("RevokeAllTokens kept a refresh token")
+	}
+}
+
+func TestStaleBlobs(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	alice := insertUser(t, s, "alice")
+	pending, unreferenced, referenced := uuid.New(), uuid.New(), uuid.New()
+
+	tx := beginTx(t, s)
+	for _, id := range []uuid.UUID{pending, unreferenced, referenced} {
+		if err := s.InsertBlob(ctx, domain.Blob{ID: id, UserID: alice, Size: 10, ChunkSize: 10}, tx); err != nil {
+			t.Fatalf("InsertBlob: %v", err)
+		}
+	}
+	s.CompleteBlob(ctx, unreferenced, []byte("sum"), tx)
+	s.CompleteBlob(ctx, referenced, []byte("sum"), tx)
+	s.InsertOrUpdate(ctx, &domain.Data{ID: "file", BlobID: referenced.String()}, alice, tx)
+	tx.Commit()
+
+	if size, _ := s.GetBlobsSize(ctx, alice, nil); size != 30 {
+		t.Errorf("GetBlobsSize = %d, want 30", size)
+	}
+	stale, err := s.GetStaleBlobs(ctx, time.Now().Add(time.Second))
+	if err != nil || len(stale) != 2 {
+		t.Fatalf("GetStaleBlobs = %v, %v, want 2 blobs", stale, err)
+	}
+	for _, id := range stale {
+		if id == referenced {
+			t.Errorf("GetStaleBlobs returned a referenced blob")
+		}
+	}
+	if stale, _ = s.GetStaleBlobs(ctx, time.Now().Add(-time.Minute)); len(stale) != 0 {
+		t.Errorf("GetStaleBlobs returned new blobs: %v", stale)
+	}
+}
+
+func TestReadsReturnCopies(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	alice := insertUser(t, s, "alice")
+
+	tx := beginTx(t, s)
+	s.InsertOrUpdate(ctx, &domain.Data{ID: "note", Data: []byte("secret")}, alice, tx)
+	tx.Commit()
+
+	pd, err := s.GetByID(ctx, "note", alice, nil)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	pd.Data[0] = 'S'
+	u, _ := s.GetUserByID(ctx, alice)
+	u.PasswordHash[0] = 'H'
+
+	if pd, _ = s.GetByID(ctx, "note", alice, nil); string(pd.Data) != "secret" {
+		t.Errorf("changing a read record changed the stored one to %q", pd.Data)
+	}
+	if u, _ = s.GetUserByID(ctx, alice); string(u.PasswordHash) != "hash" {
+		t.Errorf("changing a read user changed the stored one to %q", u.PasswordHash)
+	}
+}
@@ -2,32 +2,134 @@ package storage
 
 import (
 	"context"
+	"gokeeper/internal/server/adapters/storage/blobstore"
+	"gokeeper/internal/server/adapters/storage/chunks"
 	"gokeeper/internal/server/adapters/storage/database"
 	"gokeeper/internal/server/adapters/storage/database/postgresql"
+	"gokeeper/internal/server/adapters/storage/database/sqlite"
+	"gokeeper/internal/server/adapters/storage/memory"
 	domain2 "gokeeper/pkg/domain"
+	"io"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	memoryScheme = "memory://"
+	sqliteScheme = "sqlite://"
+)
+
 type AuthStorage interface {
 	GetUser(ctx context.Context, login string) (domain2.User, error)
+	GetUserByID(ctx context.Context, userID uuid.UUID) (domain2.User, error)
+	LockUser(ctx context.Context, userID uuid.UUID, tx *database.Trx) (domain2.User, error)
 	InsertUser(ctx context.Context, newUser domain2.User, tx *database.Trx) error
+	UpdatePasswordHash(ctx context.Context, userID uuid.UUID, passwordHash []byte, tx *database.Trx) error
+	InsertRefreshToken(ctx context.Context, rt domain2.RefreshToken, tx *database.Trx) error
+	GetRefreshToken(ctx context.Context, tokenHash []byte, tx *database.Trx) (domain2.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, id uuid.UUID, tx *database.Trx) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID, tx *database.Trx) error
+	RevokeToken(ctx context.Context, jti string, userID uuid.UUID, expiresAt time.Time, tx *database.Trx) error
+	RevokeAllTokens(ctx context.Context, userID uuid.UUID, tx *database.Trx) error
+	IsTokenRevoked(ctx context.Context, jti string, userID uuid.UUID, issuedAt time.Time) (bool, error)
+	SetTOTPSecret(ctx context.Context, userID uuid.UUID, secret []byte, tx *database.Trx) error
+	EnableTOTP(ctx context.Context, userID uuid.UUID, step int64, tx *database.Trx) error
+	SetMFAFailures(ctx context.Context, userID uuid.UUID, failures int, lockedUntil *time.Time, tx *database.Trx) error
+	UpdateTOTPLastStep(ctx context.Context, userID uuid.UUID, step int64, tx *database.Trx) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes [][]byte, tx *database.Trx) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash []byte, tx *database.Trx) (bool, error)
+	GetVaultKey(ctx context.Context, userID uuid.UUID) ([]byte, error)
+	SetVaultKey(ctx context.Context, userID uuid.UUID, wrappedKey []byte, tx *database.Trx) error
+	UpdateVaultKey(ctx context.Context, userID uuid.UUID, wrappedKey []byte, tx *database.Trx) error
 	BeginTx(ctx context.Context) (*database.Trx, error)
 }
 
 type PrivateStorage interface {
+	LockUser(ctx context.Context, userID uuid.UUID, tx *database.Trx) (domain2.User, error)
 	GetByID(ctx context.Context, id string, userID uuid.UUID, tx *database.Trx) (*domain2.Data, error)
 	InsertOrUpdate(ctx context.Context, pd *domain2.Data, userID uuid.UUID, tx *database.Trx) error
 	Delete(ctx context.Context, id string, userID uuid.UUID, tx *database.Trx) error
+	MarkDeleted(ctx context.Context, id string, userID uuid.UUID, deletedAt time.Time, revision int64, tx *database.Trx) error
+	GetDeleted(ctx context.Context, userID uuid.UUID) ([]domain2.Data, error)
+	GetTombstones(ctx context.Context, deletedBefore time.Time) ([]domain2.RecordKey, error)
 	GetAll(ctx context.Context, req *domain2.GetAllRequest, userID uuid.UUID) ([]domain2.Data, error)
+	CountAll(ctx context.Context, req *domain2.GetAllRequest, userID uuid.UUID) (int64, error)
+	LockPayload(ctx context.Context, key string, tx *database.Trx) error
+	IsPayloadReferenced(ctx context.Context, key string, tx *database.Trx) (bool, error)
+	IsBlobReferenced(ctx context.Context, blobID string, tx *database.Trx) (bool, error)
+	InsertVersion(ctx context.Context, pd *domain2.Data, userID uuid.UUID, tx *database.Trx) error
+	GetVersions(ctx context.Context, id string, userID uuid.UUID, tx *database.Trx) ([]domain2.Data, error)
+	GetVersion(ctx context.Context, id string, userID uuid.UUID, version int64, tx *database.Trx) (*domain2.Data, error)
+	DeleteVersion(ctx context.Context, id string, userID uuid.UUID, version int64, tx *database.Trx) error
+	NextRevision(ctx context.Context, userID uuid.UUID, tx *database.Trx) (int64, error)
+	GetRevisions(ctx context.Context, userID uuid.UUID, tx *database.Trx) (int64, int64, error)
+	SetPurgedRevision(ctx context.Context, userID uuid.UUID, revision int64, tx *database.Trx) error
+	GetChanges(ctx context.Context, userID uuid.UUID, req *domain2.ChangesRequest, tx *database.Trx) ([]domain2.Data, error)
+	BeginTx(ctx context.Context) (*database.Trx, error)
+}
+
+type BlobStorage interface {
+	LockUser(ctx context.Context, userID uuid.UUID, tx *database.Trx) (domain2.User, error)
+	InsertBlob(ctx context.Context, blob domain2.Blob, tx *database.Trx) error
+	GetBlob(ctx context.Context, id uuid.UUID, userID uuid.UUID) (domain2.Blob, error)
+	LockBlob(ctx context.Context, id uuid.UUID, tx *database.Trx) (domain2.Blob, error)
+	GetBlobsSize(ctx context.Context, userID uuid.UUID, tx *database.Trx) (int64, error)
+	GetStaleBlobs(ctx context.Context, createdBefore time.Time) ([]uuid.UUID, error)
+	IsBlobReferenced(ctx context.Context, blobID string, tx *database.Trx) (bool, error)
+	InsertBlobChunk(ctx context.Context, blobID uuid.UUID, index int, tx *database.Trx) error
+	GetBlobChunks(ctx context.Context, blobID uuid.UUID) ([]int, error)
+	CompleteBlob(ctx context.Context, blobID uuid.UUID, checksum []byte, tx *database.Trx) error
+	DeleteBlob(ctx context.Context, blobID uuid.UUID, tx *database.Trx) error
 	BeginTx(ctx context.Context) (*database.Trx, error)
 }
 
 type Storage interface {
 	AuthStorage
 	PrivateStorage
+	BlobStorage
+}
+
+// ChunkStore keeps the content of blob chunks, while BlobStorage keeps track
+// of which chunks were received.
+type ChunkStore interface {
+	StageChunk(ctx context.Context, blobID uuid.UUID, index int, r io.Reader) (*chunks.StagedChunk, error)
+	OpenChunk(ctx context.Context, blobID uuid.UUID, index int, offset int64) (io.ReadCloser, error)
+	DeleteChunks(ctx context.Context, blobID uuid.UUID) error
 }
 
+// BlobStore keeps record payloads that are too large to be stored in the
+// database. Payloads are content addressed, so storing the same content twice
+// returns the same key.
+type BlobStore interface {
+	Put(ctx context.Context, data []byte) (string, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStorage opens the storage the DSN refers to. The DSN memory:// selects
+// an in-memory storage and sqlite://<path> a SQLite database file; anything
+// else is treated as a PostgreSQL DSN.
 func NewStorage(dsn string) (Storage, error) {
-	return postgresql.NewStorage(dsn)
+	switch {
+	case strings.HasPrefix(dsn, memoryScheme):
+		return memory.NewStorage(), nil
+	case strings.HasPrefix(dsn, sqliteScheme):
+		return sqlite.NewStorage(dsn)
+	default:
+		return postgresql.NewStorage(dsn)
+	}
+}
+
+func NewChunkStore(dir string) (ChunkStore, error) {
+	return chunks.NewLocalStore(dir)
+}
+
+func NewLocalBlobStore(dir string) (BlobStore, error) {
+	return blobstore.NewLocalStore(dir)
+}
+
+func NewS3BlobStore(cfg blobstore.S3Config) (BlobStore, error) {
+	return blobstore.NewS3Store(cfg)
 }
@@ -1,9 +1,11 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"gokeeper/internal/server/adapters/api"
 	"gokeeper/internal/server/adapters/storage"
+	"gokeeper/internal/server/adapters/storage/blobstore"
 	"gokeeper/internal/server/core/config"
 	"gokeeper/internal/server/core/service"
 	"gokeeper/pkg/auth"
@@ -11,6 +13,7 @@ import (
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
 )
 
 type Server struct {
@@ -28,15 +31,61 @@ func NewServer() (*Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
-	authenticator := auth.NewAuthJWT(cfg.JWTSecretKey, cfg.TokenExp)
-	services := service.NewServices(newStorage, *authenticator)
+	chunkStore, err := storage.NewChunkStore(cfg.BlobDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize chunk store: %w", err)
+	}
+	payloadStore, err := newPayloadStore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize payload store: %w", err)
+	}
+	authenticator := auth.NewAuthJWT(cfg.JWTSecretKey, cfg.TokenExp, cfg.RefreshExp)
+	services, err := service.NewServices(newStorage, chunkStore, payloadStore, *authenticator, service.PasswordHashParams{
+		Memory:  cfg.PasswordHashMemory,
+		Time:    cfg.PasswordHashTime,
+		Threads: cfg.PasswordHashThreads,
+	}, service.BlobPolicy{
+		MaxSize:   cfg.BlobMaxSize,
+		Quota:     cfg.BlobQuota,
+		UploadTTL: cfg.BlobUploadTTL,
+	}, cfg.PayloadOffloadThreshold, service.RetentionPolicy{
+		MaxVersions: cfg.VersionRetention,
+		MaxAge:      cfg.VersionMaxAge,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize services: %w", err)
+	}
 	return &Server{
-		cfg: cfg,
-		api: api.NewAPI(services, cfg, authenticator),
+		cfg:      cfg,
+		api:      api.NewAPI(services, cfg, authenticator),
+		services: services,
 	}, nil
 }
 
+func newPayloadStore(cfg *config.Config) (storage.BlobStore, error) {
+	switch cfg.PayloadStore {
+	case "local":
+		return storage.NewLocalBlobStore(cfg.PayloadDir)
+	case "s3":
+		return storage.NewS3BlobStore(blobstore.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			Prefix:    cfg.S3Prefix,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown payload store %q", cfg.PayloadStore)
+	}
+}
+
 func (s *Server) Run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.services.RunPurge(ctx, s.cfg.PurgeInterval, s.cfg.TrashRetention)
+	go s.services.RunBlobSweep(ctx, s.cfg.PurgeInterval)
+
 	if err := s.api.Run(); err != nil {
 		logger.Log.Error("error while running server", zap.Error(err))
 		return
@@ -12,6 +12,43 @@ type Config struct {
 	LogLevel     string        `env:"LOG_LEVEL"`
 	JWTSecretKey string        `env:"SECRET_KEY"`
 	TokenExp     time.Duration `env:"TOKEN_EXP"`
+	RefreshExp   time.Duration `env:"REFRESH_TOKEN_EXP"`
+
+	PasswordHashMemory  uint32 `env:"PASSWORD_HASH_MEMORY"`
+	PasswordHashTime    uint32 `env:"PASSWORD_HASH_TIME"`
+	PasswordHashThreads uint8  `env:"PASSWORD_HASH_THREADS"`
+
+	BlobDir     string `env:"BLOB_DIR"`
+	BlobMaxSize int64  `env:"BLOB_MAX_SIZE"`
+	// BlobQuota limits the total size of the blobs of a user, 0 disables it.
+	// Uploads left incomplete, or completed but never referenced, for
+	// BlobUploadTTL are swept every PurgeInterval.
+	BlobQuota     int64         `env:"BLOB_QUOTA"`
+	BlobUploadTTL time.Duration `env:"BLOB_UPLOAD_TTL"`
+
+	// PayloadStore selects where payloads larger than
+	// PayloadOffloadThreshold are kept: "local" or "s3".
+	PayloadStore            string `env:"PAYLOAD_STORE"`
+	PayloadDir              string `env:"PAYLOAD_DIR"`
+	PayloadOffloadThreshold int    `env:"PAYLOAD_OFFLOAD_THRESHOLD"`
+
+	// VersionRetention is the number of versions kept of each record, 0
+	// keeps all. Versions older than VersionMaxAge are dropped as well,
+	// unless it is 0.
+	VersionRetention int           `env:"VERSION_RETENTION"`
+	VersionMaxAge    time.Duration `env:"VERSION_MAX_AGE"`
+
+	// Deleted records stay in the trash for TrashRetention before they are
+	// purged. The purge runs every PurgeInterval.
+	TrashRetention time.Duration `env:"TRASH_RETENTION"`
+	PurgeInterval  time.Duration `env:"PURGE_INTERVAL"`
+
+	S3Endpoint  string `env:"S3_ENDPOINT"`
+	S3Region    string `env:"S3_REGION"`
+	S3Bucket    string `env:"S3_BUCKET"`
+	S3Prefix    string `env:"S3_PREFIX"`
+	S3AccessKey string `env:"S3_ACCESS_KEY"`
+	S3SecretKey string `env:"S3_SECRET_KEY"`
 }
 
 func NewConfig() *Config {
@@ -23,7 +60,28 @@ func NewConfig() *Config {
 		LogLevel:     "info",
 		Address:      ":8080",
 		JWTSecretKey: hex.EncodeToString(defaultSecretKey),
-		TokenExp:     time.Hour * 24,
+		TokenExp:     time.Minute * 15,
+		RefreshExp:   time.Hour * 24 * 30,
+
+		PasswordHashMemory:  64 * 1024,
+		PasswordHashTime:    1,
+		PasswordHashThreads: 4,
+
+		BlobDir:       "./blobs",
+		BlobMaxSize:   8 * 1024 * 1024 * 1024,
+		BlobQuota:     32 * 1024 * 1024 * 1024,
+		BlobUploadTTL: time.Hour * 24,
+
+		PayloadStore:            "local",
+		PayloadDir:              "./payloads",
+		PayloadOffloadThreshold: 64 * 1024,
+
+		VersionRetention: 10,
+
+		TrashRetention: time.Hour * 24 * 30,
+		PurgeInterval:  time.Hour,
+
+		S3Region: "us-east-1",
 	}
 	return cfg
 }
@@ -1,101 +1,325 @@
 package service
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
 	"errors"
 	"fmt"
 	"gokeeper/internal/server/adapters/storage"
+	"gokeeper/internal/server/adapters/storage/database"
 	"gokeeper/pkg/auth"
 	domain2 "gokeeper/pkg/domain"
+	"gokeeper/pkg/logger"
+	"time"
 
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
 type AuthService struct {
-	authStorage   storage.AuthStorage
-	authenticator auth.Authenticator
+	authStorage    storage.AuthStorage
+	authenticator  auth.Authenticator
+	passwordHasher *passwordHasher
 }
 
-func NewAuthService(authStorage storage.AuthStorage, authenticator auth.Authenticator) *AuthService {
-	return &AuthService{
-		authStorage:   authStorage,
-		authenticator: authenticator,
-	}
-}
-
-func (as *AuthService) Register(ctx context.Context, inUser domain2.InUserRequest) (auth.Token, error) {
-	tx, err := as.authStorage.BeginTx(ctx)
-
+func NewAuthService(
+	authStorage storage.AuthStorage,
+	authenticator auth.Authenticator,
+	hashParams PasswordHashParams,
+) (*AuthService, error) {
+	passwordHasher, err := newPasswordHasher(hashParams)
 	if err != nil {
-		return "", fmt.Errorf("failed to begin transaction: %v", err)
+		return nil, fmt.Errorf("invalid password hash parameters: %w", err)
 	}
+	return &AuthService{
+		authStorage:    authStorage,
+		authenticator:  authenticator,
+		passwordHasher: passwordHasher,
+	}, nil
+}
 
+func (as *AuthService) Register(ctx context.Context, inUser domain2.InUserRequest) (auth.TokenPair, error) {
 	if registeredUser, err := as.authStorage.GetUser(ctx, inUser.Login); err == nil {
 		if registeredUser.Login != "" {
-			return "", domain2.ErrUserConflict
+			return auth.TokenPair{}, domain2.ErrUserConflict
 		}
 	}
+	passwordHash, err := as.passwordHasher.Hash(inUser.Password)
+	if err != nil {
+		return auth.TokenPair{}, fmt.Errorf("failed to hash password: %w", err)
+	}
 	newUser := domain2.User{
 		Login:        inUser.Login,
-		PasswordHash: generatePasswordHash(inUser.Password),
+		PasswordHash: passwordHash,
 		ID:           uuid.New(),
 	}
-	err = as.authStorage.InsertUser(ctx, newUser, tx)
+
+	tx, err := as.authStorage.BeginTx(ctx)
 	if err != nil {
-		err = tx.Rollback()
-		if err != nil {
-			return "", fmt.Errorf("failed to rollback transaction: %w", err)
-		}
-		return "", fmt.Errorf("failed to insert user: %w", err)
+		return auth.TokenPair{}, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	if err = as.authStorage.InsertUser(ctx, newUser, tx); err != nil {
+		rollback(tx)
+		return auth.TokenPair{}, err
+	}
+
+	tokens, err := as.issueTokens(ctx, newUser, uuid.New(), tx)
+	if err != nil {
+		rollback(tx)
+		return auth.TokenPair{}, err
 	}
 	if err = tx.Commit(); err != nil {
-		return "", fmt.Errorf("failed to commit transaction: %w", err)
+		return auth.TokenPair{}, fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	return tokens, nil
+}
 
-	token, err := as.authenticator.MakeJWT(newUser.ID, newUser.Login)
+func (as *AuthService) Login(ctx context.Context, inUser domain2.InUserRequest) (auth.TokenPair, error) {
+	userInDB, err := as.authStorage.GetUser(ctx, inUser.Login)
 	if err != nil {
-		return "", errors.New("failed to generate token, %")
+		if errors.Is(err, domain2.ErrUserNotFound) {
+			return auth.TokenPair{}, domain2.ErrUserAuthentication
+		}
+		return auth.TokenPair{}, err
+	}
+
+	if !as.passwordHasher.Check(userInDB.PasswordHash, inUser.Password) {
+		return auth.TokenPair{}, domain2.ErrUserAuthentication
+	}
+	if as.passwordHasher.NeedsRehash(userInDB.PasswordHash) {
+		as.rehashPassword(ctx, userInDB, inUser.Password)
 	}
-	return token, nil
+	if userInDB.TOTPEnabled {
+		mfaToken, err := as.authenticator.MakeMFAToken(userInDB.ID, userInDB.Login)
+		if err != nil {
+			return auth.TokenPair{}, fmt.Errorf("failed to generate mfa token: %w", err)
+		}
+		return auth.TokenPair{MFAToken: mfaToken}, domain2.ErrMFARequired
+	}
+
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		return auth.TokenPair{}, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	tokens, err := as.issueTokens(ctx, userInDB, uuid.New(), tx)
+	if err != nil {
+		rollback(tx)
+		return auth.TokenPair{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return auth.TokenPair{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return tokens, nil
 }
 
-func (as *AuthService) Login(ctx context.Context, inUser domain2.InUserRequest) (auth.Token, error) {
+// Refresh exchanges a refresh token for a new token pair. Every refresh token
+// is single use: presenting one that was already rotated revokes the whole
+// token family, since either the client or an attacker holds a stolen copy.
+func (as *AuthService) Refresh(ctx context.Context, refreshToken string) (auth.TokenPair, error) {
 	tx, err := as.authStorage.BeginTx(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to begin transaction: %v", err)
+		return auth.TokenPair{}, fmt.Errorf("failed to begin transaction: %v", err)
 	}
 
-	userInDB, err := as.authStorage.GetUser(ctx, inUser.Login)
+	rt, err := as.authStorage.GetRefreshToken(ctx, auth.HashRefreshToken(refreshToken), tx)
 	if err != nil {
+		rollback(tx)
+		if errors.Is(err, domain2.ErrRefreshTokenNotFound) {
+			return auth.TokenPair{}, domain2.ErrUserAuthentication
+		}
+		return auth.TokenPair{}, err
+	}
+
+	if rt.UsedAt != nil || rt.RevokedAt != nil {
+		if rt.RevokedAt == nil {
+			logger.Log.Warn("refresh token reuse detected, revoking token family",
+				zap.String("user_id", rt.UserID.String()),
+				zap.String("family_id", rt.FamilyID.String()),
+			)
+		}
+		if err = as.authStorage.RevokeRefreshTokenFamily(ctx, rt.FamilyID, tx); err != nil {
+			rollback(tx)
+			return auth.TokenPair{}, err
+		}
+		if err = tx.Commit(); err != nil {
+			return auth.TokenPair{}, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return auth.TokenPair{}, domain2.ErrUserAuthentication
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		rollback(tx)
+		return auth.TokenPair{}, domain2.ErrUserAuthentication
+	}
+
+	user, err := as.authStorage.GetUserByID(ctx, rt.UserID)
+	if err != nil {
+		rollback(tx)
 		if errors.Is(err, domain2.ErrUserNotFound) {
-			return "", domain2.ErrUserAuthentication
+			return auth.TokenPair{}, domain2.ErrUserAuthentication
 		}
-		return "", err
+		return auth.TokenPair{}, err
+	}
+	if err = as.authStorage.MarkRefreshTokenUsed(ctx, rt.ID, tx); err != nil {
+		rollback(tx)
+		return auth.TokenPair{}, err
 	}
+	tokens, err := as.issueTokens(ctx, user, rt.FamilyID, tx)
+	if err != nil {
+		rollback(tx)
+		return auth.TokenPair{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return auth.TokenPair{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return tokens, nil
+}
+
+// ChangePassword replaces the password hash and, if given, the re-wrapped
+// vault key of the user in one transaction after verifying the old password.
+// Every session of the user is revoked in the same transaction, so tokens
+// obtained with the old password stop working.
+func (as *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, req domain2.ChangePasswordRequest) error {
+	if req.NewPassword == "" || len(req.VaultKey) > maxVaultKeySize {
+		return domain2.ErrPrivateDataBadFormat
+	}
+
+	newHash, err := as.passwordHasher.Hash(req.NewPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// The old password is checked against the locked row, so that two
+	// concurrent changes can not both succeed with the same old password.
+	user, err := as.authStorage.LockUser(ctx, userID, tx)
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+	if !as.passwordHasher.Check(user.PasswordHash, req.OldPassword) {
+		rollback(tx)
+		return domain2.ErrWrongPassword
+	}
+	if err = as.authStorage.UpdatePasswordHash(ctx, userID, newHash, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if len(req.VaultKey) != 0 {
+		if err = as.authStorage.UpdateVaultKey(ctx, userID, req.VaultKey, tx); err != nil {
+			rollback(tx)
+			return err
+		}
+	}
+	if err = as.authStorage.RevokeAllTokens(ctx, userID, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Logout revokes the access token tokenID and the refresh token family of the
+// session it belongs to.
+func (as *AuthService) Logout(ctx context.Context, userID uuid.UUID, tokenID string, sessionID uuid.UUID) error {
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	expiresAt := time.Now().Add(as.authenticator.TokenExp())
+	if err = as.authStorage.RevokeToken(ctx, tokenID, userID, expiresAt, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = as.authStorage.RevokeRefreshTokenFamily(ctx, sessionID, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
 
-	if !checkPassword(userInDB.PasswordHash, inUser.Password) {
-		return "", domain2.ErrUserAuthentication
+// LogoutAll revokes every session of the user.
+func (as *AuthService) LogoutAll(ctx context.Context, userID uuid.UUID) error {
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = as.authStorage.RevokeAllTokens(ctx, userID, tx); err != nil {
+		rollback(tx)
+		return err
 	}
 	if err = tx.Commit(); err != nil {
-		return "", fmt.Errorf("failed to commit transaction: %w", err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// IsTokenRevoked reports whether the access token was revoked by Logout or
+// LogoutAll. Issue time has second precision, so tokens issued in the same
+// second as a LogoutAll are treated as revoked too.
+func (as *AuthService) IsTokenRevoked(ctx context.Context, claims *auth.Claims) (bool, error) {
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
 	}
+	return as.authStorage.IsTokenRevoked(ctx, claims.ID, claims.UserID, issuedAt)
+}
 
-	token, err := as.authenticator.MakeJWT(userInDB.ID, userInDB.Login)
+// issueTokens makes an access token and stores a new refresh token of the
+// given family within tx.
+func (as *AuthService) issueTokens(ctx context.Context, user domain2.User, familyID uuid.UUID, tx *database.Trx) (auth.TokenPair, error) {
+	accessToken, err := as.authenticator.MakeJWT(user.ID, user.Login, familyID)
 	if err != nil {
-		return "", errors.New("failed to generate token, %")
+		return auth.TokenPair{}, fmt.Errorf("failed to generate token: %w", err)
 	}
-	return token, nil
+	refreshToken, err := as.authenticator.MakeRefreshToken()
+	if err != nil {
+		return auth.TokenPair{}, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	err = as.authStorage.InsertRefreshToken(ctx, domain2.RefreshToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: auth.HashRefreshToken(refreshToken),
+		ExpiresAt: time.Now().Add(as.authenticator.RefreshTokenExp()),
+	}, tx)
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+	return auth.TokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
 }
 
-func generatePasswordHash(password string) []byte {
-	h := sha256.New()
-	h.Write([]byte(password))
-	hash := h.Sum(nil)
-	return hash
+// rehashPassword upgrades a legacy or outdated password hash in place.
+// Failures are only logged: the user is already authenticated.
+func (as *AuthService) rehashPassword(ctx context.Context, user domain2.User, password string) {
+	newHash, err := as.passwordHasher.Hash(password)
+	if err != nil {
+		logger.Log.Warn("failed to upgrade password hash", zap.String("login", user.Login), zap.Error(err))
+		return
+	}
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		logger.Log.Warn("failed to upgrade password hash", zap.String("login", user.Login), zap.Error(err))
+		return
+	}
+	if err = as.authStorage.UpdatePasswordHash(ctx, user.ID, newHash, tx); err != nil {
+		rollback(tx)
+		logger.Log.Warn("failed to upgrade password hash", zap.String("login", user.Login), zap.Error(err))
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Log.Warn("failed to upgrade password hash", zap.String("login", user.Login), zap.Error(err))
+	}
 }
 
-func checkPassword(passHash []byte, password string) bool {
-	return bytes.Equal(passHash, generatePasswordHash(password))
+func rollback(tx *database.Trx) {
+	if err := tx.Rollback(); err != nil {
+		logger.Log.Debug("failed to rollback transaction", zap.Error(err))
+	}
 }
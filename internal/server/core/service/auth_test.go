@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"gokeeper/internal/server/adapters/storage/memory"
+	"gokeeper/pkg/auth"
+	domain2 "gokeeper/pkg/domain"
+	"gokeeper/pkg/totp"
+	"testing"
+	"time"
+)
+
+func newTestAuthService(t *testing.T) *AuthService {
+	t.Helper()
+	as, err := NewAuthService(memory.NewStorage(), *auth.NewAuthJWT("secret", time.Minute, time.Hour), PasswordHashParams{
+		Memory:  minPasswordHashMemory,
+		Time:    1,
+		Threads: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewAuthService: %v", err)
+	}
+	return as
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	ctx := context.Background()
+	as := newTestAuthService(t)
+	user := domain2.InUserRequest{Login: "alice", Password: "secret"}
+
+	tokens, err := as.Register(ctx, user)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("Register returned incomplete tokens %+v", tokens)
+	}
+	if _, err = as.Register(ctx, user); !errors.Is(err, domain2.ErrUserConflict) {
+		t.Fatalf("Register of a taken login: got %v, want %v", err, domain2.ErrUserConflict)
+	}
+
+	if _, err = as.Login(ctx, user); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	for _, in := range []domain2.InUserRequest{
+		{Login: "alice", Password: "wrong"},
+		{Login: "bob", Password: "secret"},
+	} {
+		if _, err = as.Login(ctx, in); !errors.Is(err, domain2.ErrUserAuthentication) {
+			t.Errorf("Login as %+v: got %v, want %v", in, err, domain2.ErrUserAuthentication)
+		}
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	as := newTestAuthService(t)
+
+	tokens, err := as.Register(ctx, domain2.InUserRequest{Login: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	rotated, err := as.Refresh(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if rotated.RefreshToken == tokens.RefreshToken {
+		t.Fatal("Refresh did not rotate the refresh token")
+	}
+
+	if _, err = as.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, domain2.ErrUserAuthentication) {
+		t.Fatalf("Refresh with a rotated token: got %v, want %v", err, domain2.ErrUserAuthentication)
+	}
+	// The reuse revoked the whole family, including the token it was
+	// rotated to.
+	if _, err = as.Refresh(ctx, rotated.RefreshToken); !errors.Is(err, domain2.ErrUserAuthentication) {
+		t.Fatalf("Refresh after reuse: got %v, want %v", err, domain2.ErrUserAuthentication)
+	}
+}
+
+func TestRefreshUnknownToken(t *testing.T) {
+	as := newTestAuthService(t)
+	if _, err := as.Refresh(context.Background(), "unknown"); !errors.Is(err, domain2.ErrUserAuthentication) {
+		t.Fatalf("Refresh: got %v, want %v", err, domain2.ErrUserAuthentication)
+	}
+}
+
+func TestMFALockout(t *testing.T) {
+	ctx := context.Background()
+	as := newTestAuthService(t)
+	in := domain2.InUserRequest{Login: "alice", Password: "secret"}
+	if _, err := as.Register(ctx, in); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	user, err := as.authStorage.GetUser(ctx, in.Login)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if _, err = as.EnrollTOTP(ctx, user.ID); err != nil {
+		t.Fatalf("EnrollTOTP: %v", err)
+	}
+	if user, err = as.authStorage.GetUser(ctx, in.Login); err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	now := time.Now()
+	if _, err = as.VerifyTOTP(ctx, user.ID, totp.Code(user.TOTPSecret, totp.Step(now)-1)); err != nil {
+		t.Fatalf("VerifyTOTP: %v", err)
+	}
+
+	// Every attempt starts a new login, so only a per-user count stops it.
+	loginMFA := func(code string) error {
+		tokens, err := as.Login(ctx, in)
+		if !errors.Is(err, domain2.ErrMFARequired) {
+			t.Fatalf("Login: got %v, want %v", err, domain2.ErrMFARequired)
+		}
+		_, err = as.LoginMFA(ctx, domain2.MFALoginRequest{MFAToken: string(tokens.MFAToken), Code: code})
+		return err
+	}
+	for i := range maxMFAFailures + 1 {
+		if err = loginMFA("nope"); !errors.Is(err, domain2.ErrUserAuthentication) {
+			t.Fatalf("attempt %d with a wrong code: got %v, want %v", i+1, err, domain2.ErrUserAuthentication)
+		}
+	}
+	valid := totp.Code(user.TOTPSecret, totp.Step(now))
+	var throttled *domain2.TooManyRequestsError
+	if err = loginMFA(valid); !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+		t.Fatalf("attempt while locked out: got %v, want %v", err, domain2.ErrTooManyRequests)
+	}
+
+	// Let the lockout run out.
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	past := now.Add(-time.Second)
+	if err = as.authStorage.SetMFAFailures(ctx, user.ID, maxMFAFailures+1, &past, tx); err != nil {
+		t.Fatalf("SetMFAFailures: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if err = loginMFA(valid); err != nil {
+		t.Fatalf("attempt after the lockout: %v", err)
+	}
+	if user, err = as.authStorage.GetUser(ctx, in.Login); err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.MFAFailures != 0 || user.MFALockedUntil != nil {
+		t.Fatalf("successful attempt left %d failures locked until %v", user.MFAFailures, user.MFALockedUntil)
+	}
+}
@@ -0,0 +1,499 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gokeeper/internal/server/adapters/storage"
+	"gokeeper/internal/server/adapters/storage/database"
+	"gokeeper/pkg/domain"
+	"gokeeper/pkg/logger"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultBlobChunkSize = 4 * 1024 * 1024
+	MinBlobChunkSize     = 64 * 1024
+	MaxBlobChunkSize     = 16 * 1024 * 1024
+)
+
+// BlobService handles resumable uploads of large payloads. A blob is created
+// with its size, its chunks are uploaded in any order and possibly more than
+// once, and it is completed with the SHA-256 checksum of the whole content.
+// Only completed blobs can be downloaded or referenced by records.
+type BlobService struct {
+	blobStorage storage.BlobStorage
+	chunkStore  storage.ChunkStore
+	policy      BlobPolicy
+}
+
+// BlobPolicy limits the blobs a user can keep.
+type BlobPolicy struct {
+	// MaxSize is the size limit of a single blob.
+	MaxSize int64
+	// Quota limits the total size of the blobs of a user, 0 disables it.
+	Quota int64
+	// UploadTTL is how long an upload may stay incomplete, or a completed
+	// blob unreferenced, before it is swept. 0 disables sweeping.
+	UploadTTL time.Duration
+}
+
+func NewBlobService(blobStorage storage.BlobStorage, chunkStore storage.ChunkStore, policy BlobPolicy) *BlobService {
+	return &BlobService{
+		blobStorage: blobStorage,
+		chunkStore:  chunkStore,
+		policy:      policy,
+	}
+}
+
+func (bs *BlobService) InitBlob(ctx context.Context, userID uuid.UUID, req domain.BlobInitRequest) (domain.BlobStatus, error) {
+	if req.Size <= 0 {
+		return domain.BlobStatus{}, domain.ErrPrivateDataBadFormat
+	}
+	if req.Size > bs.policy.MaxSize {
+		return domain.BlobStatus{}, domain.ErrBlobTooLarge
+	}
+	if req.ChunkSize == 0 {
+		req.ChunkSize = DefaultBlobChunkSize
+	}
+	if req.ChunkSize < MinBlobChunkSize || req.ChunkSize > MaxBlobChunkSize {
+		return domain.BlobStatus{}, domain.ErrPrivateDataBadFormat
+	}
+
+	blob := domain.Blob{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Size:      req.Size,
+		ChunkSize: req.ChunkSize,
+	}
+	tx, err := bs.blobStorage.BeginTx(ctx)
+	if err != nil {
+		return domain.BlobStatus{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = bs.checkQuota(ctx, userID, req.Size, tx); err != nil {
+		rollback(tx)
+		return domain.BlobStatus{}, err
+	}
+	if err = bs.blobStorage.InsertBlob(ctx, blob, tx); err != nil {
+		rollback(tx)
+		return domain.BlobStatus{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return domain.BlobStatus{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return blobStatus(blob, nil), nil
+}
+
+// checkQuota verifies within tx that the user can keep size more bytes of
+// blobs. The user stays locked until tx ends, so concurrent uploads can not
+// both fit into the remaining quota.
+func (bs *BlobService) checkQuota(ctx context.Context, userID uuid.UUID, size int64, tx *database.Trx) error {
+	if bs.policy.Quota <= 0 {
+		return nil
+	}
+	if _, err := bs.blobStorage.LockUser(ctx, userID, tx); err != nil {
+		return err
+	}
+	used, err := bs.blobStorage.GetBlobsSize(ctx, userID, tx)
+	if err != nil {
+		return err
+	}
+	if used+size > bs.policy.Quota {
+		return domain.ErrBlobQuotaExceeded
+	}
+	return nil
+}
+
+// PutBlobChunk stores a chunk. Uploading a chunk again replaces it, so
+// interrupted uploads can simply be retried. The chunk is received aside and
+// only put in place while the blob is locked, so it can not change under a
+// concurrent CompleteBlob.
+func (bs *BlobService) PutBlobChunk(ctx context.Context, userID, blobID uuid.UUID, index int, r io.Reader) error {
+	blob, err := bs.blobStorage.GetBlob(ctx, blobID, userID)
+	if err != nil {
+		return err
+	}
+	if blob.Completed {
+		return domain.ErrBlobCompleted
+	}
+	if index < 0 || index >= blob.Chunks() {
+		return domain.ErrBlobChunkInvalid
+	}
+
+	staged, err := bs.chunkStore.StageChunk(ctx, blobID, index, &exactReader{r: r, remaining: blob.ChunkLen(index)})
+	if err != nil {
+		return err
+	}
+	defer staged.Discard()
+
+	tx, err := bs.blobStorage.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if _, err = bs.lockBlob(ctx, userID, blobID, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = staged.Commit(); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = bs.blobStorage.InsertBlobChunk(ctx, blobID, index, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// BlobStatus reports which chunks of the blob were received.
+func (bs *BlobService) BlobStatus(ctx context.Context, userID, blobID uuid.UUID) (domain.BlobStatus, error) {
+	blob, err := bs.blobStorage.GetBlob(ctx, blobID, userID)
+	if err != nil {
+		return domain.BlobStatus{}, err
+	}
+	received, err := bs.blobStorage.GetBlobChunks(ctx, blobID)
+	if err != nil {
+		return domain.BlobStatus{}, err
+	}
+	return blobStatus(blob, received), nil
+}
+
+// CompleteBlob verifies that all chunks were received and that the content
+// matches the checksum. Completing a blob again with the same checksum is a
+// no-op. The blob stays locked while it is verified, so no chunk can be
+// replaced in the meantime.
+func (bs *BlobService) CompleteBlob(ctx context.Context, userID, blobID uuid.UUID, req domain.BlobCompleteRequest) error {
+	checksum, err := hex.DecodeString(req.SHA256)
+	if err != nil || len(checksum) != sha256.Size {
+		return domain.ErrPrivateDataBadFormat
+	}
+
+	tx, err := bs.blobStorage.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	blob, err := bs.blobStorage.LockBlob(ctx, blobID, tx)
+	if err == nil && blob.UserID != userID {
+		err = domain.ErrBlobNotFound
+	}
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+	if blob.Completed {
+		rollback(tx)
+		if subtle.ConstantTimeCompare(blob.Checksum, checksum) != 1 {
+			return domain.ErrBlobChecksumMismatch
+		}
+		return nil
+	}
+
+	received, err := bs.blobStorage.GetBlobChunks(ctx, blobID)
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+	if len(received) != blob.Chunks() {
+		rollback(tx)
+		return domain.ErrBlobIncomplete
+	}
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, newBlobReader(ctx, bs.chunkStore, blob)); err != nil {
+		rollback(tx)
+		return fmt.Errorf("failed to read blob: %w", err)
+	}
+	if subtle.ConstantTimeCompare(hash.Sum(nil), checksum) != 1 {
+		rollback(tx)
+		return domain.ErrBlobChecksumMismatch
+	}
+
+	if err = bs.blobStorage.CompleteBlob(ctx, blobID, checksum, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// OpenBlob returns the content of a completed blob. The reader supports
+// seeking, so ranges of the blob can be served.
+func (bs *BlobService) OpenBlob(ctx context.Context, userID, blobID uuid.UUID) (domain.Blob, io.ReadSeeker, error) {
+	blob, err := bs.blobStorage.GetBlob(ctx, blobID, userID)
+	if err != nil {
+		return domain.Blob{}, nil, err
+	}
+	if !blob.Completed {
+		return domain.Blob{}, nil, domain.ErrBlobIncomplete
+	}
+	return blob, newBlobReader(ctx, bs.chunkStore, blob), nil
+}
+
+// lockBlob locks the blob of the user within tx and fails if its upload is
+// already complete.
+func (bs *BlobService) lockBlob(ctx context.Context, userID, blobID uuid.UUID, tx *database.Trx) (domain.Blob, error) {
+	blob, err := bs.blobStorage.LockBlob(ctx, blobID, tx)
+	if err != nil {
+		return domain.Blob{}, err
+	}
+	if blob.UserID != userID {
+		return domain.Blob{}, domain.ErrBlobNotFound
+	}
+	if blob.Completed {
+		return domain.Blob{}, domain.ErrBlobCompleted
+	}
+	return blob, nil
+}
+
+// checkBlob verifies that a record may reference the blob.
+func (bs *BlobService) checkBlob(ctx context.Context, userID uuid.UUID, blobID string) error {
+	id, err := uuid.Parse(blobID)
+	if err != nil {
+		return domain.ErrPrivateDataBadFormat
+	}
+	blob, err := bs.blobStorage.GetBlob(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	if !blob.Completed {
+		return domain.ErrBlobIncomplete
+	}
+	return nil
+}
+
+// lockReferencedBlob verifies within tx that a record may reference the blob,
+// and keeps the blob from being swept until tx ends.
+func (bs *BlobService) lockReferencedBlob(ctx context.Context, userID uuid.UUID, blobID string, tx *database.Trx) error {
+	id, err := uuid.Parse(blobID)
+	if err != nil {
+		return domain.ErrPrivateDataBadFormat
+	}
+	blob, err := bs.blobStorage.LockBlob(ctx, id, tx)
+	if err != nil {
+		return err
+	}
+	if blob.UserID != userID {
+		return domain.ErrBlobNotFound
+	}
+	if !blob.Completed {
+		return domain.ErrBlobIncomplete
+	}
+	return nil
+}
+
+// RunBlobSweep sweeps the stale blobs every interval, until ctx is done. A
+// non-positive interval or upload TTL disables sweeping.
+func (bs *BlobService) RunBlobSweep(ctx context.Context, interval time.Duration) {
+	if interval <= 0 || bs.policy.UploadTTL <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := bs.SweepBlobs(ctx, time.Now().Add(-bs.policy.UploadTTL)); err != nil && ctx.Err() == nil {
+			logger.Log.Error("failed to sweep stale blobs", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepBlobs deletes the blobs created before the given time whose upload was
+// abandoned, or which were completed but never referenced by a record.
+func (bs *BlobService) SweepBlobs(ctx context.Context, createdBefore time.Time) error {
+	ids, err := bs.blobStorage.GetStaleBlobs(ctx, createdBefore)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err = bs.sweepBlob(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bs *BlobService) sweepBlob(ctx context.Context, id uuid.UUID) error {
+	tx, err := bs.blobStorage.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	blob, err := bs.blobStorage.LockBlob(ctx, id, tx)
+	if err != nil {
+		rollback(tx)
+		if errors.Is(err, domain.ErrBlobNotFound) {
+			return nil
+		}
+		return err
+	}
+	// A record may have started to reference the blob in the meantime.
+	if blob.Completed {
+		referenced, err := bs.blobStorage.IsBlobReferenced(ctx, id.String(), tx)
+		if err != nil {
+			rollback(tx)
+			return err
+		}
+		if referenced {
+			rollback(tx)
+			return nil
+		}
+	}
+	if err = bs.blobStorage.DeleteBlob(ctx, id, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	bs.removeChunks(ctx, id.String())
+	return nil
+}
+
+// deleteBlob deletes the blob within tx. Its chunks have to be removed with
+// removeChunks once the transaction is committed.
+func (bs *BlobService) deleteBlob(ctx context.Context, blobID string, tx *database.Trx) error {
+	id, err := uuid.Parse(blobID)
+	if err != nil {
+		return domain.ErrPrivateDataBadFormat
+	}
+	return bs.blobStorage.DeleteBlob(ctx, id, tx)
+}
+
+func (bs *BlobService) removeChunks(ctx context.Context, blobID string) {
+	id, err := uuid.Parse(blobID)
+	if err != nil {
+		return
+	}
+	if err = bs.chunkStore.DeleteChunks(ctx, id); err != nil {
+		logger.Log.Error("failed to delete blob chunks", zap.String("blob_id", blobID), zap.Error(err))
+	}
+}
+
+func blobStatus(blob domain.Blob, received []int) domain.BlobStatus {
+	if received == nil {
+		received = []int{}
+	}
+	return domain.BlobStatus{
+		ID:        blob.ID.String(),
+		Size:      blob.Size,
+		ChunkSize: blob.ChunkSize,
+		Chunks:    blob.Chunks(),
+		Received:  received,
+		Completed: blob.Completed,
+	}
+}
+
+// exactReader reads exactly remaining bytes from r and fails if r is shorter
+// or longer.
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (er *exactReader) Read(p []byte) (int, error) {
+	if er.remaining == 0 {
+		var extra [1]byte
+		if n, _ := er.r.Read(extra[:]); n > 0 {
+			return 0, domain.ErrBlobChunkInvalid
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > er.remaining {
+		p = p[:er.remaining]
+	}
+	n, err := er.r.Read(p)
+	er.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		if er.remaining > 0 {
+			return n, domain.ErrBlobChunkInvalid
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// blobReader reads a blob chunk by chunk.
+type blobReader struct {
+	ctx    context.Context
+	store  storage.ChunkStore
+	blob   domain.Blob
+	offset int64
+	chunk  io.ReadCloser
+}
+
+func newBlobReader(ctx context.Context, store storage.ChunkStore, blob domain.Blob) *blobReader {
+	return &blobReader{ctx: ctx, store: store, blob: blob}
+}
+
+func (br *blobReader) Read(p []byte) (int, error) {
+	if br.offset >= br.blob.Size {
+		br.closeChunk()
+		return 0, io.EOF
+	}
+	if br.chunk == nil {
+		index := br.offset / br.blob.ChunkSize
+		chunk, err := br.store.OpenChunk(br.ctx, br.blob.ID, int(index), br.offset%br.blob.ChunkSize)
+		if err != nil {
+			return 0, err
+		}
+		br.chunk = chunk
+	}
+
+	// Do not read past the chunk, so that the next read opens the next one.
+	chunkEnd := (br.offset/br.blob.ChunkSize + 1) * br.blob.ChunkSize
+	if int64(len(p)) > chunkEnd-br.offset {
+		p = p[:chunkEnd-br.offset]
+	}
+	n, err := br.chunk.Read(p)
+	br.offset += int64(n)
+	if br.offset == chunkEnd || br.offset >= br.blob.Size {
+		br.closeChunk()
+	}
+	if errors.Is(err, io.EOF) {
+		if n == 0 && br.offset < br.blob.Size {
+			return 0, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (br *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += br.offset
+	case io.SeekEnd:
+		offset += br.blob.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative blob offset")
+	}
+	if offset != br.offset {
+		br.closeChunk()
+		br.offset = offset
+	}
+	return offset, nil
+}
+
+func (br *blobReader) closeChunk() {
+	if br.chunk != nil {
+		br.chunk.Close()
+		br.chunk = nil
+	}
+}
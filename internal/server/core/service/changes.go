@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	domain2 "gokeeper/pkg/domain"
+
+	"github.com/google/uuid"
+)
+
+// GetChanges returns the changes of the user's records after the requested
+// revision. Deleted records are returned as tombstones without payloads. If
+// a record deleted after that revision has been purged since, the changes
+// start over from the first revision and are marked as a reset. The user is
+// locked while the revisions and the changes are read, so both reflect the
+// same committed state and no change in progress is skipped by the cursor.
+func (ps *PrivateService) GetChanges(ctx context.Context, req *domain2.ChangesRequest, userID uuid.UUID) (*domain2.Changes, error) {
+	tx, err := ps.privateStorage.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = ps.lockUser(ctx, userID, tx); err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	revision, purgedRevision, err := ps.privateStorage.GetRevisions(ctx, userID, tx)
+	if err != nil {
+		rollback(tx)
+		return nil, fmt.Errorf("failed to get revisions: %w", err)
+	}
+
+	changes := &domain2.Changes{Cursor: req.Since}
+	// A revision ahead of the user's one was not issued by this server.
+	if req.Since > 0 && (req.Since < purgedRevision || req.Since > revision) {
+		changes.Reset = true
+		changes.Cursor = 0
+	}
+	// Asking for one more change tells whether there are more of them.
+	data, err := ps.privateStorage.GetChanges(ctx, userID, &domain2.ChangesRequest{
+		Since: changes.Cursor,
+		Limit: req.Limit + 1,
+	}, tx)
+	if err != nil {
+		rollback(tx)
+		return nil, fmt.Errorf("failed to get changes: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if uint64(len(data)) > req.Limit {
+		changes.HasMore = true
+		data = data[:req.Limit]
+	}
+	for i := range data {
+		if data[i].DeletedAt != nil {
+			data[i].Data = nil
+			data[i].PayloadKey = ""
+		} else if err = ps.loadPayload(ctx, &data[i]); err != nil {
+			return nil, err
+		}
+		changes.Cursor = data[i].Revision
+	}
+	changes.Changes = data
+	return changes, nil
+}
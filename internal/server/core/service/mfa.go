@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"gokeeper/internal/server/adapters/storage/database"
+	"gokeeper/pkg/auth"
+	domain2 "gokeeper/pkg/domain"
+	"gokeeper/pkg/logger"
+	"gokeeper/pkg/totp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	totpIssuer         = "GophKeeper"
+	recoveryCodesCount = 10
+	recoveryCodeBytes  = 5
+
+	// maxMFAFailures is the number of second factor checks a user may fail
+	// in a row before having to wait for the next one. The wait starts at
+	// mfaLockout and doubles with every further failure up to maxMFALockout.
+	maxMFAFailures = 5
+	mfaLockout     = 30 * time.Second
+	maxMFALockout  = time.Hour
+)
+
+// EnrollTOTP generates a new TOTP secret for the user. The secret only takes
+// effect after it is confirmed with VerifyTOTP.
+func (as *AuthService) EnrollTOTP(ctx context.Context, userID uuid.UUID) (domain2.TOTPEnrollResponse, error) {
+	user, err := as.authStorage.GetUserByID(ctx, userID)
+	if err != nil {
+		return domain2.TOTPEnrollResponse{}, err
+	}
+	if user.TOTPEnabled {
+		return domain2.TOTPEnrollResponse{}, domain2.ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return domain2.TOTPEnrollResponse{}, fmt.Errorf("failed to generate totp secret: %w", err)
+	}
+
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		return domain2.TOTPEnrollResponse{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = as.authStorage.SetTOTPSecret(ctx, userID, secret, tx); err != nil {
+		rollback(tx)
+		return domain2.TOTPEnrollResponse{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return domain2.TOTPEnrollResponse{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return domain2.TOTPEnrollResponse{
+		Secret: totp.EncodeSecret(secret),
+		URI:    totp.URI(totpIssuer, user.Login, secret),
+	}, nil
+}
+
+// VerifyTOTP confirms the enrolled secret with a code from the authenticator
+// app, enables two-factor authentication and returns fresh recovery codes.
+func (as *AuthService) VerifyTOTP(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	user, err := as.authStorage.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, domain2.ErrMFAAlreadyEnabled
+	}
+	if len(user.TOTPSecret) == 0 {
+		return nil, domain2.ErrMFANotEnrolled
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, domain2.ErrUserAuthentication
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = as.authStorage.EnableTOTP(ctx, userID, step, tx); err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	if err = as.authStorage.ReplaceRecoveryCodes(ctx, userID, hashes, tx); err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return codes, nil
+}
+
+// LoginMFA completes a login started by Login using either a TOTP code or an
+// unused recovery code. After maxMFAFailures failed codes the user is locked
+// out for a while, however many MFA tokens are used.
+func (as *AuthService) LoginMFA(ctx context.Context, req domain2.MFALoginRequest) (auth.TokenPair, error) {
+	claims, err := as.authenticator.ParseMFAToken(req.MFAToken)
+	if err != nil {
+		return auth.TokenPair{}, domain2.ErrUserAuthentication
+	}
+	if err = as.addMFAFailure(ctx, claims.UserID); err != nil {
+		return auth.TokenPair{}, err
+	}
+	user, err := as.authStorage.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, domain2.ErrUserNotFound) {
+			return auth.TokenPair{}, domain2.ErrUserAuthentication
+		}
+		return auth.TokenPair{}, err
+	}
+
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		return auth.TokenPair{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = as.checkSecondFactor(ctx, user, req.Code, tx); err != nil {
+		rollback(tx)
+		return auth.TokenPair{}, err
+	}
+	if err = as.authStorage.SetMFAFailures(ctx, user.ID, 0, nil, tx); err != nil {
+		rollback(tx)
+		return auth.TokenPair{}, err
+	}
+	tokens, err := as.issueTokens(ctx, user, uuid.New(), tx)
+	if err != nil {
+		rollback(tx)
+		return auth.TokenPair{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return auth.TokenPair{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return tokens, nil
+}
+
+// addMFAFailure counts a second factor check of the user as failed until it
+// succeeds and resets the count, and refuses it while the user is locked out.
+// The count is committed before the code is checked, so that concurrent
+// checks can not exceed the limit.
+func (as *AuthService) addMFAFailure(ctx context.Context, userID uuid.UUID) error {
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	user, err := as.authStorage.LockUser(ctx, userID, tx)
+	if err != nil {
+		rollback(tx)
+		if errors.Is(err, domain2.ErrUserNotFound) {
+			return domain2.ErrUserAuthentication
+		}
+		return err
+	}
+
+	now := time.Now()
+	if user.MFALockedUntil != nil && now.Before(*user.MFALockedUntil) {
+		rollback(tx)
+		return &domain2.TooManyRequestsError{RetryAfter: user.MFALockedUntil.Sub(now)}
+	}
+	failures := user.MFAFailures + 1
+	var lockedUntil *time.Time
+	if failures > maxMFAFailures {
+		until := now.Add(mfaLockoutAfter(failures))
+		lockedUntil = &until
+		logger.Log.Warn("too many failed mfa attempts, locking out", zap.String("user_id", userID.String()))
+	}
+	if err = as.authStorage.SetMFAFailures(ctx, userID, failures, lockedUntil, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// mfaLockoutAfter returns how long the user has to wait after failures
+// failed second factor checks in a row.
+func mfaLockoutAfter(failures int) time.Duration {
+	lockout := mfaLockout
+	for i := maxMFAFailures + 1; i < failures && lockout < maxMFALockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, maxMFALockout)
+}
+
+// checkSecondFactor accepts a TOTP code newer than the last one used, or
+// consumes a recovery code. The last step is checked again by the update, as
+// a concurrent login may have used the code since user was loaded.
+func (as *AuthService) checkSecondFactor(ctx context.Context, user domain2.User, code string, tx *database.Trx) error {
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		if step <= user.TOTPLastStep {
+			return domain2.ErrUserAuthentication
+		}
+		updated, err := as.authStorage.UpdateTOTPLastStep(ctx, user.ID, step, tx)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return domain2.ErrUserAuthentication
+		}
+		return nil
+	}
+
+	used, err := as.authStorage.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code), tx)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return domain2.ErrUserAuthentication
+	}
+	return nil
+}
+
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([][]byte, 0, recoveryCodesCount)
+	for range recoveryCodesCount {
+		buf := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))
+		codes = append(codes, raw[:4]+"-"+raw[4:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return sum[:]
+}
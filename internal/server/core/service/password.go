@@ -0,0 +1,148 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2idPrefix  = "$argon2id$"
+	passwordSaltLen = 16
+	passwordKeyLen  = 32
+	legacyHashLen   = sha256.Size
+
+	// minPasswordHashMemory is the least memory, in KiB, accepted for
+	// new hashes.
+	minPasswordHashMemory = 8 * 1024
+)
+
+var errInvalidPasswordHash = errors.New("invalid password hash format")
+
+// PasswordHashParams are the Argon2id costs used for new password hashes.
+// Memory is in KiB.
+type PasswordHashParams struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// passwordHasher hashes account passwords with Argon2id and a per-user salt.
+// Parameters are encoded in the hash string in the PHC format, so they can be
+// tuned without invalidating existing hashes.
+type passwordHasher struct {
+	params PasswordHashParams
+}
+
+// Validate checks that the costs are usable for new hashes. Argon2id panics
+// without threads, and a hash without passes or with little memory is cheap
+// to brute-force.
+func (p PasswordHashParams) Validate() error {
+	switch {
+	case p.Time == 0:
+		return errors.New("password hash time must be at least 1")
+	case p.Threads == 0:
+		return errors.New("password hash threads must be at least 1")
+	case p.Memory < minPasswordHashMemory:
+		return fmt.Errorf("password hash memory must be at least %d KiB", minPasswordHashMemory)
+	default:
+		return nil
+	}
+}
+
+func newPasswordHasher(params PasswordHashParams) (*passwordHasher, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return &passwordHasher{params: params}, nil
+}
+
+func (ph *passwordHasher) Hash(password string) ([]byte, error) {
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, ph.params.Time, ph.params.Memory, ph.params.Threads, passwordKeyLen)
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		ph.params.Memory,
+		ph.params.Time,
+		ph.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+	return []byte(encoded), nil
+}
+
+// Check reports whether password matches passHash. Both Argon2id hashes and
+// legacy unsalted SHA-256 hashes are accepted.
+func (ph *passwordHasher) Check(passHash []byte, password string) bool {
+	if isLegacyPasswordHash(passHash) {
+		legacy := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(passHash, legacy[:]) == 1
+	}
+	params, salt, key, err := decodePasswordHash(passHash)
+	if err != nil {
+		return false
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// NeedsRehash reports whether passHash is a legacy hash or was produced with
+// parameters different from the current ones.
+func (ph *passwordHasher) NeedsRehash(passHash []byte) bool {
+	if isLegacyPasswordHash(passHash) {
+		return true
+	}
+	params, _, _, err := decodePasswordHash(passHash)
+	if err != nil {
+		return true
+	}
+	return params != ph.params
+}
+
+func isLegacyPasswordHash(passHash []byte) bool {
+	return len(passHash) == legacyHashLen && !bytes.HasPrefix(passHash, []byte(argon2idPrefix))
+}
+
+func decodePasswordHash(passHash []byte) (PasswordHashParams, []byte, []byte, error) {
+	var params PasswordHashParams
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(string(passHash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+	// Argon2id panics without threads, so a stored hash is never trusted
+	// to have them.
+	if params.Time == 0 || params.Threads == 0 {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+	return params, salt, key, nil
+}
@@ -5,46 +5,161 @@ import (
 	"errors"
 	"fmt"
 	"gokeeper/internal/server/adapters/storage"
+	"gokeeper/internal/server/adapters/storage/blobstore"
+	"gokeeper/internal/server/adapters/storage/database"
 	domain2 "gokeeper/pkg/domain"
+	"gokeeper/pkg/logger"
+	"slices"
 
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
+// PrivateService stores records. Payloads larger than offloadThreshold are
+// kept in payloadStore instead of the database; this is transparent to
+// clients. Every save adds a version of the record, which is kept as long as
+// the retention policy allows.
 type PrivateService struct {
-	privateStorage storage.PrivateStorage
+	privateStorage   storage.PrivateStorage
+	blobs            *BlobService
+	payloadStore     storage.BlobStore
+	offloadThreshold int
+	retention        RetentionPolicy
 }
 
-func NewPrivateService(privateStorage storage.PrivateStorage) *PrivateService {
+func NewPrivateService(
+	privateStorage storage.PrivateStorage,
+	blobs *BlobService,
+	payloadStore storage.BlobStore,
+	offloadThreshold int,
+	retention RetentionPolicy,
+) *PrivateService {
 	return &PrivateService{
-		privateStorage: privateStorage,
+		privateStorage:   privateStorage,
+		blobs:            blobs,
+		payloadStore:     payloadStore,
+		offloadThreshold: offloadThreshold,
+		retention:        retention,
 	}
 }
 
-func (ps *PrivateService) Save(ctx context.Context, pd *domain2.Data, userID uuid.UUID) error {
+func (ps *PrivateService) Save(ctx context.Context, pd *domain2.Data, userID uuid.UUID) (err error) {
+	if err = ps.prepareSave(ctx, pd, userID); err != nil {
+		return err
+	}
+	if pd.PayloadKey != "" {
+		// The payload store is not part of the transaction, so the payload
+		// has to be dropped again if the record is not saved.
+		defer func() {
+			if err != nil {
+				ps.dropPayload(ctx, pd.PayloadKey)
+			}
+		}()
+	}
+
 	tx, err := ps.privateStorage.BeginTx(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-
-	existingPrivateData, err := ps.privateStorage.GetByID(ctx, pd.ID, userID, tx)
-	if err != nil && !errors.Is(err, domain2.ErrPrivateDataNotFound) {
-		return fmt.Errorf("failed to get existing private data: %w", err)
+	if err = ps.lockUser(ctx, userID, tx); err != nil {
+		rollback(tx)
+		return err
 	}
-
-	if existingPrivateData != nil && existingPrivateData.SavedAt.After(pd.SavedAt) {
-		return domain2.ErrPrivateDataConflict
+	if err = ps.lockPayloads(ctx, []*domain2.Data{pd}, tx); err != nil {
+		rollback(tx)
+		return err
 	}
 
-	if err = ps.privateStorage.InsertOrUpdate(ctx, pd, userID, tx); err != nil {
-		return fmt.Errorf("failed to save private data: %w", err)
+	pruned, orphanBlobIDs, err := ps.save(ctx, pd, userID, tx)
+	if err != nil {
+		rollback(tx)
+		return err
 	}
 
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	ps.releaseVersions(ctx, pruned, orphanBlobIDs)
 	return nil
 }
 
+// prepareSave checks the blob a record refers to and decides whether the
+// payload is offloaded, which happens outside of the transaction saving the
+// record.
+func (ps *PrivateService) prepareSave(ctx context.Context, pd *domain2.Data, userID uuid.UUID) error {
+	if pd.BlobID != "" {
+		if err := ps.blobs.checkBlob(ctx, userID, pd.BlobID); err != nil {
+			return err
+		}
+	}
+	ps.offloadPayload(pd)
+	return nil
+}
+
+// checkRevision checks that a change based on revision applies to the record
+// as it is now. A change of an existing record, even one in the trash, has to
+// name its current revision; a new record must not name any.
+func checkRevision(existing *domain2.Data, revision int64) error {
+	switch {
+	case existing == nil && revision != 0:
+		return domain2.ErrRevisionMismatch
+	case existing == nil:
+		return nil
+	case revision == 0:
+		return domain2.ErrRevisionRequired
+	case revision != existing.Revision:
+		return domain2.ErrRevisionMismatch
+	default:
+		return nil
+	}
+}
+
+// lockUser locks the user in tx until it ends. Every change of records takes
+// the lock before reading them, so that the revision a change is checked
+// against can not change before the change is written.
+func (ps *PrivateService) lockUser(ctx context.Context, userID uuid.UUID, tx *database.Trx) error {
+	if _, err := ps.privateStorage.LockUser(ctx, userID, tx); err != nil {
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+	return nil
+}
+
+// save writes a prepared record in tx, which holds the locks of the user and
+// of the offloaded payload. The blob the record refers to is checked again
+// and locked, since it may have been swept since prepareSave. It returns the
+// versions pruned by the retention policy and the blobs they left unused,
+// which are to be released once tx is committed.
+func (ps *PrivateService) save(
+	ctx context.Context,
+	pd *domain2.Data,
+	userID uuid.UUID,
+	tx *database.Trx,
+) ([]domain2.Data, []string, error) {
+	existingPrivateData, err := ps.privateStorage.GetByID(ctx, pd.ID, userID, tx)
+	if err != nil && !errors.Is(err, domain2.ErrPrivateDataNotFound) {
+		return nil, nil, fmt.Errorf("failed to get existing private data: %w", err)
+	}
+
+	// A record in the trash is only overwritten by a change based on its
+	// trashed revision; anything else most likely means to create a new one.
+	if existingPrivateData != nil && existingPrivateData.DeletedAt != nil && pd.Revision == 0 {
+		return nil, nil, domain2.ErrPrivateDataDeleted
+	}
+	if err = checkRevision(existingPrivateData, pd.Revision); err != nil {
+		return nil, nil, err
+	}
+	if pd.BlobID != "" {
+		if err = ps.blobs.lockReferencedBlob(ctx, userID, pd.BlobID, tx); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err = ps.storePayload(ctx, pd); err != nil {
+		return nil, nil, err
+	}
+
+	return ps.writeVersion(ctx, pd, existingPrivateData, userID, tx)
+}
+
 func (ps *PrivateService) GetByID(ctx context.Context, id string, userID uuid.UUID) (*domain2.Data, error) {
 	tx, err := ps.privateStorage.BeginTx(ctx)
 	if err != nil {
@@ -53,46 +168,289 @@ func (ps *PrivateService) GetByID(ctx context.Context, id string, userID uuid.UU
 
 	existingPrivateData, err := ps.privateStorage.GetByID(ctx, id, userID, tx)
 	if err != nil {
+		rollback(tx)
 		return nil, fmt.Errorf("failed to get existing private data: %w", err)
 	}
 	if err = tx.Commit(); err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	if existingPrivateData.DeletedAt != nil {
+		return nil, domain2.ErrPrivateDataNotFound
+	}
 
+	if err = ps.loadPayload(ctx, existingPrivateData); err != nil {
+		return nil, err
+	}
 	return existingPrivateData, nil
 }
 
+// Delete moves the record to the trash. Its payload and history are kept
+// until it is purged, so it can be undeleted.
 func (ps *PrivateService) Delete(ctx context.Context, pd *domain2.DeleteRequest, userID uuid.UUID) error {
 	tx, err := ps.privateStorage.BeginTx(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	if err = ps.lockUser(ctx, userID, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = ps.delete(ctx, pd, userID, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// delete moves the record to the trash in tx, which holds the lock of the
+// user. Deleting a missing or deleted record does nothing.
+func (ps *PrivateService) delete(ctx context.Context, pd *domain2.DeleteRequest, userID uuid.UUID, tx *database.Trx) error {
 	existingPrivateData, err := ps.privateStorage.GetByID(ctx, pd.ID, userID, tx)
+	if errors.Is(err, domain2.ErrPrivateDataNotFound) {
+		return nil
+	}
 	if err != nil {
-		switch {
-		case errors.Is(err, domain2.ErrPrivateDataNotFound):
-			return nil
-		}
 		return fmt.Errorf("failed to get existing private data: %w", err)
 	}
 
-	if existingPrivateData != nil && existingPrivateData.SavedAt.After(pd.DeletedAt) {
-		return domain2.ErrPrivateDataConflict
+	if existingPrivateData.DeletedAt != nil {
+		return nil
+	}
+	if err = checkRevision(existingPrivateData, pd.Revision); err != nil {
+		return err
 	}
 
-	if err = ps.privateStorage.Delete(ctx, pd.ID, userID, tx); err != nil {
+	revision, err := ps.privateStorage.NextRevision(ctx, userID, tx)
+	if err != nil {
+		return fmt.Errorf("failed to get next revision: %w", err)
+	}
+	if err = ps.privateStorage.MarkDeleted(ctx, pd.ID, userID, pd.DeletedAt, revision, tx); err != nil {
 		return fmt.Errorf("failed to delete private data: %w", err)
 	}
+	return nil
+}
+
+// Batch applies ops and returns the error of each, in order. In best effort
+// mode every op is applied on its own. Otherwise they are applied in one
+// transaction which is rolled back on the first failure: the failing op gets
+// its error and all others ErrBatchAborted. The returned error is set only if
+// the batch could not be processed at all.
+func (ps *PrivateService) Batch(ctx context.Context, ops []domain2.BatchOp, bestEffort bool, userID uuid.UUID) ([]error, error) {
+	errs := make([]error, len(ops))
+	if bestEffort {
+		for i, op := range ops {
+			if op.Delete != nil {
+				errs[i] = ps.Delete(ctx, op.Delete, userID)
+			} else {
+				errs[i] = ps.Save(ctx, op.Save, userID)
+			}
+		}
+		return errs, nil
+	}
+
+	// The payload store is not part of the transaction, so payloads have to
+	// be dropped again if the batch fails.
+	var payloadKeys []string
+	dropPayloads := func() {
+		for _, key := range payloadKeys {
+			ps.dropPayload(ctx, key)
+		}
+	}
+	abort := func(failed int, err error) []error {
+		dropPayloads()
+		for i := range errs {
+			errs[i] = domain2.ErrBatchAborted
+		}
+		errs[failed] = err
+		return errs
+	}
+
+	for i, op := range ops {
+		if op.Save == nil {
+			continue
+		}
+		if err := ps.prepareSave(ctx, op.Save, userID); err != nil {
+			return abort(i, err), nil
+		}
+		if op.Save.PayloadKey != "" {
+			payloadKeys = append(payloadKeys, op.Save.PayloadKey)
+		}
+	}
+
+	tx, err := ps.privateStorage.BeginTx(ctx)
+	if err != nil {
+		dropPayloads()
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	saves := make([]*domain2.Data, 0, len(ops))
+	for _, op := range ops {
+		if op.Save != nil {
+			saves = append(saves, op.Save)
+		}
+	}
+	if err = ps.lockUser(ctx, userID, tx); err == nil {
+		err = ps.lockPayloads(ctx, saves, tx)
+	}
+	if err != nil {
+		rollback(tx)
+		dropPayloads()
+		return nil, err
+	}
+
+	var pruned []domain2.Data
+	var orphanBlobIDs []string
+	for i, op := range ops {
+		if op.Delete != nil {
+			err = ps.delete(ctx, op.Delete, userID, tx)
+		} else {
+			var p []domain2.Data
+			var o []string
+			p, o, err = ps.save(ctx, op.Save, userID, tx)
+			pruned = append(pruned, p...)
+			orphanBlobIDs = append(orphanBlobIDs, o...)
+		}
+		if err != nil {
+			rollback(tx)
+			return abort(i, err), nil
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		dropPayloads()
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
-	return nil
+	ps.releaseVersions(ctx, pruned, orphanBlobIDs)
+	return errs, nil
 }
 
-func (ps *PrivateService) GetAll(ctx context.Context, req *domain2.GetAllRequest, userID uuid.UUID) ([]domain2.Data, error) {
-	data, err := ps.privateStorage.GetAll(ctx, req, userID)
+// GetAll returns a page of the user's records matching the filters of req,
+// starting after its cursor, with the total number of matching records.
+func (ps *PrivateService) GetAll(ctx context.Context, req *domain2.GetAllRequest, userID uuid.UUID) (*domain2.Page, error) {
+	if req.Cursor != "" {
+		after, err := domain2.ParseCursor(req.Cursor, req.Order)
+		if err != nil {
+			return nil, err
+		}
+		req.After = after
+	}
+
+	// One record more than asked for tells whether there is a next page.
+	pageReq := *req
+	pageReq.Limit++
+	data, err := ps.privateStorage.GetAll(ctx, &pageReq, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get private data: %w", err)
 	}
-	return data, nil
+	total, err := ps.privateStorage.CountAll(ctx, req, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count private data: %w", err)
+	}
+
+	page := &domain2.Page{Records: data, Total: total}
+	if uint64(len(data)) > req.Limit {
+		page.Records = data[:req.Limit]
+		page.NextCursor = domain2.KeyOf(page.Records[len(page.Records)-1], req.Order).Cursor()
+	}
+	for i := range page.Records {
+		if err = ps.loadPayload(ctx, &page.Records[i]); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// offloadPayload sets the key a large payload of pd is to be stored under.
+// The payload itself is stored by storePayload.
+func (ps *PrivateService) offloadPayload(pd *domain2.Data) {
+	pd.PayloadKey = ""
+	if ps.payloadStore == nil || ps.offloadThreshold <= 0 || len(pd.Data) <= ps.offloadThreshold {
+		return
+	}
+	pd.PayloadKey = blobstore.Key(pd.Data)
+}
+
+// lockPayloads locks the offloaded payloads of pds in tx, in the order of
+// their keys, so that transactions sharing payloads do not deadlock.
+func (ps *PrivateService) lockPayloads(ctx context.Context, pds []*domain2.Data, tx *database.Trx) error {
+	var keys []string
+	for _, pd := range pds {
+		if pd.PayloadKey != "" {
+			keys = append(keys, pd.PayloadKey)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range slices.Compact(keys) {
+		if err := ps.privateStorage.LockPayload(ctx, key, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storePayload moves the offloaded payload of pd to the payload store. It is
+// called under the lock of the payload, which dropPayload takes as well, so
+// the payload can not be dropped before the record referring to it is
+// committed.
+func (ps *PrivateService) storePayload(ctx context.Context, pd *domain2.Data) error {
+	if pd.PayloadKey == "" {
+		return nil
+	}
+	if _, err := ps.payloadStore.Put(ctx, pd.Data); err != nil {
+		return fmt.Errorf("failed to store payload: %w", err)
+	}
+	pd.Data = nil
+	return nil
+}
+
+// loadPayload fills in the payload of pd if it was offloaded.
+func (ps *PrivateService) loadPayload(ctx context.Context, pd *domain2.Data) error {
+	if pd.PayloadKey == "" {
+		return nil
+	}
+	if ps.payloadStore == nil {
+		return fmt.Errorf("payload of record %s is offloaded, but no payload store is configured", pd.ID)
+	}
+	data, err := ps.payloadStore.Get(ctx, pd.PayloadKey)
+	if err != nil {
+		return fmt.Errorf("failed to load payload of record %s: %w", pd.ID, err)
+	}
+	pd.Data = data
+	return nil
+}
+
+// dropPayload deletes the payload unless a record still refers to it. Errors
+// are only logged, since the record change they follow is already committed.
+func (ps *PrivateService) dropPayload(ctx context.Context, key string) {
+	if err := ps.deletePayload(ctx, key); err != nil {
+		logger.Log.Error("failed to delete payload", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// deletePayload deletes the payload if no record refers to it. The check and
+// the deletion hold the lock of the payload, so a record being saved with the
+// same payload is either seen by the check or stores the payload again.
+func (ps *PrivateService) deletePayload(ctx context.Context, key string) error {
+	tx, err := ps.privateStorage.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = ps.privateStorage.LockPayload(ctx, key, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	referenced, err := ps.privateStorage.IsPayloadReferenced(ctx, key, tx)
+	if err == nil && !referenced {
+		err = ps.payloadStore.Delete(ctx, key)
+	}
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"gokeeper/internal/server/adapters/storage/memory"
+	domain2 "gokeeper/pkg/domain"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestPrivateService(t *testing.T) (*PrivateService, uuid.UUID) {
+	t.Helper()
+	ctx := context.Background()
+	store := memory.NewStorage()
+
+	userID := uuid.New()
+	tx, err := store.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	if err = store.InsertUser(ctx, domain2.User{ID: userID, Login: "alice"}, tx); err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	blobs := NewBlobService(store, nil, BlobPolicy{})
+	return NewPrivateService(store, blobs, nil, 0, RetentionPolicy{}), userID
+}
+
+func textRecord(id string, data string, revision int64) *domain2.Data {
+	return &domain2.Data{
+		ID:       id,
+		DataType: domain2.TEXT,
+		Data:     []byte(data),
+		SavedAt:  time.Now(),
+		Revision: revision,
+	}
+}
+
+func TestSaveChecksRevision(t *testing.T) {
+	ctx := context.Background()
+	ps, userID := newTestPrivateService(t)
+
+	if err := ps.Save(ctx, textRecord("a", "v0", 1), userID); !errors.Is(err, domain2.ErrRevisionMismatch) {
+		t.Fatalf("Save of a new record at a revision: got %v, want %v", err, domain2.ErrRevisionMismatch)
+	}
+	first := textRecord("a", "v1", 0)
+	if err := ps.Save(ctx, first, userID); err != nil {
+		t.Fatalf("Save of a new record: %v", err)
+	}
+
+	if err := ps.Save(ctx, textRecord("a", "v2", 0), userID); !errors.Is(err, domain2.ErrRevisionRequired) {
+		t.Fatalf("Save without a revision: got %v, want %v", err, domain2.ErrRevisionRequired)
+	}
+	if err := ps.Save(ctx, textRecord("a", "v2", first.Revision+1), userID); !errors.Is(err, domain2.ErrRevisionMismatch) {
+		t.Fatalf("Save at another revision: got %v, want %v", err, domain2.ErrRevisionMismatch)
+	}
+	second := textRecord("a", "v2", first.Revision)
+	if err := ps.Save(ctx, second, userID); err != nil {
+		t.Fatalf("Save at the current revision: %v", err)
+	}
+	if second.Revision <= first.Revision {
+		t.Fatalf("Save left the revision at %d, was %d", second.Revision, first.Revision)
+	}
+
+	pd, err := ps.GetByID(ctx, "a", userID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if string(pd.Data) != "v2" || pd.Version != 2 || pd.Revision != second.Revision {
+		t.Fatalf("GetByID returned %q at version %d and revision %d", pd.Data, pd.Version, pd.Revision)
+	}
+}
+
+func TestConcurrentSavesAtOneRevision(t *testing.T) {
+	ctx := context.Background()
+	ps, userID := newTestPrivateService(t)
+
+	pd := textRecord("a", "v1", 0)
+	if err := ps.Save(ctx, pd, userID); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	const savers = 8
+	errs := make([]error, savers)
+	var wg sync.WaitGroup
+	for i := range savers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = ps.Save(ctx, textRecord("a", "v2", pd.Revision), userID)
+		}()
+	}
+	wg.Wait()
+
+	var saved int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			saved++
+		case !errors.Is(err, domain2.ErrRevisionMismatch):
+			t.Errorf("Save: got %v, want nil or %v", err, domain2.ErrRevisionMismatch)
+		}
+	}
+	if saved != 1 {
+		t.Fatalf("%d saves at one revision succeeded, want 1", saved)
+	}
+}
+
+func TestDeleteChecksRevision(t *testing.T) {
+	ctx := context.Background()
+	ps, userID := newTestPrivateService(t)
+
+	pd := textRecord("a", "v1", 0)
+	if err := ps.Save(ctx, pd, userID); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	deletion := &domain2.DeleteRequest{ID: "a", DeletedAt: time.Now()}
+	if err := ps.Delete(ctx, deletion, userID); !errors.Is(err, domain2.ErrRevisionRequired) {
+		t.Fatalf("Delete without a revision: got %v, want %v", err, domain2.ErrRevisionRequired)
+	}
+	deletion.Revision = pd.Revision + 1
+	if err := ps.Delete(ctx, deletion, userID); !errors.Is(err, domain2.ErrRevisionMismatch) {
+		t.Fatalf("Delete at another revision: got %v, want %v", err, domain2.ErrRevisionMismatch)
+	}
+	deletion.Revision = pd.Revision
+	if err := ps.Delete(ctx, deletion, userID); err != nil {
+		t.Fatalf("Delete at the current revision: %v", err)
+	}
+	if _, err := ps.GetByID(ctx, "a", userID); !errors.Is(err, domain2.ErrPrivateDataNotFound) {
+		t.Fatalf("GetByID of a deleted record: got %v, want %v", err, domain2.ErrPrivateDataNotFound)
+	}
+
+	trash, err := ps.GetTrash(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetTrash: %v", err)
+	}
+	if len(trash) != 1 || trash[0].Revision <= pd.Revision {
+		t.Fatalf("GetTrash returned %+v", trash)
+	}
+	if _, err = ps.Undelete(ctx, "a", userID, pd.Revision); !errors.Is(err, domain2.ErrRevisionMismatch) {
+		t.Fatalf("Undelete at the revision before the deletion: got %v, want %v", err, domain2.ErrRevisionMismatch)
+	}
+	if _, err = ps.Undelete(ctx, "a", userID, trash[0].Revision); err != nil {
+		t.Fatalf("Undelete at the current revision: %v", err)
+	}
+	if _, err = ps.GetByID(ctx, "a", userID); err != nil {
+		t.Fatalf("GetByID of an undeleted record: %v", err)
+	}
+}
+
+func TestSaveOverTrashedRecord(t *testing.T) {
+	ctx := context.Background()
+	ps, userID := newTestPrivateService(t)
+
+	pd := textRecord("a", "v1", 0)
+	if err := ps.Save(ctx, pd, userID); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	deletion := &domain2.DeleteRequest{ID: "a", DeletedAt: time.Now(), Revision: pd.Revision}
+	if err := ps.Delete(ctx, deletion, userID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if err := ps.Save(ctx, textRecord("a", "v2", 0), userID); !errors.Is(err, domain2.ErrPrivateDataDeleted) {
+		t.Fatalf("Save without a revision: got %v, want %v", err, domain2.ErrPrivateDataDeleted)
+	}
+	trash, err := ps.GetTrash(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetTrash: %v", err)
+	}
+	if len(trash) != 1 {
+		t.Fatalf("GetTrash returned %+v", trash)
+	}
+	if err = ps.Save(ctx, textRecord("a", "v2", trash[0].Revision), userID); err != nil {
+		t.Fatalf("Save at the trashed revision: %v", err)
+	}
+	if pd, err = ps.GetByID(ctx, "a", userID); err != nil || string(pd.Data) != "v2" {
+		t.Fatalf("GetByID returned %+v, %v", pd, err)
+	}
+}
+
+func TestBatchRollsBackOnFailure(t *testing.T) {
+	ctx := context.Background()
+	ps, userID := newTestPrivateService(t)
+
+	existing := textRecord("a", "v1", 0)
+	if err := ps.Save(ctx, existing, userID); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	ops := []domain2.BatchOp{
+		{Save: textRecord("b", "new", 0)},
+		{Save: textRecord("a", "v2", existing.Revision+1)},
+		{Delete: &domain2.DeleteRequest{ID: "a", DeletedAt: time.Now(), Revision: existing.Revision}},
+	}
+	errs, err := ps.Batch(ctx, ops, false, userID)
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	want := []error{domain2.ErrBatchAborted, domain2.ErrRevisionMismatch, domain2.ErrBatchAborted}
+	for i := range want {
+		if !errors.Is(errs[i], want[i]) {
+			t.Errorf("op %d: got %v, want %v", i, errs[i], want[i])
+		}
+	}
+
+	if _, err = ps.GetByID(ctx, "b", userID); !errors.Is(err, domain2.ErrPrivateDataNotFound) {
+		t.Fatalf("GetByID of a record saved by an aborted batch: got %v, want %v", err, domain2.ErrPrivateDataNotFound)
+	}
+	pd, err := ps.GetByID(ctx, "a", userID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if !bytes.Equal(pd.Data, []byte("v1")) || pd.Revision != existing.Revision {
+		t.Fatalf("aborted batch changed the record to %q at revision %d", pd.Data, pd.Revision)
+	}
+	changes, err := ps.GetChanges(ctx, &domain2.ChangesRequest{Since: existing.Revision, Limit: 10}, userID)
+	if err != nil {
+		t.Fatalf("GetChanges: %v", err)
+	}
+	if len(changes.Changes) != 0 {
+		t.Fatalf("aborted batch left changes %+v", changes.Changes)
+	}
+}
+
+func TestBatchBestEffort(t *testing.T) {
+	ctx := context.Background()
+	ps, userID := newTestPrivateService(t)
+
+	existing := textRecord("a", "v1", 0)
+	if err := ps.Save(ctx, existing, userID); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	ops := []domain2.BatchOp{
+		{Save: textRecord("b", "new", 0)},
+		{Save: textRecord("a", "v2", existing.Revision+1)},
+	}
+	errs, err := ps.Batch(ctx, ops, true, userID)
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], domain2.ErrRevisionMismatch) {
+		t.Fatalf("Batch returned %v", errs)
+	}
+	if _, err = ps.GetByID(ctx, "b", userID); err != nil {
+		t.Fatalf("GetByID of a record saved in best effort mode: %v", err)
+	}
+}
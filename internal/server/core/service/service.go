@@ -8,14 +8,27 @@ import (
 type Services struct {
 	*AuthService
 	*PrivateService
+	*BlobService
 }
 
 func NewServices(
 	storage storage.Storage,
+	chunkStore storage.ChunkStore,
+	payloadStore storage.BlobStore,
 	authenticator auth.Authenticator,
-) *Services {
-	return &Services{
-		NewAuthService(storage, authenticator),
-		NewPrivateService(storage),
+	hashParams PasswordHashParams,
+	blobPolicy BlobPolicy,
+	offloadThreshold int,
+	retention RetentionPolicy,
+) (*Services, error) {
+	authService, err := NewAuthService(storage, authenticator, hashParams)
+	if err != nil {
+		return nil, err
 	}
+	blobService := NewBlobService(storage, chunkStore, blobPolicy)
+	return &Services{
+		authService,
+		NewPrivateService(storage, blobService, payloadStore, offloadThreshold, retention),
+		blobService,
+	}, nil
 }
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	domain2 "gokeeper/pkg/domain"
+	"gokeeper/pkg/logger"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// GetTrash returns the deleted records of the user without their payloads,
+// most recently deleted first.
+func (ps *PrivateService) GetTrash(ctx context.Context, userID uuid.UUID) ([]domain2.Data, error) {
+	data, err := ps.privateStorage.GetDeleted(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deleted private data: %w", err)
+	}
+	return data, nil
+}
+
+// Undelete takes the record out of the trash as a new version and returns it
+// without its payload. The record is dated now, so that clients take it as
+// the latest change. Like a save, it has to name the current revision of the
+// record, which is the one of its deletion.
+func (ps *PrivateService) Undelete(ctx context.Context, id string, userID uuid.UUID, revision int64) (*domain2.Data, error) {
+	tx, err := ps.privateStorage.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = ps.lockUser(ctx, userID, tx); err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	existingPrivateData, err := ps.privateStorage.GetByID(ctx, id, userID, tx)
+	if err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	if existingPrivateData.DeletedAt == nil {
+		rollback(tx)
+		return nil, domain2.ErrPrivateDataNotFound
+	}
+	if err = checkRevision(existingPrivateData, revision); err != nil {
+		rollback(tx)
+		return nil, err
+	}
+
+	pd := *existingPrivateData
+	pd.DeletedAt = nil
+	pd.SavedAt = time.Now()
+	if existingPrivateData.DeletedAt.After(pd.SavedAt) {
+		pd.SavedAt = *existingPrivateData.DeletedAt
+	}
+	pruned, orphanBlobIDs, err := ps.writeVersion(ctx, &pd, existingPrivateData, userID, tx)
+	if err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	ps.releaseVersions(ctx, pruned, orphanBlobIDs)
+
+	pd.Data = nil
+	return &pd, nil
+}
+
+// RunPurge purges the records deleted longer than retention ago every
+// interval, until ctx is done. A non-positive interval disables purging.
+func (ps *PrivateService) RunPurge(ctx context.Context, interval, retention time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := ps.Purge(ctx, time.Now().Add(-retention)); err != nil && ctx.Err() == nil {
+			logger.Log.Error("failed to purge deleted private data", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge permanently removes the records deleted before the given time,
+// together with their history and payloads.
+func (ps *PrivateService) Purge(ctx context.Context, deletedBefore time.Time) error {
+	keys, err := ps.privateStorage.GetTombstones(ctx, deletedBefore)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = ps.purge(ctx, key, deletedBefore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ps *PrivateService) purge(ctx context.Context, key domain2.RecordKey, deletedBefore time.Time) error {
+	tx, err := ps.privateStorage.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = ps.lockUser(ctx, key.UserID, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	existingPrivateData, err := ps.privateStorage.GetByID(ctx, key.ID, key.UserID, tx)
+	if err != nil {
+		rollback(tx)
+		if errors.Is(err, domain2.ErrPrivateDataNotFound) {
+			return nil
+		}
+		return err
+	}
+	// The record may have been undeleted or saved again in the meantime.
+	if existingPrivateData.DeletedAt == nil || !existingPrivateData.DeletedAt.Before(deletedBefore) {
+		rollback(tx)
+		return nil
+	}
+
+	// Clients that have not seen the tombstone yet can no longer learn about
+	// the deletion from the changes, so they have to start over.
+	if err = ps.privateStorage.SetPurgedRevision(ctx, key.UserID, existingPrivateData.Revision, tx); err != nil {
+		rollback(tx)
+		return fmt.Errorf("failed to set purged revision: %w", err)
+	}
+	if err = ps.privateStorage.Delete(ctx, key.ID, key.UserID, tx); err != nil {
+		rollback(tx)
+		return fmt.Errorf("failed to delete private data: %w", err)
+	}
+	versions, err := ps.privateStorage.GetVersions(ctx, key.ID, key.UserID, tx)
+	if err != nil {
+		rollback(tx)
+		return fmt.Errorf("failed to get versions: %w", err)
+	}
+	orphanBlobIDs, err := ps.deleteVersions(ctx, key.ID, key.UserID, versions, tx)
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	ps.releaseVersions(ctx, versions, orphanBlobIDs)
+	return nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	domain2 "gokeeper/pkg/domain"
+
+	"github.com/google/uuid"
+)
+
+// maxVaultKeySize bounds the opaque wrapped vault key accepted from clients.
+const maxVaultKeySize = 1024
+
+// GetVaultKey returns the user's vault key as wrapped by the client. The
+// server never sees the key in the clear.
+func (as *AuthService) GetVaultKey(ctx context.Context, userID uuid.UUID) ([]byte, error) {
+	return as.authStorage.GetVaultKey(ctx, userID)
+}
+
+// InitVaultKey stores the wrapped vault key of a user that has none yet.
+func (as *AuthService) InitVaultKey(ctx context.Context, userID uuid.UUID, wrappedKey []byte) error {
+	if len(wrappedKey) == 0 || len(wrappedKey) > maxVaultKeySize {
+		return domain2.ErrPrivateDataBadFormat
+	}
+
+	tx, err := as.authStorage.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = as.authStorage.SetVaultKey(ctx, userID, wrappedKey, tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
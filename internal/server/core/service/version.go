@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"gokeeper/internal/server/adapters/storage/database"
+	domain2 "gokeeper/pkg/domain"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetentionPolicy limits the versions kept of each record. It is applied
+// whenever a record is saved, and the current version is always kept.
+type RetentionPolicy struct {
+	// MaxVersions is the number of versions kept, 0 keeps all of them.
+	MaxVersions int
+	// MaxAge drops versions saved longer ago, 0 keeps them regardless of
+	// their age.
+	MaxAge time.Duration
+}
+
+// expired returns the versions the policy no longer keeps. versions are
+// ordered oldest first and end with the current one.
+func (rp RetentionPolicy) expired(versions []domain2.Data, now time.Time) []domain2.Data {
+	if len(versions) == 0 {
+		return nil
+	}
+	var expired []domain2.Data
+	for i, version := range versions[:len(versions)-1] {
+		tooMany := rp.MaxVersions > 0 && len(versions)-i > rp.MaxVersions
+		tooOld := rp.MaxAge > 0 && now.Sub(version.SavedAt) > rp.MaxAge
+		if tooMany || tooOld {
+			expired = append(expired, version)
+		}
+	}
+	return expired
+}
+
+// GetVersions returns the versions of a record without their payloads,
+// oldest first.
+func (ps *PrivateService) GetVersions(ctx context.Context, id string, userID uuid.UUID) ([]domain2.Data, error) {
+	tx, err := ps.privateStorage.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	versions, err := ps.privateStorage.GetVersions(ctx, id, userID, tx)
+	if err != nil {
+		rollback(tx)
+		return nil, fmt.Errorf("failed to get versions: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if len(versions) == 0 {
+		return nil, domain2.ErrPrivateDataNotFound
+	}
+	return versions, nil
+}
+
+func (ps *PrivateService) GetVersion(ctx context.Context, id string, userID uuid.UUID, version int64) (*domain2.Data, error) {
+	tx, err := ps.privateStorage.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	pd, err := ps.privateStorage.GetVersion(ctx, id, userID, version, tx)
+	if err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if err = ps.loadPayload(ctx, pd); err != nil {
+		return nil, err
+	}
+	return pd, nil
+}
+
+// RestoreVersion makes a copy of an earlier version the current one and
+// returns the record without its payload. The restored record is dated now,
+// so that clients take it as the latest change. Restoring a version of a
+// deleted record takes it out of the trash. Like a save, it has to name the
+// current revision of the record.
+func (ps *PrivateService) RestoreVersion(
+	ctx context.Context,
+	id string,
+	userID uuid.UUID,
+	version int64,
+	revision int64,
+) (*domain2.Data, error) {
+	tx, err := ps.privateStorage.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err = ps.lockUser(ctx, userID, tx); err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	existingPrivateData, err := ps.privateStorage.GetByID(ctx, id, userID, tx)
+	if err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	if err = checkRevision(existingPrivateData, revision); err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	pd, err := ps.privateStorage.GetVersion(ctx, id, userID, version, tx)
+	if err != nil {
+		rollback(tx)
+		return nil, err
+	}
+
+	pd.SavedAt = time.Now()
+	if existingPrivateData.SavedAt.After(pd.SavedAt) {
+		pd.SavedAt = existingPrivateData.SavedAt
+	}
+	if existingPrivateData.DeletedAt != nil && existingPrivateData.DeletedAt.After(pd.SavedAt) {
+		pd.SavedAt = *existingPrivateData.DeletedAt
+	}
+	pruned, orphanBlobIDs, err := ps.writeVersion(ctx, pd, existingPrivateData, userID, tx)
+	if err != nil {
+		rollback(tx)
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	ps.releaseVersions(ctx, pruned, orphanBlobIDs)
+
+	pd.Data = nil
+	return pd, nil
+}
+
+// writeVersion stores pd as the new current version of the record, at the
+// next revision of the user, and deletes the versions the retention policy no
+// longer keeps. It returns the
+// deleted versions and the blobs nothing refers to anymore, which have to be
+// passed to releaseVersions once the transaction is committed.
+func (ps *PrivateService) writeVersion(
+	ctx context.Context,
+	pd *domain2.Data,
+	existingPrivateData *domain2.Data,
+	userID uuid.UUID,
+	tx *database.Trx,
+) ([]domain2.Data, []string, error) {
+	revision, err := ps.privateStorage.NextRevision(ctx, userID, tx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get next revision: %w", err)
+	}
+	pd.Revision = revision
+	pd.Version = 1
+	if existingPrivateData != nil {
+		pd.Version = existingPrivateData.Version + 1
+	}
+	if err = ps.privateStorage.InsertOrUpdate(ctx, pd, userID, tx); err != nil {
+		return nil, nil, fmt.Errorf("failed to save private data: %w", err)
+	}
+	if err = ps.privateStorage.InsertVersion(ctx, pd, userID, tx); err != nil {
+		return nil, nil, fmt.Errorf("failed to save version: %w", err)
+	}
+
+	versions, err := ps.privateStorage.GetVersions(ctx, pd.ID, userID, tx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get versions: %w", err)
+	}
+	pruned := ps.retention.expired(versions, time.Now())
+	orphanBlobIDs, err := ps.deleteVersions(ctx, pd.ID, userID, pruned, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pruned, orphanBlobIDs, nil
+}
+
+// deleteVersions deletes the versions together with the blobs nothing else
+// refers to, and returns the IDs of these blobs.
+func (ps *PrivateService) deleteVersions(
+	ctx context.Context,
+	id string,
+	userID uuid.UUID,
+	versions []domain2.Data,
+	tx *database.Trx,
+) ([]string, error) {
+	for _, version := range versions {
+		if err := ps.privateStorage.DeleteVersion(ctx, id, userID, version.Version, tx); err != nil {
+			return nil, err
+		}
+	}
+
+	var orphanBlobIDs []string
+	seen := make(map[string]bool)
+	for _, version := range versions {
+		if version.BlobID == "" || seen[version.BlobID] {
+			continue
+		}
+		seen[version.BlobID] = true
+
+		referenced, err := ps.privateStorage.IsBlobReferenced(ctx, version.BlobID, tx)
+		if err != nil {
+			return nil, err
+		}
+		if referenced {
+			continue
+		}
+		if err = ps.blobs.deleteBlob(ctx, version.BlobID, tx); err != nil {
+			return nil, err
+		}
+		orphanBlobIDs = append(orphanBlobIDs, version.BlobID)
+	}
+	return orphanBlobIDs, nil
+}
+
+// releaseVersions removes what deleted versions left behind once their
+// deletion is committed: the chunks of orphaned blobs and payloads nothing
+// refers to anymore.
+func (ps *PrivateService) releaseVersions(ctx context.Context, versions []domain2.Data, orphanBlobIDs []string) {
+	for _, blobID := range orphanBlobIDs {
+		ps.blobs.removeChunks(ctx, blobID)
+	}
+	seen := make(map[string]bool)
+	for _, version := range versions {
+		if version.PayloadKey != "" && !seen[version.PayloadKey] {
+			seen[version.PayloadKey] = true
+			ps.dropPayload(ctx, version.PayloadKey)
+		}
+	}
+}
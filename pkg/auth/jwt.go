@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"time"
 
@@ -9,33 +12,93 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	// RefreshTokenHeader is the response header carrying the refresh token.
+	RefreshTokenHeader = "X-Refresh-Token"
+	// MFATokenHeader is the response header carrying the MFA pending token.
+	MFATokenHeader = "X-MFA-Token"
+)
+
+const (
+	refreshTokenLen = 32
+	mfaTokenExp     = 5 * time.Minute
+	purposeMFA      = "mfa"
+)
+
 type Authenticator struct {
-	secretKey string
-	tokenExp  time.Duration
+	secretKey       string
+	tokenExp        time.Duration
+	refreshTokenExp time.Duration
 }
 
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID uuid.UUID
-	Login  string
+	UserID    uuid.UUID
+	Login     string
+	SessionID uuid.UUID
+	// Purpose is empty for access tokens. Tokens with any other purpose are
+	// rejected by ParseClaims.
+	Purpose string `json:",omitempty"`
 }
 
 type Token string
 
-func NewAuthJWT(secretKey string, tokenExp time.Duration) *Authenticator {
+// TokenPair is a short-lived access token and the opaque refresh token used
+// to obtain the next pair. When a login needs a second factor only MFAToken
+// is set.
+type TokenPair struct {
+	AccessToken  Token
+	RefreshToken string
+	MFAToken     Token
+}
+
+func NewAuthJWT(secretKey string, tokenExp time.Duration, refreshTokenExp time.Duration) *Authenticator {
 	return &Authenticator{
-		secretKey: secretKey,
-		tokenExp:  tokenExp,
+		secretKey:       secretKey,
+		tokenExp:        tokenExp,
+		refreshTokenExp: refreshTokenExp,
 	}
 }
 
-func (a *Authenticator) MakeJWT(ID uuid.UUID, login string) (Token, error) {
+// TokenExp returns the lifetime of access tokens.
+func (a *Authenticator) TokenExp() time.Duration {
+	return a.tokenExp
+}
+
+// RefreshTokenExp returns the lifetime of refresh tokens.
+func (a *Authenticator) RefreshTokenExp() time.Duration {
+	return a.refreshTokenExp
+}
+
+// MakeRefreshToken returns a random opaque refresh token.
+func (a *Authenticator) MakeRefreshToken() (string, error) {
+	buf := make([]byte, refreshTokenLen)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashRefreshToken returns the digest under which a refresh token is stored.
+// Refresh tokens are random, so a plain hash is sufficient.
+func HashRefreshToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// MakeJWT issues an access token. sessionID ties the token to the refresh
+// token family it was issued with, so that both can be revoked together.
+func (a *Authenticator) MakeJWT(ID uuid.UUID, login string, sessionID uuid.UUID) (Token, error) {
+	now := time.Now().UTC()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(a.tokenExp)),
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.tokenExp)),
 		},
-		UserID: ID,
-		Login:  login,
+		UserID:    ID,
+		Login:     login,
+		SessionID: sessionID,
 	})
 
 	tokenString, err := token.SignedString([]byte(a.secretKey))
@@ -47,6 +110,61 @@ func (a *Authenticator) MakeJWT(ID uuid.UUID, login string) (Token, error) {
 }
 
 func (a *Authenticator) GetUserID(tokenStr string) (uuid.UUID, error) {
+	claims, err := a.ParseClaims(tokenStr)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return claims.UserID, nil
+}
+
+// MakeMFAToken issues a short-lived token proving that the first login step
+// succeeded. It is only accepted by ParseMFAToken.
+func (a *Authenticator) MakeMFAToken(ID uuid.UUID, login string) (Token, error) {
+	now := time.Now().UTC()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaTokenExp)),
+		},
+		UserID:  ID,
+		Login:   login,
+		Purpose: purposeMFA,
+	})
+
+	tokenString, err := token.SignedString([]byte(a.secretKey))
+	if err != nil {
+		return "", err
+	}
+
+	return Token(tokenString), nil
+}
+
+// ParseMFAToken verifies an MFA pending token and returns its claims.
+func (a *Authenticator) ParseMFAToken(tokenStr string) (*Claims, error) {
+	claims, err := a.parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purposeMFA {
+		return nil, errors.New("not an mfa token")
+	}
+	return claims, nil
+}
+
+// ParseClaims verifies an access token and returns its claims.
+func (a *Authenticator) ParseClaims(tokenStr string) (*Claims, error) {
+	claims, err := a.parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("not an access token")
+	}
+	return claims, nil
+}
+
+func (a *Authenticator) parse(tokenStr string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -55,12 +173,12 @@ func (a *Authenticator) GetUserID(tokenStr string) (uuid.UUID, error) {
 		return []byte(a.secretKey), nil
 	})
 	if err != nil {
-		return uuid.UUID{}, errors.New("auth error")
+		return nil, errors.New("auth error")
 	}
 
 	if !token.Valid {
-		return uuid.UUID{}, errors.New("token is invalid")
+		return nil, errors.New("token is invalid")
 	}
 
-	return claims.UserID, nil
+	return claims, nil
 }
@@ -1,6 +1,10 @@
 package domain
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type InUserRequest struct {
 	Login    string `json:"login"`
@@ -11,4 +15,51 @@ type User struct {
 	ID           uuid.UUID `json:"uid"`
 	Login        string    `json:"login"`
 	PasswordHash []byte    `json:"-"`
+	TOTPSecret   []byte    `json:"-"`
+	TOTPEnabled  bool      `json:"-"`
+	TOTPLastStep int64     `json:"-"`
+	// MFAFailures counts the second factor checks that did not succeed
+	// since the last one that did; MFALockedUntil is when the next check
+	// may be made once there were too many.
+	MFAFailures    int        `json:"-"`
+	MFALockedUntil *time.Time `json:"-"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	FamilyID  uuid.UUID
+	TokenHash []byte
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPVerifyRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+	// VaultKey is the vault key re-wrapped with the new password.
+	VaultKey []byte `json:"vault_key,omitempty"`
 }
@@ -0,0 +1,49 @@
+package domain
+
+// MaxBatchOps is the largest number of operations the server accepts in one
+// batch.
+const MaxBatchOps = 100
+
+// BatchOp is one operation of a batch: either Save or Delete is set.
+type BatchOp struct {
+	Save   *Data          `json:"save,omitempty"`
+	Delete *DeleteRequest `json:"delete,omitempty"`
+}
+
+// ID returns the id of the record the operation changes.
+func (op BatchOp) ID() string {
+	if op.Delete != nil {
+		return op.Delete.ID
+	}
+	if op.Save != nil {
+		return op.Save.ID
+	}
+	return ""
+}
+
+// BatchRequest saves and deletes records at once. By default the operations
+// are applied in one transaction, so either all of them take effect or none
+// does. In best effort mode each is applied on its own. The revision an
+// operation is based on is given in it rather than in If-Match.
+type BatchRequest struct {
+	Ops        []BatchOp `json:"ops"`
+	BestEffort bool      `json:"best_effort,omitempty"`
+}
+
+// BatchResult is the outcome of an operation as an HTTP status code, in the
+// order of the request.
+type BatchResult struct {
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// Capabilities describes optional features the server supports. MaxBatch is
+// zero if the server has no batch endpoint.
+type Capabilities struct {
+	MaxBatch int `json:"max_batch,omitempty"`
+}
@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Blob is a binary payload uploaded in numbered chunks of ChunkSize bytes;
+// only the last chunk may be shorter. A blob can be referenced by a record
+// once it is completed.
+type Blob struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Size      int64
+	ChunkSize int64
+	Checksum  []byte
+	Completed bool
+	CreatedAt time.Time
+}
+
+// Chunks returns the number of chunks of the blob.
+func (b Blob) Chunks() int {
+	return int((b.Size + b.ChunkSize - 1) / b.ChunkSize)
+}
+
+// ChunkLen returns the size of the chunk with the given index.
+func (b Blob) ChunkLen(index int) int64 {
+	return min(b.ChunkSize, b.Size-int64(index)*b.ChunkSize)
+}
+
+type BlobInitRequest struct {
+	Size      int64 `json:"size"`
+	ChunkSize int64 `json:"chunk_size"`
+}
+
+type BlobStatus struct {
+	ID        string `json:"id"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	Chunks    int    `json:"chunks"`
+	Received  []int  `json:"received"`
+	Completed bool   `json:"completed"`
+}
+
+type BlobCompleteRequest struct {
+	SHA256 string `json:"sha256"`
+}
+
+// BlobUpload is an upload the client has not finished yet. Spool is the local
+// file holding the encrypted payload, and Record is saved referencing the blob
+// once it is completed.
+type BlobUpload struct {
+	Record Data   `json:"record"`
+	BlobID string `json:"blob_id,omitempty"`
+	Spool  string `json:"spool"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
@@ -1,17 +1,68 @@
 package domain
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
 	ErrUserNotFound       = errors.New("user not found")
 	ErrUserAuthentication = errors.New("user unauthorized")
 	ErrUserConflict       = errors.New("user already exists")
+	ErrWrongPassword      = errors.New("wrong password")
+
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrMFARequired          = errors.New("two-factor authentication required")
+	ErrMFAAlreadyEnabled    = errors.New("two-factor authentication already enabled")
+	ErrMFANotEnrolled       = errors.New("two-factor authentication not enrolled")
+
+	ErrVaultKeyNotFound = errors.New("vault key not found")
+	ErrVaultKeyConflict = errors.New("vault key already exists")
 
 	ErrPrivateDataBadFormat = errors.New("private data bad format")
 	ErrPrivateDataNotFound  = errors.New("private data not found")
 	ErrPrivateDataConflict  = errors.New("private data conflict")
+	ErrVersionNotFound      = errors.New("version not found")
+	ErrBadCursor            = errors.New("invalid page cursor")
+	ErrRevisionMismatch     = errors.New("record was changed since the given revision")
+	ErrRevisionRequired     = errors.New("revision of the record is required")
+	ErrPrivateDataDeleted   = errors.New("record is in the trash")
+	ErrLegacyEncryption     = errors.New("record is encrypted in a legacy format")
+
+	ErrBlobNotFound         = errors.New("blob not found")
+	ErrBlobIncomplete       = errors.New("blob upload is not complete")
+	ErrBlobCompleted        = errors.New("blob upload is already complete")
+	ErrBlobChunkInvalid     = errors.New("invalid blob chunk")
+	ErrBlobChecksumMismatch = errors.New("blob checksum mismatch")
+	ErrBlobTooLarge         = errors.New("blob too large")
+	ErrBlobQuotaExceeded    = errors.New("blob storage quota exceeded")
+
+	ErrPayloadNotFound = errors.New("payload not found")
 
+	ErrReplicaNotFound    = errors.New("local replica not found")
+	ErrInsecureFile       = errors.New("file is accessible to other users")
+	ErrQueueEntryNotFound = errors.New("queue entry not found")
+
+	ErrBatchAborted = errors.New("batch aborted by another operation")
+
+	ErrTooManyRequests     = errors.New("too many requests")
 	ErrInternalServerError = errors.New("internal server error")
+	ErrServerUnavailable   = errors.New("server temporarily unavailable")
 	ErrJWTTokenError       = errors.New("jwt token error")
 	WarnServerUnavailable  = errors.New("server unavailable")
 )
+
+// TooManyRequestsError is returned when the server throttles the client. It
+// matches ErrTooManyRequests. RetryAfter is the pause the server asked for,
+// zero if it did not say.
+type TooManyRequestsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return ErrTooManyRequests.Error()
+}
+
+func (e *TooManyRequestsError) Unwrap() error {
+	return ErrTooManyRequests
+}
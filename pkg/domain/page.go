@@ -0,0 +1,113 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Order is the order records are listed in. Records with the same sort value
+// are ordered by id, so that the order is total and pages are stable.
+type Order string
+
+const (
+	OrderByID      Order = "id"
+	OrderBySavedAt Order = "saved_at"
+	OrderByType    Order = "type"
+)
+
+// ParseOrder returns the order of the given name, ordering by id if it is
+// empty.
+func ParseOrder(name string) (Order, error) {
+	switch order := Order(name); order {
+	case "":
+		return OrderByID, nil
+	case OrderByID, OrderBySavedAt, OrderByType:
+		return order, nil
+	default:
+		return "", fmt.Errorf("unknown order %q", name)
+	}
+}
+
+// Page is a page of the user's records.
+type Page struct {
+	Records []Data `json:"records"`
+	// NextCursor asks for the page after this one. It is empty on the last
+	// page.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Total is the number of records matching the filters on all pages.
+	Total int64 `json:"total"`
+}
+
+// PageKey is the position of a record in a listing. A page starts after the
+// key of the last record of the page before.
+type PageKey struct {
+	Order   Order     `json:"o"`
+	SavedAt time.Time `json:"s"`
+	Type    string    `json:"t,omitempty"`
+	ID      string    `json:"i"`
+}
+
+// KeyOf returns the position of pd when listed in order.
+func KeyOf(pd Data, order Order) PageKey {
+	key := PageKey{Order: order, ID: pd.ID}
+	switch order {
+	case OrderBySavedAt:
+		key.SavedAt = pd.SavedAt
+	case OrderByType:
+		key.Type = pd.DataType.String()
+	}
+	return key
+}
+
+// Less reports whether the record at k is listed before the one at other.
+func (k PageKey) Less(other PageKey) bool {
+	switch k.Order {
+	case OrderBySavedAt:
+		if !k.SavedAt.Equal(other.SavedAt) {
+			return k.SavedAt.Before(other.SavedAt)
+		}
+	case OrderByType:
+		if k.Type != other.Type {
+			return k.Type < other.Type
+		}
+	}
+	return k.ID < other.ID
+}
+
+// Cursor encodes the key as the opaque cursor handed to clients.
+func (k PageKey) Cursor() string {
+	data, _ := json.Marshal(k)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes a cursor of a listing in order.
+func ParseCursor(cursor string, order Order) (*PageKey, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var key PageKey
+	if err = json.Unmarshal(data, &key); err != nil || key.Order != order || key.ID == "" {
+		return nil, ErrBadCursor
+	}
+	return &key, nil
+}
+
+// Matches reports whether pd passes the filters of the request. The cursor
+// is not taken into account.
+func (r *GetAllRequest) Matches(pd Data) bool {
+	if r.Type != nil && pd.DataType != *r.Type {
+		return false
+	}
+	if !strings.HasPrefix(pd.ID, r.IDPrefix) {
+		return false
+	}
+	if r.MetaToken != "" && !slices.Contains(pd.MetaIndex, r.MetaToken) {
+		return false
+	}
+	return true
+}
@@ -1,11 +1,13 @@
 package domain
 
 import (
-	"bytes"
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type Type int32
@@ -33,6 +35,32 @@ func (t Type) MarshalJSON() ([]byte, error) {
 	}
 }
 
+// String returns the name of the type as it is stored and sent.
+func (t Type) String() string {
+	switch t {
+	case LOGIN_PASSWORD:
+		return "LOGIN_PASSWORD"
+	case TEXT:
+		return "TEXT"
+	case BYTES:
+		return "BYTES"
+	case CARD:
+		return "CARD"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseType returns the type of the given name, as returned by String.
+func ParseType(name string) (Type, error) {
+	for t := LOGIN_PASSWORD; t < UNKNOWN; t++ {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return UNKNOWN, fmt.Errorf("unknown data type %q", name)
+}
+
 func (t Type) Value() (driver.Value, error) {
 	switch t {
 	case LOGIN_PASSWORD:
@@ -73,40 +101,102 @@ func (t *Type) Scan(value interface{}) error {
 	return nil
 }
 
+// UnmarshalJSON reads a type by its name. An unknown name is rejected rather
+// than read as some other type.
 func (t *Type) UnmarshalJSON(data []byte) error {
-	fmt.Println("data", string(data))
-	switch {
-	case bytes.Equal(data, []byte("\"LOGIN_PASSWORD\"")):
-		*t = LOGIN_PASSWORD
-	case bytes.Equal(data, []byte("\"TEXT\"")):
-		*t = TEXT
-	case bytes.Equal(data, []byte("\"BYTES\"")):
-		*t = BYTES
-	case bytes.Equal(data, []byte("\"CARD\"")):
-		*t = CARD
-	default:
-		*t = LOGIN_PASSWORD
-		//return ErrPrivateDataBadFormat
+	if string(data) == "null" {
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("%w: %w", ErrPrivateDataBadFormat, err)
+	}
+	parsed, err := ParseType(name)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPrivateDataBadFormat, err)
 	}
+	*t = parsed
 	return nil
 }
 
 type Data struct {
-	ID       string    `json:"id"`
-	DataType Type      `json:"type"`
-	MetaData []byte    `json:"meta"`
-	Data     []byte    `json:"data"`
-	SavedAt  time.Time `json:"saved_at"`
+	ID       string `json:"id"`
+	DataType Type   `json:"type"`
+	MetaData []byte `json:"meta"`
+	// MetaIndex holds blind index tokens of encrypted metadata words, which
+	// let the server filter records without learning the metadata.
+	MetaIndex []string `json:"meta_index,omitempty"`
+	Data      []byte   `json:"data"`
+	// BlobID references an uploaded blob holding the payload instead of Data.
+	BlobID string `json:"blob_id,omitempty"`
+	// PayloadKey references the payload in the server's blob store when it
+	// was too large to be kept in the database. It never leaves the server.
+	PayloadKey string    `json:"-"`
+	SavedAt    time.Time `json:"saved_at"`
+	// Version numbers the saved states of the record, starting at 1. It is
+	// assigned by the server.
+	Version int64 `json:"version,omitempty"`
+	// DeletedAt is set while the record is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Revision orders the changes of the user's records. Every change of a
+	// record gives it the next revision of the user. In a change sent to the
+	// server it is the revision the change is based on.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// RecordKey identifies a record of a user.
+type RecordKey struct {
+	UserID uuid.UUID
+	ID     string
 }
 
 type DeleteRequest struct {
 	ID        string    `json:"id"`
 	DeletedAt time.Time `json:"deleted_at"`
+	// Revision is the revision of the record the deletion is based on.
+	Revision int64 `json:"revision,omitempty"`
 }
 
+// GetAllRequest asks for a page of the user's records.
 type GetAllRequest struct {
-	Limit  uint64 `json:"limit"`
-	Offset uint64 `json:"offset"`
+	Limit uint64 `json:"limit"`
+	// Cursor is the NextCursor of the previous page, empty for the first
+	// one. It is only valid with the same order.
+	Cursor string `json:"cursor,omitempty"`
+	Order  Order  `json:"order,omitempty"`
+	// Type, if set, limits the result to records of the type.
+	Type *Type `json:"type,omitempty"`
+	// IDPrefix limits the result to records whose id starts with it.
+	IDPrefix string `json:"id_prefix,omitempty"`
+	// MetaToken, if set, limits the result to records whose MetaIndex
+	// contains it.
+	MetaToken string `json:"meta_token"`
+	// Search is a metadata word to filter by. It never leaves the client,
+	// which sends its blind index token as MetaToken instead.
+	Search string `json:"-"`
+	// After is the decoded Cursor, which the server lists from.
+	After *PageKey `json:"-"`
+}
+
+// ChangesRequest asks for the changes of the user's records made after the
+// revision Since.
+type ChangesRequest struct {
+	Since int64
+	Limit uint64
+}
+
+// Changes lists changed records in the order they were changed. Deleted
+// records are included as tombstones, which carry DeletedAt but no payload.
+type Changes struct {
+	Changes []Data `json:"changes"`
+	// Cursor is the revision to ask for the next changes since.
+	Cursor  int64 `json:"cursor"`
+	HasMore bool  `json:"has_more"`
+	// Reset is set when the changes since the requested revision are no
+	// longer known, because deleted records were purged in the meantime.
+	// The changes then start over from the first revision, and the client
+	// has to drop the records it does not receive.
+	Reset bool `json:"reset,omitempty"`
 }
 
 type LoginPasswordData struct {
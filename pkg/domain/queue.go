@@ -0,0 +1,49 @@
+package domain
+
+import "time"
+
+// QueueOp is the kind of a change in the offline queue.
+type QueueOp string
+
+const (
+	QueueSave   QueueOp = "save"
+	QueueDelete QueueOp = "delete"
+)
+
+// QueueEntry is a change made while the server was unavailable, waiting in
+// the offline queue to be sent. Data of a delete only holds the id, the time
+// of the deletion and the revision the change is based on.
+type QueueEntry struct {
+	ID       int64     `json:"id"`
+	Op       QueueOp   `json:"op"`
+	Data     Data      `json:"data"`
+	QueuedAt time.Time `json:"queued_at"`
+	// Attempts counts the failed attempts to send the change, and LastError
+	// holds the error of the latest one.
+	Attempts  int    `json:"attempts,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// ChangedAt returns when the change was made.
+func (e QueueEntry) ChangedAt() time.Time {
+	if e.Op == QueueDelete && e.Data.DeletedAt != nil {
+		return *e.Data.DeletedAt
+	}
+	return e.Data.SavedAt
+}
+
+// DeleteRequest returns the request that sends a delete.
+func (e QueueEntry) DeleteRequest() DeleteRequest {
+	return DeleteRequest{ID: e.Data.ID, DeletedAt: e.ChangedAt(), Revision: e.Data.Revision}
+}
+
+// UploadReport sums up what an upload of the offline queue did.
+type UploadReport struct {
+	// Changes are the queued changes to send, the latest of each record.
+	Changes []QueueEntry
+	// Succeeded are the changes the server acknowledged.
+	Succeeded []QueueEntry
+	// Failed are the changes that could not be sent, with their error in
+	// LastError. The changes neither succeeded nor failed were not tried.
+	Failed []QueueEntry
+}
@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// ConflictPolicy decides which change is kept when a record was changed both
+// locally and on the server since the last sync.
+type ConflictPolicy string
+
+const (
+	// ServerWins drops the local change.
+	ServerWins ConflictPolicy = "server-wins"
+	// LocalWins overwrites the server's change with the local one.
+	LocalWins ConflictPolicy = "local-wins"
+	// KeepBoth saves the local change as a new record with a suffixed id.
+	KeepBoth ConflictPolicy = "keep-both"
+	// Prompt asks the user for each conflict.
+	Prompt ConflictPolicy = "prompt"
+)
+
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(name); policy {
+	case ServerWins, LocalWins, KeepBoth, Prompt:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q", name)
+	}
+}
+
+// Replica is the client's local copy of the user's records as the server had
+// them at the revision Cursor. Records are kept encrypted as they were
+// received, and deleted ones as tombstones. Records fetched between syncs are
+// kept as well, so they may be newer than Cursor.
+type Replica struct {
+	Cursor   int64           `json:"cursor"`
+	SyncedAt time.Time       `json:"synced_at"`
+	Records  map[string]Data `json:"records"`
+}
+
+// StaleDataWarning is returned together with records read from the local
+// replica instead of the server. They are at least as recent as SyncedAt,
+// which is zero if the replica was never synced.
+type StaleDataWarning struct {
+	SyncedAt time.Time
+}
+
+func (w *StaleDataWarning) Error() string {
+	if w.SyncedAt.IsZero() {
+		return "showing the local copy of records fetched earlier, it was never synced with the server"
+	}
+	return "showing the local copy of records, last synced with the server at " + w.SyncedAt.Local().Format(time.DateTime)
+}
+
+// SyncConflict is a local change of a record the server changed as well. The
+// metadata of both is decrypted.
+type SyncConflict struct {
+	Local  Data
+	Server Data
+}
+
+// SyncReport sums up what a sync did.
+type SyncReport struct {
+	// Pulled is the number of changes received from the server.
+	Pulled int
+	// Pushed is the number of local changes sent to the server.
+	Pushed int
+	// Conflicts is the number of local changes that conflicted with the
+	// server's.
+	Conflicts int
+	// Discarded is the number of local changes dropped in favour of the
+	// server's.
+	Discarded int
+	// Pending is the number of local changes left for the next sync.
+	Pending int
+}
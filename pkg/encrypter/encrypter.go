@@ -1,70 +1,299 @@
 package encrypter
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	formatVersion = 1
+
+	saltLen  = 16
+	keyLen   = 32
+	keyIDLen = 8
+
+	// keyHeaderLen is the length of the header written by appendKeyHeader.
+	keyHeaderLen = 3 + 1 + 1 + saltLen + keyIDLen
 )
 
-type Encrypter struct{}
+// magic prefixes every message produced by EncryptMessage. Messages without
+// it are in the baseline nonce||ciphertext format.
+var magic = []byte("GKE")
+
+// messageKeyInfo separates the message keys derived from a key by HKDF from
+// any other use of that key.
+var messageKeyInfo = []byte("gokeeper message key")
+
+var (
+	ErrCiphertextTooShort = errors.New("ciphertext too short")
+	ErrUnsupportedFormat  = errors.New("unsupported ciphertext format")
+	ErrKeyMismatch        = errors.New("message was encrypted with another key")
+	ErrLegacyFormat       = errors.New("message was encrypted in a legacy format")
+	ErrInvalidKey         = errors.New("invalid key length")
+)
+
+// Algorithm identifies the AEAD cipher of a message.
+type Algorithm byte
+
+const (
+	AES256GCM         Algorithm = 1
+	XChaCha20Poly1305 Algorithm = 2
+)
 
-func NewEncrypter() *Encrypter {
-	return &Encrypter{}
+// ParseAlgorithm maps a cipher name to its Algorithm.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch name {
+	case "aes-256-gcm":
+		return AES256GCM, nil
+	case "xchacha20-poly1305":
+		return XChaCha20Poly1305, nil
+	default:
+		return 0, fmt.Errorf("unknown cipher %q", name)
+	}
 }
 
-func generateKey(secrets []string) [32]byte {
-	var key [32]byte
-	for _, secret := range secrets {
-		byteSecret := append([]byte(secret), key[:]...)
-		key = sha256.Sum256(byteSecret)
+func (a Algorithm) newAEAD(key []byte) (cipher.AEAD, error) {
+	switch a {
+	case AES256GCM:
+		return newGCM(key)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, ErrUnsupportedFormat
 	}
-	return key
 }
 
-func (e *Encrypter) EncryptMessage(msg []byte, secrets ...string) ([]byte, error) {
-	key := generateKey(secrets)
+// KDFParams are the Argon2id costs used to derive keys from passwords and
+// other secrets. Memory is in KiB.
+type KDFParams struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
 
-	aesblock, err := aes.NewCipher(key[:])
+// DefaultKDFParams follow the second recommended option of RFC 9106.
+var DefaultKDFParams = KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// Encrypter seals messages with an AEAD cipher under a random 256-bit key,
+// such as a vault key. The key is already uniform, so every message is
+// encrypted with its own key derived from it and a random salt by
+// HKDF-SHA256. Every message is a self-describing envelope:
+//
+//	"GKE" | version(1) | cipher(1) | salt(16) | keyID(8) | nonce | ciphertext
+//
+// The key id is a check value of the message key, so a wrong key is rejected
+// without running the cipher. The whole header followed by the caller's
+// associated data is authenticated as additional data, so a message only
+// decrypts in the context it was encrypted for.
+//
+// Argon2id is only run to wrap keys with a password. Messages of the
+// baseline format, encrypted under a key hashed from the login and password
+// without a header, are only opened by DecryptBaselineMessage to migrate
+// them.
+type Encrypter struct {
+	params    KDFParams
+	algorithm Algorithm
+}
+
+func NewEncrypter(params KDFParams, algorithm Algorithm) *Encrypter {
+	return &Encrypter{params: params, algorithm: algorithm}
+}
+
+// EncryptMessage seals msg under key, which must be 32 random bytes. ad is
+// not encrypted but must be passed unchanged to DecryptMessage.
+func (e *Encrypter) EncryptMessage(msg, ad, key []byte) ([]byte, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+	key, err = messageKey(key, salt)
 	if err != nil {
 		return nil, err
 	}
+	header := e.appendKeyHeader(nil, magic, formatVersion, salt, key)
 
-	aesgcm, err := cipher.NewGCM(aesblock)
+	aead, err := e.algorithm.newAEAD(key)
 	if err != nil {
 		return nil, err
 	}
 
-	nonce := make([]byte, aesgcm.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
 	if _, err := rand.Read(nonce); err != nil {
 		return nil, err
 	}
 
-	ciphertext := aesgcm.Seal(nil, nonce, msg, nil)
-	return append(nonce, ciphertext...), nil
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, msg, associatedData(header, ad)), nil
 }
 
-func (e *Encrypter) DecryptMessage(msg []byte, secrets ...string) ([]byte, error) {
-	key := generateKey(secrets)
-
-	aesblock, err := aes.NewCipher(key[:])
+// DecryptMessage opens a message written by EncryptMessage, or a stream
+// written by EncryptWriter, under key. Messages in the baseline format are
+// rejected with ErrLegacyFormat.
+func (e *Encrypter) DecryptMessage(msg, ad, key []byte) ([]byte, error) {
+	if IsStream(msg) {
+		r, err := e.NewDecryptReader(bytes.NewReader(msg), ad, key)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+	if !bytes.HasPrefix(msg, magic) {
+		return nil, ErrLegacyFormat
+	}
+	h, err := parseKeyHeader(msg, magic, formatVersion)
 	if err != nil {
 		return nil, err
 	}
+	aead, err := h.open(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := msg[len(h.raw):]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrCiphertextTooShort
+	}
+	return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], associatedData(h.raw, ad))
+}
 
-	aesgcm, err := cipher.NewGCM(aesblock)
+// DecryptBaselineMessage opens a message of the baseline format encrypted
+// under a key hashed from secrets. Nothing binds it to the context it was
+// encrypted for, so whoever stores it can swap it for another one; it should
+// only be read to migrate it.
+func (e *Encrypter) DecryptBaselineMessage(msg []byte, secrets ...string) ([]byte, error) {
+	key := baselineKey(secrets)
+
+	aesgcm, err := newGCM(key[:])
 	if err != nil {
 		return nil, err
 	}
 
 	nonceSize := aesgcm.NonceSize()
 	if len(msg) < nonceSize {
-		return nil, errors.New("ciphertext too short")
+		return nil, ErrCiphertextTooShort
+	}
+	return aesgcm.Open(nil, msg[:nonceSize], msg[nonceSize:], nil)
+}
+
+// NeedsUpgrade reports whether msg is not sealed with the cipher
+// EncryptMessage currently uses, or not in its format at all.
+func (e *Encrypter) NeedsUpgrade(msg []byte) bool {
+	prefix, version := magic, byte(formatVersion)
+	if IsStream(msg) {
+		prefix, version = streamMagic, streamVersion
 	}
+	h, err := parseKeyHeader(msg, prefix, version)
+	return err != nil || h.algorithm != e.algorithm
+}
+
+type keyHeader struct {
+	raw       []byte
+	algorithm Algorithm
+	salt      []byte
+	keyID     []byte
+}
+
+// appendKeyHeader appends the header describing which salt the message key
+// was derived with and which cipher uses it:
+//
+//	prefix | version(1) | cipher(1) | salt(16) | keyID(8)
+func (e *Encrypter) appendKeyHeader(dst, prefix []byte, version byte, salt, key []byte) []byte {
+	dst = append(dst, prefix...)
+	dst = append(dst, version, byte(e.algorithm))
+	dst = append(dst, salt...)
+	return append(dst, keyCheckValue(key)...)
+}
+
+// parseKeyHeader parses a header written by appendKeyHeader with prefix and
+// version.
+func parseKeyHeader(msg, prefix []byte, version byte) (keyHeader, error) {
+	if !bytes.HasPrefix(msg, prefix) {
+		return keyHeader{}, ErrUnsupportedFormat
+	}
+	if len(msg) < keyHeaderLen {
+		return keyHeader{}, ErrCiphertextTooShort
+	}
+	if msg[3] != version {
+		return keyHeader{}, ErrUnsupportedFormat
+	}
+	return keyHeader{
+		raw:       msg[:keyHeaderLen],
+		algorithm: Algorithm(msg[4]),
+		salt:      msg[5 : 5+saltLen],
+		keyID:     msg[5+saltLen : keyHeaderLen],
+	}, nil
+}
 
-	nonce := msg[:nonceSize]
-	ciphertext := msg[nonceSize:]
+// open derives the message key of h from key and returns its cipher.
+func (h keyHeader) open(key []byte) (cipher.AEAD, error) {
+	key, err := messageKey(key, h.salt)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(keyCheckValue(key), h.keyID) {
+		return nil, ErrKeyMismatch
+	}
+	return h.algorithm.newAEAD(key)
+}
 
-	return aesgcm.Open(nil, nonce, ciphertext, nil)
+// associatedData joins the envelope header and the caller's associated data.
+func associatedData(header, ad []byte) []byte {
+	out := make([]byte, 0, len(header)+len(ad))
+	out = append(out, header...)
+	return append(out, ad...)
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// messageKey derives the key of a single message from key and the message's
+// salt.
+func messageKey(key, salt []byte) ([]byte, error) {
+	if len(key) != keyLen {
+		return nil, ErrInvalidKey
+	}
+	out := make([]byte, keyLen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, messageKeyInfo), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// keyCheckValue identifies a derived key without revealing it.
+func keyCheckValue(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("gokeeper key id"), key...))
+	return sum[:keyIDLen]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	aesblock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(aesblock)
+}
+
+// baselineKey is the unsalted iterated SHA-256 derivation of the baseline
+// format. It is only used for reading old messages.
+func baselineKey(secrets []string) [32]byte {
+	var key [32]byte
+	for _, secret := range secrets {
+		byteSecret := append([]byte(secret), key[:]...)
+		key = sha256.Sum256(byteSecret)
+	}
+	return key
 }
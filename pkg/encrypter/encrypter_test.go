@@ -0,0 +1,157 @@
+package encrypter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+var algorithms = []Algorithm{AES256GCM, XChaCha20Poly1305}
+
+func TestMessageRoundTrip(t *testing.T) {
+	key := newTestKey(t)
+	for _, algorithm := range algorithms {
+		e := NewEncrypter(testKDFParams, algorithm)
+		for _, msg := range [][]byte{nil, []byte("secret"), bytes.Repeat([]byte{0xa5}, 1<<16)} {
+			ct, err := e.EncryptMessage(msg, []byte("record a"), key)
+			if err != nil {
+				t.Fatalf("EncryptMessage: %v", err)
+			}
+			if !bytes.HasPrefix(ct, magic) || ct[4] != byte(algorithm) {
+				t.Fatalf("EncryptMessage wrote header %x", ct[:keyHeaderLen])
+			}
+			got, err := e.DecryptMessage(ct, []byte("record a"), key)
+			if err != nil {
+				t.Fatalf("DecryptMessage with algorithm %d: %v", algorithm, err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatalf("DecryptMessage with algorithm %d returned another message", algorithm)
+			}
+		}
+	}
+}
+
+func TestMessagesAreSalted(t *testing.T) {
+	e := NewEncrypter(testKDFParams, AES256GCM)
+	key := newTestKey(t)
+
+	first, err := e.EncryptMessage([]byte("secret"), nil, key)
+	if err != nil {
+		t.Fatalf("EncryptMessage: %v", err)
+	}
+	second, err := e.EncryptMessage([]byte("secret"), nil, key)
+	if err != nil {
+		t.Fatalf("EncryptMessage: %v", err)
+	}
+	if bytes.Equal(first[:keyHeaderLen], second[:keyHeaderLen]) {
+		t.Fatalf("two messages share their salt and key id")
+	}
+}
+
+func TestDecryptMessageRejects(t *testing.T) {
+	e := NewEncrypter(testKDFParams, AES256GCM)
+	key := newTestKey(t)
+	ct, err := e.EncryptMessage([]byte("secret"), []byte("record a"), key)
+	if err != nil {
+		t.Fatalf("EncryptMessage: %v", err)
+	}
+
+	modified := func(i int) []byte {
+		b := bytes.Clone(ct)
+		b[i] ^= 1
+		return b
+	}
+	tests := []struct {
+		name    string
+		msg     []byte
+		ad      string
+		key     []byte
+		wantErr error
+	}{
+		{"wrong key", ct, "record a", newTestKey(t), ErrKeyMismatch},
+		{"short key", ct, "record a", key[:16], ErrInvalidKey},
+		{"other associated data", ct, "record b", key, nil},
+		{"baseline format", ct[len(magic):], "record a", key, ErrLegacyFormat},
+		{"truncated header", ct[:keyHeaderLen-1], "record a", key, ErrCiphertextTooShort},
+		{"truncated nonce", ct[:keyHeaderLen+4], "record a", key, ErrCiphertextTooShort},
+		{"unknown version", modified(3), "record a", key, ErrUnsupportedFormat},
+		{"changed cipher", modified(4), "record a", key, nil},
+		{"changed salt", modified(5), "record a", key, ErrKeyMismatch},
+		{"changed key id", modified(keyHeaderLen - 1), "record a", key, ErrKeyMismatch},
+		{"changed nonce", modified(keyHeaderLen), "record a", key, nil},
+		{"changed ciphertext", modified(len(ct) - 1), "record a", key, nil},
+	}
+	for _, tt := range tests {
+		_, err := e.DecryptMessage(tt.msg, []byte(tt.ad), tt.key)
+		if err == nil {
+			t.Errorf("%s: DecryptMessage succeeded", tt.name)
+			continue
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: DecryptMessage = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDecryptBaselineMessage(t *testing.T) {
+	e := NewEncrypter(testKDFParams, AES256GCM)
+
+	// A baseline message is nonce||ciphertext under the hash of the secrets.
+	key := baselineKey([]string{"alice", "password"})
+	aesgcm, err := newGCM(key[:])
+	if err != nil {
+		t.Fatalf("newGCM: %v", err)
+	}
+	nonce := make([]byte, aesgcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	msg := aesgcm.Seal(bytes.Clone(nonce), nonce, []byte("secret"), nil)
+
+	got, err := e.DecryptBaselineMessage(msg, "alice", "password")
+	if err != nil || string(got) != "secret" {
+		t.Fatalf("DecryptBaselineMessage = %q, %v", got, err)
+	}
+	if _, err = e.DecryptBaselineMessage(msg, "alice", "passw0rd"); err == nil {
+		t.Fatalf("DecryptBaselineMessage with the wrong password succeeded")
+	}
+	if _, err = e.DecryptBaselineMessage(msg[:4], "alice", "password"); !errors.Is(err, ErrCiphertextTooShort) {
+		t.Fatalf("DecryptBaselineMessage of a short message = %v, want %v", err, ErrCiphertextTooShort)
+	}
+	if !e.NeedsUpgrade(msg) {
+		t.Fatalf("NeedsUpgrade of a baseline message = false")
+	}
+}
+
+func TestNeedsUpgrade(t *testing.T) {
+	key := newTestKey(t)
+	aes := NewEncrypter(testKDFParams, AES256GCM)
+	chacha := NewEncrypter(testKDFParams, XChaCha20Poly1305)
+
+	ct, err := aes.EncryptMessage([]byte("secret"), nil, key)
+	if err != nil {
+		t.Fatalf("EncryptMessage: %v", err)
+	}
+	if aes.NeedsUpgrade(ct) {
+		t.Errorf("NeedsUpgrade with the same cipher = true")
+	}
+	if !chacha.NeedsUpgrade(ct) {
+		t.Errorf("NeedsUpgrade with another cipher = false")
+	}
+	// Messages of the other cipher still decrypt.
+	if _, err = chacha.DecryptMessage(ct, nil, key); err != nil {
+		t.Errorf("DecryptMessage with another cipher: %v", err)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for name, want := range map[string]Algorithm{"aes-256-gcm": AES256GCM, "xchacha20-poly1305": XChaCha20Poly1305} {
+		if got, err := ParseAlgorithm(name); err != nil || got != want {
+			t.Errorf("ParseAlgorithm(%q) = %d, %v", name, got, err)
+		}
+	}
+	if _, err := ParseAlgorithm("rot13"); err == nil {
+		t.Errorf("ParseAlgorithm of an unknown cipher succeeded")
+	}
+}
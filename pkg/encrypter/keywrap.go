@@ -0,0 +1,100 @@
+package encrypter
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	wrapVersion   = 1
+	wrapHeaderLen = 1 + 4 + 4 + 1 + saltLen
+
+	// The Argon2id costs accepted from a wrapped key are bounded so that a
+	// tampered key can not exhaust the client's memory or stall it.
+	maxKDFTime    = 16
+	maxKDFMemory  = 1024 * 1024 // KiB
+	maxKDFThreads = 64
+)
+
+var (
+	ErrInvalidWrappedKey = errors.New("invalid wrapped key")
+	ErrInvalidKDFParams  = errors.New("KDF parameters out of bounds")
+)
+
+// WrapKey encrypts key with a key derived from password by Argon2id with the
+// Encrypter's KDF parameters. The result is self-contained:
+//
+//	version(1) | time(4) | memory(4) | threads(1) | salt(16) | nonce | ciphertext
+//
+// The header is authenticated as additional data.
+func (e *Encrypter) WrapKey(key []byte, password string) ([]byte, error) {
+	if !e.params.valid() {
+		return nil, ErrInvalidKDFParams
+	}
+	header := make([]byte, wrapHeaderLen)
+	header[0] = wrapVersion
+	binary.BigEndian.PutUint32(header[1:5], e.params.Time)
+	binary.BigEndian.PutUint32(header[5:9], e.params.Memory)
+	header[9] = e.params.Threads
+	if _, err := rand.Read(header[10:]); err != nil {
+		return nil, err
+	}
+
+	aesgcm, err := wrapCipher(header, password)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aesgcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	blob := append(header, nonce...)
+	return aesgcm.Seal(blob, nonce, key, header), nil
+}
+
+// UnwrapKey reverses WrapKey.
+func (e *Encrypter) UnwrapKey(blob []byte, password string) ([]byte, error) {
+	if len(blob) < wrapHeaderLen || blob[0] != wrapVersion {
+		return nil, ErrInvalidWrappedKey
+	}
+	header := blob[:wrapHeaderLen]
+
+	aesgcm, err := wrapCipher(header, password)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := blob[wrapHeaderLen:]
+	if len(rest) < aesgcm.NonceSize() {
+		return nil, ErrInvalidWrappedKey
+	}
+	return aesgcm.Open(nil, rest[:aesgcm.NonceSize()], rest[aesgcm.NonceSize():], header)
+}
+
+func wrapCipher(header []byte, password string) (cipher.AEAD, error) {
+	params := KDFParams{
+		Time:    binary.BigEndian.Uint32(header[1:5]),
+		Memory:  binary.BigEndian.Uint32(header[5:9]),
+		Threads: header[9],
+	}
+	if !params.valid() {
+		return nil, ErrInvalidWrappedKey
+	}
+
+	salt := header[10:wrapHeaderLen]
+	kek := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, keyLen)
+	return newGCM(kek)
+}
+
+// valid reports whether p is within the bounds UnwrapKey accepts.
+func (p KDFParams) valid() bool {
+	return p.Time != 0 && p.Time <= maxKDFTime &&
+		p.Memory <= maxKDFMemory &&
+		p.Threads != 0 && p.Threads <= maxKDFThreads
+}
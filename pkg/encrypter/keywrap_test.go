@@ -0,0 +1,101 @@
+package encrypter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// testKDFParams keep Argon2id cheap, since the tests only check the format.
+var testKDFParams = KDFParams{Time: 1, Memory: 64, Threads: 1}
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, keyLen)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	return key
+}
+
+func TestWrapKeyRoundTrip(t *testing.T) {
+	e := NewEncrypter(testKDFParams, AES256GCM)
+	key := newTestKey(t)
+
+	blob, err := e.WrapKey(key, "password")
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+	got, err := e.UnwrapKey(blob, "password")
+	if err != nil {
+		t.Fatalf("UnwrapKey: %v", err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatalf("UnwrapKey returned another key")
+	}
+
+	// The costs are read from the wrapped key, not from the Encrypter.
+	other := NewEncrypter(KDFParams{Time: 2, Memory: 128, Threads: 2}, AES256GCM)
+	if got, err = other.UnwrapKey(blob, "password"); err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("UnwrapKey with other costs: %v", err)
+	}
+}
+
+func TestUnwrapKeyRejects(t *testing.T) {
+	e := NewEncrypter(testKDFParams, AES256GCM)
+	blob, err := e.WrapKey(newTestKey(t), "password")
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+
+	modified := func(change func(b []byte)) []byte {
+		b := bytes.Clone(blob)
+		change(b)
+		return b
+	}
+	tests := []struct {
+		name     string
+		blob     []byte
+		password string
+		wantErr  error
+	}{
+		{"wrong password", blob, "passw0rd", nil},
+		{"empty", nil, "password", ErrInvalidWrappedKey},
+		{"truncated header", blob[:wrapHeaderLen-1], "password", ErrInvalidWrappedKey},
+		{"truncated nonce", blob[:wrapHeaderLen+4], "password", ErrInvalidWrappedKey},
+		{"unknown version", modified(func(b []byte) { b[0] = wrapVersion + 1 }), "password", ErrInvalidWrappedKey},
+		{"changed salt", modified(func(b []byte) { b[wrapHeaderLen-1] ^= 1 }), "password", nil},
+		{"changed costs", modified(func(b []byte) { binary.BigEndian.PutUint32(b[1:5], 2) }), "password", nil},
+		{"changed ciphertext", modified(func(b []byte) { b[len(b)-1] ^= 1 }), "password", nil},
+		{"zero time", modified(func(b []byte) { binary.BigEndian.PutUint32(b[1:5], 0) }), "password", ErrInvalidWrappedKey},
+		{"huge time", modified(func(b []byte) { binary.BigEndian.PutUint32(b[1:5], 1<<32-1) }), "password", ErrInvalidWrappedKey},
+		{"huge memory", modified(func(b []byte) { binary.BigEndian.PutUint32(b[5:9], maxKDFMemory+1) }), "password", ErrInvalidWrappedKey},
+		{"zero threads", modified(func(b []byte) { b[9] = 0 }), "password", ErrInvalidWrappedKey},
+		{"many threads", modified(func(b []byte) { b[9] = maxKDFThreads + 1 }), "password", ErrInvalidWrappedKey},
+	}
+	for _, tt := range tests {
+		_, err := e.UnwrapKey(tt.blob, tt.password)
+		if err == nil {
+			t.Errorf("%s: UnwrapKey succeeded", tt.name)
+			continue
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: UnwrapKey = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWrapKeyRejectsInvalidParams(t *testing.T) {
+	for _, params := range []KDFParams{
+		{Time: 0, Memory: 64, Threads: 1},
+		{Time: maxKDFTime + 1, Memory: 64, Threads: 1},
+		{Time: 1, Memory: maxKDFMemory + 1, Threads: 1},
+		{Time: 1, Memory: 64, Threads: 0},
+	} {
+		if _, err := NewEncrypter(params, AES256GCM).WrapKey(make([]byte, keyLen), "password"); !errors.Is(err, ErrInvalidKDFParams) {
+			t.Errorf("WrapKey with %+v = %v, want %v", params, err, ErrInvalidKDFParams)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package encrypter
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	streamVersion = 1
+
+	// segmentSize is the plaintext size of every segment but the last one.
+	segmentSize = 64 * 1024
+	// maxSegmentSize bounds the segment size accepted from a header.
+	maxSegmentSize = 16 * 1024 * 1024
+	// segmentNonceLen is the part of the nonce taken by the segment counter
+	// and the last segment flag.
+	segmentNonceLen = 4 + 1
+	maxSegments     = 1<<32 - 1
+)
+
+// streamMagic prefixes streams written by EncryptWriter.
+var streamMagic = []byte("GKS")
+
+var (
+	ErrStreamTruncated = errors.New("encrypted stream is truncated")
+	ErrStreamTooLong   = errors.New("encrypted stream is too long")
+	errWriterClosed    = errors.New("encrypt writer is closed")
+)
+
+// IsStream reports whether msg was written by an EncryptWriter.
+func IsStream(msg []byte) bool {
+	return bytes.HasPrefix(msg, streamMagic)
+}
+
+// EncryptWriter encrypts everything written to it in segments, so that
+// payloads of any size can be encrypted in constant memory. It follows the
+// STREAM construction:
+//
+//	"GKS" | version(1) | cipher(1) | salt(16) | keyID(8) | segmentSize(4) |
+//	noncePrefix | segments
+//
+// where the key is derived as for EncryptMessage. Each segment is sealed
+// with the nonce noncePrefix | counter(4) | last(1), so segments can not be
+// reordered, and the flag on the final segment reveals truncation. The header
+// followed by the caller's associated data is authenticated with every
+// segment.
+//
+// Close must be called to write the final segment. It does not close the
+// underlying writer.
+type EncryptWriter struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	ad      []byte
+	prefix  []byte
+	counter uint64
+
+	buf    []byte
+	out    []byte
+	closed bool
+	err    error
+}
+
+// NewEncryptWriter writes the stream header to dst and returns a writer that
+// encrypts to it under key, which must be 32 random bytes.
+func (e *Encrypter) NewEncryptWriter(dst io.Writer, ad, key []byte) (io.WriteCloser, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+	if key, err = messageKey(key, salt); err != nil {
+		return nil, err
+	}
+	aead, err := e.algorithm.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, aead.NonceSize()-segmentNonceLen)
+	if _, err = rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	header := e.appendKeyHeader(nil, streamMagic, streamVersion, salt, key)
+	header = binary.BigEndian.AppendUint32(header, segmentSize)
+	header = append(header, prefix...)
+	if _, err = dst.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &EncryptWriter{
+		dst:    dst,
+		aead:   aead,
+		ad:     associatedData(header, ad),
+		prefix: prefix,
+		buf:    make([]byte, 0, segmentSize),
+	}, nil
+}
+
+func (w *EncryptWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errWriterClosed
+	}
+
+	var n int
+	for len(p) > 0 {
+		// A full segment is only sealed once more data arrives, since the
+		// final segment has to be flagged as such.
+		if len(w.buf) == segmentSize {
+			if w.err = w.seal(false); w.err != nil {
+				return n, w.err
+			}
+		}
+		m := min(segmentSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close writes the final segment.
+func (w *EncryptWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err == nil {
+		w.err = w.seal(true)
+	}
+	return w.err
+}
+
+func (w *EncryptWriter) seal(last bool) error {
+	if w.counter > maxSegments {
+		return ErrStreamTooLong
+	}
+	nonce := segmentNonce(w.prefix, w.counter, last)
+	w.out = w.aead.Seal(w.out[:0], nonce, w.buf, w.ad)
+	if _, err := w.dst.Write(w.out); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.counter++
+	return nil
+}
+
+// DecryptReader decrypts a stream written by EncryptWriter. Read only
+// returns io.EOF after the final segment was authenticated, so a truncated
+// stream results in an error.
+type DecryptReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	ad      []byte
+	prefix  []byte
+	counter uint64
+
+	// segment holds one encrypted segment plus one byte read ahead to tell
+	// whether the segment is the final one.
+	segment []byte
+	carry   int
+	plain   []byte
+	pos     int
+	done    bool
+}
+
+// NewDecryptReader reads the stream header from src and returns a reader of
+// the plaintext.
+func (e *Encrypter) NewDecryptReader(src io.Reader, ad, key []byte) (io.Reader, error) {
+	header := make([]byte, keyHeaderLen)
+	if err := readHeader(src, header); err != nil {
+		return nil, err
+	}
+	h, err := parseKeyHeader(header, streamMagic, streamVersion)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := h.open(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixLen := aead.NonceSize() - segmentNonceLen
+	if header, err = readMore(src, header, 4+prefixLen); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[len(header)-4-prefixLen:])
+	if size == 0 || size > maxSegmentSize {
+		return nil, ErrUnsupportedFormat
+	}
+
+	return &DecryptReader{
+		src:     src,
+		aead:    aead,
+		ad:      associatedData(header, ad),
+		prefix:  header[len(header)-prefixLen:],
+		segment: make([]byte, int(size)+aead.Overhead()+1),
+	}, nil
+}
+
+func (r *DecryptReader) Read(p []byte) (int, error) {
+	for r.pos == len(r.plain) {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// open reads and decrypts the next segment.
+func (r *DecryptReader) open() error {
+	if r.counter > maxSegments {
+		return ErrStreamTooLong
+	}
+
+	n, err := io.ReadFull(r.src, r.segment[r.carry:])
+	n += r.carry
+	last := false
+	switch {
+	case err == nil:
+		n--
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	default:
+		return err
+	}
+	if n < r.aead.Overhead() {
+		return ErrStreamTruncated
+	}
+
+	nonce := segmentNonce(r.prefix, r.counter, last)
+	r.plain, err = r.aead.Open(r.plain[:0], nonce, r.segment[:n], r.ad)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt segment %d: %w", r.counter, err)
+	}
+	r.pos = 0
+	r.counter++
+
+	r.done = last
+	r.carry = 0
+	if !last {
+		r.segment[0] = r.segment[n]
+		r.carry = 1
+	}
+	return nil
+}
+
+func segmentNonce(prefix []byte, counter uint64, last bool) []byte {
+	nonce := make([]byte, 0, len(prefix)+segmentNonceLen)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, uint32(counter))
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+func readHeader(src io.Reader, buf []byte) error {
+	if _, err := io.ReadFull(src, buf); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrCiphertextTooShort
+		}
+		return err
+	}
+	return nil
+}
+
+// readMore reads n more header bytes from src.
+func readMore(src io.Reader, header []byte, n int) ([]byte, error) {
+	header = append(header, make([]byte, n)...)
+	if err := readHeader(src, header[len(header)-n:]); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
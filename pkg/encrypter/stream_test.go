@@ -0,0 +1,153 @@
+package encrypter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func encryptStream(t *testing.T, e *Encrypter, plain, ad, key []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := e.NewEncryptWriter(&buf, ad, key)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter: %v", err)
+	}
+	// Odd sized writes cross the segment boundaries.
+	for chunk := range chunks(plain, 1000) {
+		if _, err = w.Write(chunk); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func chunks(b []byte, n int) func(func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		for len(b) > 0 {
+			m := min(n, len(b))
+			if !yield(b[:m]) {
+				return
+			}
+			b = b[m:]
+		}
+	}
+}
+
+func decryptStream(e *Encrypter, ct, ad, key []byte) ([]byte, error) {
+	r, err := e.NewDecryptReader(bytes.NewReader(ct), ad, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := newTestKey(t)
+	sizes := []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 17}
+	for _, algorithm := range algorithms {
+		e := NewEncrypter(testKDFParams, algorithm)
+		for _, size := range sizes {
+			plain := bytes.Repeat([]byte{byte(size)}, size)
+			ct := encryptStream(t, e, plain, []byte("record a"), key)
+			if !IsStream(ct) {
+				t.Fatalf("stream of %d bytes does not start with the stream magic", size)
+			}
+
+			got, err := decryptStream(e, ct, []byte("record a"), key)
+			if err != nil {
+				t.Fatalf("decrypting a stream of %d bytes with algorithm %d: %v", size, algorithm, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("stream of %d bytes with algorithm %d decrypted to %d other bytes", size, algorithm, len(got))
+			}
+			// DecryptMessage opens streams as well.
+			if got, err = e.DecryptMessage(ct, []byte("record a"), key); err != nil || !bytes.Equal(got, plain) {
+				t.Fatalf("DecryptMessage of a stream of %d bytes: %v", size, err)
+			}
+		}
+	}
+}
+
+func TestStreamRejects(t *testing.T) {
+	e := NewEncrypter(testKDFParams, AES256GCM)
+	key := newTestKey(t)
+	plain := bytes.Repeat([]byte("x"), 3*segmentSize)
+	ct := encryptStream(t, e, plain, []byte("record a"), key)
+
+	// AES-GCM has a 12 byte nonce and a 16 byte tag.
+	headerLen := keyHeaderLen + 4 + 12 - segmentNonceLen
+	sealed := segmentSize + 16
+	segment := func(i int) []byte {
+		return ct[headerLen+i*sealed : headerLen+(i+1)*sealed]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	modified := func(i int, change func(b []byte)) []byte {
+		b := bytes.Clone(ct)
+		change(b[i:])
+		return b
+	}
+
+	tests := []struct {
+		name    string
+		ct      []byte
+		ad      string
+		key     []byte
+		wantErr error
+	}{
+		{"wrong key", ct, "record a", newTestKey(t), ErrKeyMismatch},
+		{"other associated data", ct, "record a!", key, nil},
+		{"truncated header", ct[:keyHeaderLen-1], "record a", key, ErrCiphertextTooShort},
+		{"truncated nonce prefix", ct[:headerLen-1], "record a", key, ErrCiphertextTooShort},
+		{"no segments", ct[:headerLen], "record a", key, ErrStreamTruncated},
+		{"final segment dropped", ct[:headerLen+2*sealed], "record a", key, nil},
+		{"final segment cut", ct[:len(ct)-1], "record a", key, nil},
+		{"segments swapped", join(ct[:headerLen], segment(1), segment(0), segment(2)), "record a", key, nil},
+		{"segment repeated", join(ct[:headerLen], segment(0), segment(0), segment(2)), "record a", key, nil},
+		{"trailing data", join(ct, []byte{0}), "record a", key, nil},
+		{"changed segment", modified(headerLen+sealed, func(b []byte) { b[0] ^= 1 }), "record a", key, nil},
+		{"changed segment size", modified(keyHeaderLen, func(b []byte) {
+			binary.BigEndian.PutUint32(b, segmentSize/2)
+		}), "record a", key, nil},
+		{"zero segment size", modified(keyHeaderLen, func(b []byte) {
+			binary.BigEndian.PutUint32(b, 0)
+		}), "record a", key, ErrUnsupportedFormat},
+		{"huge segment size", modified(keyHeaderLen, func(b []byte) {
+			binary.BigEndian.PutUint32(b, maxSegmentSize+1)
+		}), "record a", key, ErrUnsupportedFormat},
+	}
+	for _, tt := range tests {
+		_, err := decryptStream(e, tt.ct, []byte(tt.ad), tt.key)
+		if err == nil {
+			t.Errorf("%s: decrypting succeeded", tt.name)
+			continue
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: decrypting = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestEncryptWriterClosed(t *testing.T) {
+	e := NewEncrypter(testKDFParams, AES256GCM)
+	w, err := e.NewEncryptWriter(io.Discard, nil, newTestKey(t))
+	if err != nil {
+		t.Fatalf("NewEncryptWriter: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err = w.Write([]byte("late")); !errors.Is(err, errWriterClosed) {
+		t.Fatalf("Write after Close = %v, want %v", err, errWriterClosed)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"context"
 	"fmt"
 	"gokeeper/pkg/auth"
 	"gokeeper/pkg/logger"
@@ -66,19 +67,37 @@ func LoggingRequestMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(logFn)
 }
 
+// RevocationChecker reports whether a token was revoked before its expiry.
+type RevocationChecker interface {
+	IsTokenRevoked(ctx context.Context, claims *auth.Claims) (bool, error)
+}
+
 // AuthenticateMiddleware check authorization header
-func AuthenticateMiddleware(authenticator *auth.Authenticator) func(http.Handler) http.Handler {
+func AuthenticateMiddleware(authenticator *auth.Authenticator, revocations RevocationChecker) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			reqHeaderJWT := r.Header.Get("Authorization")
 
-			userID, err := authenticator.GetUserID(reqHeaderJWT)
+			claims, err := authenticator.ParseClaims(reqHeaderJWT)
 			if err != nil {
 				logger.Log.Info("failed to authenticate user", zap.Error(err))
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
-			r.Header.Set("X-User-ID", userID.String())
+			revoked, err := revocations.IsTokenRevoked(r.Context(), claims)
+			if err != nil {
+				logger.Log.Error("failed to check token revocation", zap.Error(err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				logger.Log.Info("revoked token used", zap.String("jti", claims.ID))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			r.Header.Set("X-User-ID", claims.UserID.String())
+			r.Header.Set("X-Token-ID", claims.ID)
+			r.Header.Set("X-Session-ID", claims.SessionID.String())
 			next.ServeHTTP(w, r)
 		})
 	}
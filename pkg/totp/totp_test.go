@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 secret of the RFC 6238 test vectors.
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit codes are their last 6 digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := Code(rfc6238Secret, Step(time.Unix(tt.unix, 0))); got != tt.code {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		code   string
+		step   int64
+		wantOK bool
+	}{
+		{"current step", Code(rfc6238Secret, current), current, true},
+		{"previous step", Code(rfc6238Secret, current-1), current - 1, true},
+		{"next step", Code(rfc6238Secret, current+1), current + 1, true},
+		{"two steps ago", Code(rfc6238Secret, current-2), 0, false},
+		{"two steps ahead", Code(rfc6238Secret, current+2), 0, false},
+		{"short code", Code(rfc6238Secret, current)[1:], 0, false},
+		{"empty code", "", 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := Validate(rfc6238Secret, tt.code, now)
+		if ok != tt.wantOK || step != tt.step {
+			t.Errorf("%s: Validate = %d, %v, want %d, %v", tt.name, step, ok, tt.step, tt.wantOK)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("gokeeper", "alice", rfc6238Secret)
+	for _, want := range []string{
+		"otpauth://totp/gokeeper:alice?",
+		"secret=" + EncodeSecret(rfc6238Secret),
+		"issuer=gokeeper",
+		"digits=6",
+		"period=30",
+	} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("URI %s does not contain %s", uri, want)
+		}
+	}
+}